@@ -2,5 +2,6 @@ setlocal
 set GOOS=linux
 set GOARCH=amd64
 set CGO_ENABLED=1
-go build ./cmd/server/
+go build -tags sqlite_fts5 ./cmd/server/
+go build -tags sqlite_fts5 ./cmd/mdport/
 endlocal
\ No newline at end of file
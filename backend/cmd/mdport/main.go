@@ -0,0 +1,161 @@
+// Command mdport imports and exports posts as Markdown files with front
+// matter, using the server's configuration and database:
+//
+//	mdport import [-author name] [-update] [-draft] <dir|file.zip>
+//	mdport export [-author name] <dir|file.zip>
+//
+// Posts are imported as the given author, the first admin by default. With
+// the memory search engine a running server picks imported posts up from the
+// database within 30 seconds, on the first search after that.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"easyblog/internal/config"
+	"easyblog/internal/controllers/posts"
+	"easyblog/internal/database"
+	"easyblog/internal/mdport"
+	"easyblog/internal/models"
+	"easyblog/internal/search"
+
+	"gorm.io/gorm"
+)
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: mdport import [-author name] [-update] [-draft] <dir|file.zip>")
+	fmt.Fprintln(os.Stderr, "       mdport export [-author name] <dir|file.zip>")
+	os.Exit(2)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	cmd := os.Args[1]
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	author := fs.String("author", "", "username of the author, the first admin by default")
+	update := fs.Bool("update", false, "overwrite posts whose slug already exists")
+	draft := fs.Bool("draft", false, "import every post as a draft")
+	fs.Parse(os.Args[2:])
+	if fs.NArg() != 1 || (cmd != "import" && cmd != "export") {
+		usage()
+	}
+	target := fs.Arg(0)
+
+	appConfig, err := config.Load()
+	if err != nil {
+		log.Fatalf("failed to load config: %v", err)
+	}
+	db, err := database.Initialize(appConfig)
+	if err != nil {
+		log.Fatalf("failed to initialize database: %v", err)
+	}
+	index, err := search.Open(db, appConfig)
+	if err != nil {
+		log.Fatalf("failed to open search index: %v", err)
+	}
+	user, err := findAuthor(db, *author)
+	if err != nil {
+		log.Fatalf("failed to find author: %v", err)
+	}
+	// signing keys are only needed by request handlers
+	h := posts.NewHandler(db, appConfig, nil, index)
+
+	if cmd == "export" {
+		files, err := h.ExportFiles(func(db *gorm.DB) *gorm.DB {
+			if *author == "" {
+				return db
+			}
+			return db.Where("author_id = ?", user.ID)
+		})
+		if err != nil {
+			log.Fatalf("export failed: %v", err)
+		}
+		if err := write(target, files); err != nil {
+			log.Fatalf("export failed: %v", err)
+		}
+		log.Printf("exported %d posts to %s", len(files), target)
+		return
+	}
+
+	files, err := read(target)
+	if err != nil {
+		log.Fatalf("import failed: %v", err)
+	}
+	results := h.ImportFiles(files, posts.ImportOptions{
+		AuthorID:         user.ID,
+		Publish:          !*draft,
+		Update:           *update,
+		UpdateAny:        true,
+		CreateTags:       true,
+		CreateCategories: true,
+	})
+	counts := map[string]int{}
+	for _, r := range results {
+		counts[r.Status]++
+		if r.Error != "" {
+			fmt.Printf("%-8s %s: %s\n", r.Status, r.File, r.Error)
+			continue
+		}
+		fmt.Printf("%-8s %s (post %d)\n", r.Status, r.File, r.PostID)
+	}
+	log.Printf("%d created, %d updated, %d skipped, %d failed",
+		counts["created"], counts["updated"], counts["skipped"], counts["failed"])
+	if counts["failed"] > 0 {
+		os.Exit(1)
+	}
+}
+
+func findAuthor(db *gorm.DB, username string) (models.User, error) {
+	var user models.User
+	q := db.Order("id")
+	if username != "" {
+		q = q.Where("username = ?", username)
+	} else {
+		q = q.Where("role = ?", models.RoleAdmin)
+	}
+	if err := q.First(&user).Error; err != nil {
+		return user, err
+	}
+	return user, nil
+}
+
+func isZip(target string) bool {
+	return strings.HasSuffix(strings.ToLower(target), ".zip")
+}
+
+func read(target string) ([]mdport.File, error) {
+	if !isZip(target) {
+		return mdport.ReadDir(target)
+	}
+	f, err := os.Open(target)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return mdport.ReadZip(f, info.Size())
+}
+
+func write(target string, files []mdport.File) error {
+	if !isZip(target) {
+		return mdport.WriteDir(target, files)
+	}
+	f, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+	if err := mdport.WriteZip(f, files); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -1,11 +1,18 @@
 package main
 
 import (
+	"context"
 	"easyblog/internal/config"
 	"easyblog/internal/database"
+	"easyblog/internal/keys"
+	"easyblog/internal/mailer"
+	"easyblog/internal/related"
+	"easyblog/internal/scheduler"
+	"easyblog/internal/search"
 	"easyblog/internal/server"
 	"log"
 	"net/http"
+	"time"
 )
 
 func main() {
@@ -29,8 +36,30 @@ func main() {
 		log.Fatalf("database ping failed: %v", err)
 	}
 
+	ks, err := keys.Load(db, appConfig)
+	if err != nil {
+		log.Fatalf("failed to load signing keys: %v", err)
+	}
+
+	mail, err := mailer.New(appConfig.Mail)
+	if err != nil {
+		log.Fatalf("failed to initialize mailer: %v", err)
+	}
+
+	index, err := search.Open(db, appConfig)
+	if err != nil {
+		log.Fatalf("failed to open search index: %v", err)
+	}
+	if err := related.CatchUp(db); err != nil {
+		log.Fatalf("failed to index related posts: %v", err)
+	}
+
+	// publishes and unpublishes scheduled posts
+	interval := time.Duration(appConfig.Server.Posts.SchedulerIntervalSecond) * time.Second
+	go scheduler.New(db, interval).Run(context.Background())
+
 	// Setup HTTP server
-	r := server.NewRouter(appConfig, db)
+	r := server.NewRouter(appConfig, db, ks, index, mail)
 	srv := &http.Server{
 		Addr:    appConfig.Server.Address,
 		Handler: r,
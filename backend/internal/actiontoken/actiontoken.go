@@ -0,0 +1,84 @@
+// Package actiontoken issues signed, expiring tokens for one-off actions
+// such as email verification or password reset. They are internal tokens of
+// the key set, so services trusting the published JWKS never accept them.
+package actiontoken
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"easyblog/internal/keys"
+	"easyblog/internal/models"
+	"easyblog/internal/utils"
+
+	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/gorm"
+)
+
+type Purpose string
+
+const (
+	PurposeVerifyEmail   Purpose = "verify_email"
+	PurposeResetPassword Purpose = "reset_password"
+	PurposeMFALogin      Purpose = "mfa_login"
+	PurposePostPreview   Purpose = "post_preview"
+	PurposePostUnlock    Purpose = "post_unlock"
+)
+
+var (
+	ErrInvalid  = errors.New("invalid or expired token")
+	ErrConsumed = errors.New("token already used")
+)
+
+type Claims struct {
+	Purpose Purpose `json:"pur"`
+	Email   string  `json:"email,omitempty"`
+	// binds the token to some state of the subject, e.g. the password hash
+	Fingerprint string `json:"fp,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// Issue signs claims for purpose, valid for ttl
+func Issue(ks *keys.KeySet, purpose Purpose, claims Claims, ttl time.Duration) (string, error) {
+	jti := make([]byte, 16)
+	if _, err := rand.Read(jti); err != nil {
+		return "", err
+	}
+	now := time.Now()
+	claims.Purpose = purpose
+	claims.ID = hex.EncodeToString(jti)
+	claims.IssuedAt = jwt.NewNumericDate(now)
+	claims.ExpiresAt = jwt.NewNumericDate(now.Add(ttl))
+	return ks.SignInternal(claims)
+}
+
+// Parse validates signature, expiry and purpose of a token
+func Parse(ks *keys.KeySet, purpose Purpose, token string) (*Claims, error) {
+	var claims Claims
+	t, err := ks.ParseInternal(token, &claims, jwt.WithExpirationRequired())
+	if err != nil || !t.Valid || claims.Purpose != purpose || claims.ID == "" {
+		return nil, ErrInvalid
+	}
+	return &claims, nil
+}
+
+// Consume marks a token as used so it cannot be replayed
+func Consume(db *gorm.DB, claims *Claims) error {
+	used := models.UsedToken{JTI: claims.ID, ExpiresAt: claims.ExpiresAt.Time}
+	if err := db.Create(&used).Error; err != nil {
+		var count int64
+		db.Model(&models.UsedToken{}).Where("jti = ?", claims.ID).Count(&count)
+		if count > 0 {
+			return ErrConsumed
+		}
+		return err
+	}
+	return nil
+}
+
+// Fingerprint derives a short value that changes whenever s changes
+func Fingerprint(s string) string {
+	return utils.SHA256Encode(s)[:16]
+}
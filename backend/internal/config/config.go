@@ -9,9 +9,71 @@ import (
 type ServerConfig struct {
 	Address string `mapstructure:"address"`
 	JWT     struct {
-		Secret     string `mapstructure:"secret"`
-		ExpireHour int    `mapstructure:"expire_hour"`
+		Algorithm          string `mapstructure:"algorithm"` // EdDSA 、 RS256 、 HS256
+		Secret             string `mapstructure:"secret"`    // only for HS256
+		Issuer             string `mapstructure:"issuer"`    // iss of access tokens
+		AccessExpireMinute int    `mapstructure:"access_expire_minute"`
+		RefreshExpireHour  int    `mapstructure:"refresh_expire_hour"`
+		// rotated keys still verify tokens for this long
+		RetiredKeyHour int      `mapstructure:"retired_key_hour"`
+		Keys           []JWTKey `mapstructure:"keys"`
 	} `mapstructure:"jwt"`
+	Password PasswordConfig `mapstructure:"password"`
+	Auth     struct {
+		// off 、 comment 、 login (login implies comment)
+		RequireVerifiedEmail      string              `mapstructure:"require_verified_email"`
+		VerifyEmailExpireHour     int                 `mapstructure:"verify_email_expire_hour"`
+		ResetPasswordExpireMinute int                 `mapstructure:"reset_password_expire_minute"`
+		Throttle                  LoginThrottleConfig `mapstructure:"throttle"`
+	} `mapstructure:"auth"`
+	Upload struct {
+		Dir         string `mapstructure:"dir"`
+		URLPrefix   string `mapstructure:"url_prefix"`
+		MaxAvatarKB int64  `mapstructure:"max_avatar_kb"`
+	} `mapstructure:"upload"`
+	Posts struct {
+		// revisions kept per post, oldest are pruned; 0 keeps all
+		MaxRevisions int `mapstructure:"max_revisions"`
+		// how often scheduled publishing runs
+		SchedulerIntervalSecond int `mapstructure:"scheduler_interval_second"`
+		// lifetime of draft preview links
+		PreviewExpireHour int `mapstructure:"preview_expire_hour"`
+		// how long unlocking a password protected post lasts
+		UnlockExpireMinute int `mapstructure:"unlock_expire_minute"`
+		// wrong post passwords, counted per client ip and post;
+		// ip_max_failures is not used
+		UnlockThrottle LoginThrottleConfig `mapstructure:"unlock_throttle"`
+		// how long related posts are cached, edits recompute them sooner
+		RelatedCacheHour int `mapstructure:"related_cache_hour"`
+		// also rank related posts by TF-IDF similarity of their text
+		RelatedText bool `mapstructure:"related_text"`
+		// largest upload accepted by Markdown import
+		ImportMaxMB int64 `mapstructure:"import_max_mb"`
+	} `mapstructure:"posts"`
+	Search struct {
+		// auto uses the database's full-text search, memory an in-process index
+		Engine string `mapstructure:"engine"`
+	} `mapstructure:"search"`
+}
+
+// JWTKey is a PEM key pair on disk. The first key with a private key signs,
+// the others only verify. Without any, keys are generated and stored in the database.
+type JWTKey struct {
+	Kid            string `mapstructure:"kid"`
+	PrivateKeyFile string `mapstructure:"private_key_file"`
+	PublicKeyFile  string `mapstructure:"public_key_file"`
+}
+
+type PasswordConfig struct {
+	Algorithm  string `mapstructure:"algorithm"` // argon2id 、 bcrypt
+	BcryptCost int    `mapstructure:"bcrypt_cost"`
+	Argon2     struct {
+		Time    uint32 `mapstructure:"time"`
+		Memory  uint32 `mapstructure:"memory"` // KiB
+		Threads uint8  `mapstructure:"threads"`
+		KeyLen  uint32 `mapstructure:"key_len"`
+		SaltLen uint32 `mapstructure:"salt_len"`
+	} `mapstructure:"argon2"`
 }
 
 type DatabaseConfig struct {
@@ -26,9 +88,62 @@ type DatabaseConfig struct {
 	Timezone string `mapstructure:"timezone"`
 }
 
+type LoginThrottleConfig struct {
+	MaxFailures    int `mapstructure:"max_failures"`    // per email before lockout
+	IPMaxFailures  int `mapstructure:"ip_max_failures"` // per client ip before lockout
+	BackoffSeconds int `mapstructure:"backoff_seconds"` // first delay, doubled on every failure
+	LockoutMinutes int `mapstructure:"lockout_minutes"`
+	ResetHour      int `mapstructure:"reset_hour"` // failures older than this are forgotten
+}
+
+type MailConfig struct {
+	Driver  string `mapstructure:"driver"` // smtp 、 file 、 log
+	From    string `mapstructure:"from"`
+	BaseURL string `mapstructure:"base_url"` // frontend url used in links
+	FileDir string `mapstructure:"file_dir"` // for file driver
+	SMTP    struct {
+		Host     string `mapstructure:"host"`
+		Port     int    `mapstructure:"port"`
+		Username string `mapstructure:"username"`
+		Password string `mapstructure:"password"`
+		TLS      bool   `mapstructure:"tls"` // implicit TLS, otherwise STARTTLS when offered
+	} `mapstructure:"smtp"`
+	Templates map[string]MailTemplate `mapstructure:"templates"`
+}
+
+type MailTemplate struct {
+	Subject  string `mapstructure:"subject"`
+	Body     string `mapstructure:"body"`
+	BodyFile string `mapstructure:"body_file"`
+}
+
+type OIDCConfig struct {
+	// SPA route receiving tokens in the url fragment after a successful login
+	FrontendCallback string         `mapstructure:"frontend_callback"`
+	Providers        []OIDCProvider `mapstructure:"providers"`
+}
+
+type OIDCProvider struct {
+	Name         string   `mapstructure:"name"` // used in urls
+	DisplayName  string   `mapstructure:"display_name"`
+	Issuer       string   `mapstructure:"issuer"`
+	ClientID     string   `mapstructure:"client_id"`
+	ClientSecret string   `mapstructure:"client_secret"` // empty for public clients
+	RedirectURL  string   `mapstructure:"redirect_url"`  // .../api/auth/oidc/<name>/callback
+	Scopes       []string `mapstructure:"scopes"`
+	// create a local user on first login
+	AutoProvision bool `mapstructure:"auto_provision"`
+	// attach to an existing user with the same email, verified both by the
+	// provider and locally
+	LinkByEmail bool   `mapstructure:"link_by_email"`
+	DefaultRole string `mapstructure:"default_role"`
+}
+
 type Config struct {
 	Server   ServerConfig   `mapstructure:"server"`
 	Database DatabaseConfig `mapstructure:"database"`
+	Mail     MailConfig     `mapstructure:"mail"`
+	OIDC     OIDCConfig     `mapstructure:"oidc"`
 }
 
 func Load() (*Config, error) {
@@ -41,7 +156,47 @@ func Load() (*Config, error) {
 
 	// Defaults
 	v.SetDefault("server.address", ":7966")
-	v.SetDefault("server.jwt.expire_hour", 72)
+	v.SetDefault("server.jwt.algorithm", "EdDSA")
+	v.SetDefault("server.jwt.retired_key_hour", 72)
+	v.SetDefault("server.jwt.issuer", "easyblog")
+	v.SetDefault("server.jwt.access_expire_minute", 15)
+	v.SetDefault("server.jwt.refresh_expire_hour", 720)
+	v.SetDefault("server.auth.require_verified_email", "off")
+	v.SetDefault("server.auth.verify_email_expire_hour", 48)
+	v.SetDefault("server.auth.reset_password_expire_minute", 30)
+	v.SetDefault("server.auth.throttle.max_failures", 5)
+	v.SetDefault("server.auth.throttle.ip_max_failures", 20)
+	v.SetDefault("server.auth.throttle.backoff_seconds", 1)
+	v.SetDefault("server.auth.throttle.lockout_minutes", 15)
+	v.SetDefault("server.auth.throttle.reset_hour", 24)
+	v.SetDefault("server.upload.dir", "uploads")
+	v.SetDefault("server.upload.url_prefix", "/uploads")
+	v.SetDefault("server.upload.max_avatar_kb", 2048)
+	v.SetDefault("server.posts.max_revisions", 50)
+	v.SetDefault("server.posts.scheduler_interval_second", 30)
+	v.SetDefault("server.posts.preview_expire_hour", 72)
+	v.SetDefault("server.posts.unlock_expire_minute", 60)
+	v.SetDefault("server.posts.unlock_throttle.max_failures", 10)
+	v.SetDefault("server.posts.unlock_throttle.backoff_seconds", 1)
+	v.SetDefault("server.posts.unlock_throttle.lockout_minutes", 15)
+	v.SetDefault("server.posts.unlock_throttle.reset_hour", 24)
+	v.SetDefault("server.posts.related_cache_hour", 24)
+	v.SetDefault("server.posts.related_text", true)
+	v.SetDefault("server.posts.import_max_mb", 32)
+	v.SetDefault("server.search.engine", "auto")
+	v.SetDefault("server.password.algorithm", "argon2id")
+	v.SetDefault("server.password.bcrypt_cost", 12)
+	v.SetDefault("server.password.argon2.time", 3)
+	v.SetDefault("server.password.argon2.memory", 64*1024)
+	v.SetDefault("server.password.argon2.threads", 2)
+	v.SetDefault("server.password.argon2.key_len", 32)
+	v.SetDefault("server.password.argon2.salt_len", 16)
+	v.SetDefault("mail.driver", "log")
+	v.SetDefault("mail.from", "EasyBlog <noreply@easyblog.com>")
+	v.SetDefault("mail.base_url", "http://localhost:5173")
+	v.SetDefault("mail.file_dir", "mails")
+	v.SetDefault("mail.smtp.port", 587)
+	v.SetDefault("oidc.frontend_callback", "http://localhost:5173/oidc/callback")
 	v.SetDefault("database.driver", "sqlite")
 	v.SetDefault("database.host", "127.0.0.1")
 	v.SetDefault("database.port", 5432)
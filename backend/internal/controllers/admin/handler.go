@@ -0,0 +1,316 @@
+package admin
+
+import (
+	"errors"
+	"math"
+	"net/http"
+
+	"easyblog/internal/config"
+	"easyblog/internal/keys"
+	"easyblog/internal/models"
+	"easyblog/internal/rbac"
+	"easyblog/internal/session"
+	"easyblog/internal/throttle"
+	"easyblog/internal/utils"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type Handler struct {
+	db   *gorm.DB
+	cfg  *config.Config
+	keys *keys.KeySet
+}
+
+func NewHandler(db *gorm.DB, cfg *config.Config, ks *keys.KeySet) *Handler {
+	return &Handler{db: db, cfg: cfg, keys: ks}
+}
+
+func (h *Handler) Roles(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"data": rbac.RolePermissions})
+}
+
+func (h *Handler) ListUsers(c *gin.Context) {
+	var users []models.User
+	q := h.db.Model(&models.User{})
+	if k := c.Query("q"); k != "" {
+		like := "%" + k + "%"
+		q = q.Where("username LIKE ? OR email LIKE ?", like, like)
+	}
+	if role := c.Query("role"); role != "" {
+		q = q.Where("role = ?", role)
+	}
+	switch c.Query("status") {
+	case "pending":
+		q = q.Where("pending = ?", true)
+	case "disabled":
+		q = q.Where("disabled = ?", true)
+	case "active":
+		q = q.Where("pending = ? AND disabled = ?", false, false)
+	}
+	page := utils.QueryInt(c, "page", 0, 0, math.MaxInt)
+	size := utils.QueryInt(c, "size", 10, 1, 50)
+
+	var total int64
+	q.Count(&total)
+	if err := q.Limit(size).Offset(page * size).Order("id ASC").Find(&users).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": users, "total": total, "page": page, "size": size})
+}
+
+func (h *Handler) GetUser(c *gin.Context) {
+	user, ok := h.loadUser(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": user})
+}
+
+type updateRoleReq struct {
+	Role models.UserRole `json:"role" binding:"required"`
+}
+
+func (h *Handler) UpdateRole(c *gin.Context) {
+	var req updateRoleReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !rbac.ValidRole(req.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unknown role"})
+		return
+	}
+	user, ok := h.loadUser(c)
+	if !ok || !h.notSelf(c, user) {
+		return
+	}
+	if err := h.db.Model(&user).Update("role", req.Role).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"result": "ok"})
+}
+
+type setDisabledReq struct {
+	Disabled bool `json:"disabled"`
+}
+
+func (h *Handler) SetDisabled(c *gin.Context) {
+	var req setDisabledReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	user, ok := h.loadUser(c)
+	if !ok || !h.notSelf(c, user) {
+		return
+	}
+	if err := h.db.Model(&user).Update("disabled", req.Disabled).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Disabled {
+		if err := session.NewManager(h.db, h.cfg, h.keys).RevokeAll(user.ID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"result": "ok"})
+}
+
+type resetPasswordReq struct {
+	Password string `json:"password" binding:"required,min=64,max=64"` // sha256 hex
+}
+
+// ResetPassword assigns a temporary password and signs the user out everywhere
+func (h *Handler) ResetPassword(c *gin.Context) {
+	var req resetPasswordReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	user, ok := h.loadUser(c)
+	if !ok {
+		return
+	}
+	hash, err := utils.HashPassword(h.cfg.Server.Password, req.Password)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to hash password"})
+		return
+	}
+	err = h.db.Model(&user).Updates(map[string]interface{}{
+		"password":             hash,
+		"must_change_password": true,
+	}).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := session.NewManager(h.db, h.cfg, h.keys).RevokeAll(user.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"result": "ok"})
+}
+
+func (h *Handler) LogoutUser(c *gin.Context) {
+	user, ok := h.loadUser(c)
+	if !ok {
+		return
+	}
+	if err := session.NewManager(h.db, h.cfg, h.keys).RevokeAll(user.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"result": "ok"})
+}
+
+// ResetTOTP turns off two-factor authentication for a user who lost their device
+func (h *Handler) ResetTOTP(c *gin.Context) {
+	user, ok := h.loadUser(c)
+	if !ok {
+		return
+	}
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Updates(map[string]interface{}{
+			"totp_enabled": false,
+			"totp_secret":  "",
+		}).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", user.ID).Delete(&models.RecoveryCode{}).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"result": "ok"})
+}
+
+// Unlock clears failed login attempts recorded for the user's email
+func (h *Handler) Unlock(c *gin.Context) {
+	user, ok := h.loadUser(c)
+	if !ok {
+		return
+	}
+	if err := throttle.NewGuard(h.db, h.cfg.Server.Auth.Throttle).Reset(throttle.EmailKey(user.Email)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"result": "ok"})
+}
+
+func (h *Handler) ListLoginAttempts(c *gin.Context) {
+	var attempts []models.LoginAttempt
+	page := utils.QueryInt(c, "page", 0, 0, math.MaxInt)
+	size := utils.QueryInt(c, "size", 10, 1, 50)
+	var total int64
+	h.db.Model(&models.LoginAttempt{}).Count(&total)
+	if err := h.db.Order("last_failure_at DESC").Limit(size).Offset(page * size).Find(&attempts).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": attempts, "total": total, "page": page, "size": size})
+}
+
+func (h *Handler) DeleteLoginAttempt(c *gin.Context) {
+	if err := h.db.Delete(&models.LoginAttempt{}, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"result": "ok"})
+}
+
+// DeleteUser removes a user. Their posts and comments are either
+// reassigned (?content=reassign&reassign_to=ID) or deleted (?content=delete).
+func (h *Handler) DeleteUser(c *gin.Context) {
+	user, ok := h.loadUser(c)
+	if !ok || !h.notSelf(c, user) {
+		return
+	}
+	mode := c.DefaultQuery("content", "reassign")
+	var target models.User
+	switch mode {
+	case "reassign":
+		if err := h.db.First(&target, c.Query("reassign_to")).Error; err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "reassign_to user not found"})
+			return
+		}
+		if target.ID == user.ID {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "cannot reassign to the deleted user"})
+			return
+		}
+	case "delete":
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "content must be reassign or delete"})
+		return
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if mode == "reassign" {
+			if err := tx.Model(&models.Post{}).Where("author_id = ?", user.ID).Update("author_id", target.ID).Error; err != nil {
+				return err
+			}
+			if err := tx.Model(&models.Comment{}).Where("user_id = ?", user.ID).Update("user_id", target.ID).Error; err != nil {
+				return err
+			}
+		} else {
+			var postIDs []uint
+			if err := tx.Model(&models.Post{}).Where("author_id = ?", user.ID).Pluck("id", &postIDs).Error; err != nil {
+				return err
+			}
+			if len(postIDs) > 0 {
+				if err := tx.Where("post_id IN ?", postIDs).Delete(&models.Comment{}).Error; err != nil {
+					return err
+				}
+				if err := tx.Delete(&models.Post{}, postIDs).Error; err != nil {
+					return err
+				}
+			}
+			if err := tx.Where("user_id = ?", user.ID).Delete(&models.Comment{}).Error; err != nil {
+				return err
+			}
+		}
+		if err := session.NewManager(tx, h.cfg, h.keys).RevokeAll(user.ID); err != nil {
+			return err
+		}
+		if err := tx.Model(&user).UpdateColumns(map[string]interface{}{
+			"username": models.ReleasedName(user.ID, user.Username, 64),
+			"email":    models.ReleasedName(user.ID, user.Email, 128),
+		}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&user).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"result": "ok"})
+}
+
+func (h *Handler) loadUser(c *gin.Context) (models.User, bool) {
+	var user models.User
+	if err := h.db.First(&user, c.Param("id")).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return user, false
+	}
+	return user, true
+}
+
+// notSelf stops admins from demoting, disabling or deleting themselves
+func (h *Handler) notSelf(c *gin.Context, user models.User) bool {
+	current := c.MustGet("user").(models.User)
+	if current.ID == user.ID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "cannot perform this action on yourself"})
+		return false
+	}
+	return true
+}
@@ -0,0 +1,124 @@
+package admin
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"math"
+	"net/http"
+	"time"
+
+	"easyblog/internal/models"
+	"easyblog/internal/rbac"
+	"easyblog/internal/utils"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+func (h *Handler) ListInvites(c *gin.Context) {
+	page := utils.QueryInt(c, "page", 0, 0, math.MaxInt)
+	size := utils.QueryInt(c, "size", 10, 1, 50)
+	var total int64
+	h.db.Model(&models.Invite{}).Count(&total)
+	var invites []models.Invite
+	if err := h.db.Order("id DESC").Limit(size).Offset(page * size).Find(&invites).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": invites, "total": total, "page": page, "size": size})
+}
+
+type createInviteReq struct {
+	Note          string          `json:"note" binding:"max=255"`
+	Role          models.UserRole `json:"role"`
+	MaxUses       int             `json:"max_uses" binding:"min=0,max=10000"`       // 0 is unlimited
+	ExpiresInDays int             `json:"expires_in_days" binding:"min=0,max=3650"` // 0 never expires
+}
+
+// CreateInvite returns the plain code once; only its hash is kept
+func (h *Handler) CreateInvite(c *gin.Context) {
+	req := createInviteReq{MaxUses: 1}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Role != "" && !rbac.ValidRole(req.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unknown role"})
+		return
+	}
+	raw := make([]byte, 15)
+	if _, err := rand.Read(raw); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	code := base32.StdEncoding.EncodeToString(raw)
+	invite := models.Invite{
+		CodeHash:  utils.SHA256Encode(code),
+		Prefix:    code[:6],
+		Note:      req.Note,
+		Role:      req.Role,
+		MaxUses:   req.MaxUses,
+		CreatedBy: c.MustGet("user").(models.User).ID,
+	}
+	if req.ExpiresInDays > 0 {
+		t := time.Now().AddDate(0, 0, req.ExpiresInDays)
+		invite.ExpiresAt = &t
+	}
+	if err := h.db.Create(&invite).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"code": code, "data": invite})
+}
+
+func (h *Handler) DeleteInvite(c *gin.Context) {
+	res := h.db.Delete(&models.Invite{}, c.Param("id"))
+	if res.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": res.Error.Error()})
+		return
+	}
+	if res.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "invite not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"result": "ok"})
+}
+
+func (h *Handler) ApproveUser(c *gin.Context) {
+	user, ok := h.loadPending(c)
+	if !ok {
+		return
+	}
+	if err := h.db.Model(&user).Update("pending", false).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"result": "ok"})
+}
+
+// RejectUser removes a pending registration for good, freeing its username and email
+func (h *Handler) RejectUser(c *gin.Context) {
+	user, ok := h.loadPending(c)
+	if !ok {
+		return
+	}
+	if err := h.db.Unscoped().Delete(&user).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"result": "ok"})
+}
+
+func (h *Handler) loadPending(c *gin.Context) (models.User, bool) {
+	var user models.User
+	if err := h.db.Where("pending = ?", true).First(&user, c.Param("id")).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "no pending registration for this user"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return user, false
+	}
+	return user, true
+}
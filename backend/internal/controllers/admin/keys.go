@@ -0,0 +1,46 @@
+package admin
+
+import (
+	"errors"
+	"net/http"
+
+	"easyblog/internal/keys"
+
+	"github.com/gin-gonic/gin"
+)
+
+func (h *Handler) ListKeys(c *gin.Context) {
+	signing := h.keys.SigningKid()
+	items := make([]gin.H, 0)
+	for _, k := range h.keys.Keys() {
+		if k.Algorithm == keys.AlgHS256 {
+			continue
+		}
+		items = append(items, gin.H{
+			"kid":        k.Kid,
+			"algorithm":  k.Algorithm,
+			"signing":    k.Kid == signing,
+			"can_sign":   k.Private != nil,
+			"created_at": k.CreatedAt,
+			"retired_at": k.RetiredAt,
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"algorithm": h.keys.Algorithm(),
+		"managed":   h.keys.Managed(),
+		"items":     items,
+	})
+}
+
+// RotateKeys switches to a freshly generated signing key
+func (h *Handler) RotateKeys(c *gin.Context) {
+	if err := h.keys.Rotate(); err != nil {
+		if errors.Is(err, keys.ErrStaticKeys) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"kid": h.keys.SigningKid()})
+}
@@ -0,0 +1,218 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"easyblog/internal/actiontoken"
+	"easyblog/internal/mailer"
+	"easyblog/internal/models"
+	"easyblog/internal/session"
+	"easyblog/internal/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// sendMail renders and delivers a template in the background
+func (h *Handler) sendMail(name string, user models.User, path string, token string, ttl time.Duration) {
+	siteName := "Easy Blog"
+	var cf models.ConfigModel
+	if err := h.db.Where("key = ?", "sites_name").First(&cf).Error; err == nil {
+		siteName = cf.Value
+	}
+	link := strings.TrimRight(h.cfg.Mail.BaseURL, "/") + path + "?token=" + url.QueryEscape(token)
+	msg, err := mailer.Render(h.cfg.Mail, name, user.Email, mailer.TemplateData{
+		SiteName:  siteName,
+		Username:  user.Username,
+		Link:      link,
+		ExpiresIn: ttl.String(),
+	})
+	if err != nil {
+		log.Printf("render mail %s: %v", name, err)
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := h.mail.Send(ctx, msg); err != nil {
+			log.Printf("send mail %s to %s: %v", name, user.Email, err)
+		}
+	}()
+}
+
+// sendVerification mails a verification link for address, the current or
+// the pending address of user
+func (h *Handler) sendVerification(user models.User, address string) error {
+	ttl := time.Duration(h.cfg.Server.Auth.VerifyEmailExpireHour) * time.Hour
+	token, err := actiontoken.Issue(h.keys, actiontoken.PurposeVerifyEmail, actiontoken.Claims{
+		Email:            address,
+		RegisteredClaims: jwtSubject(user.ID),
+	}, ttl)
+	if err != nil {
+		return err
+	}
+	user.Email = address
+	h.sendMail(mailer.TemplateVerifyEmail, user, "/verify-email", token, ttl)
+	return nil
+}
+
+// SendVerification mails the verification link again, to the pending
+// address when there is one
+func (h *Handler) SendVerification(c *gin.Context) {
+	user := c.MustGet("user").(models.User)
+	address := user.Email
+	if user.PendingEmail != "" {
+		address = user.PendingEmail
+	} else if user.EmailVerified {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "email already verified"})
+		return
+	}
+	if err := h.sendVerification(user, address); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"result": "ok"})
+}
+
+type tokenRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+func (h *Handler) VerifyEmail(c *gin.Context) {
+	var req tokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	claims, err := actiontoken.Parse(h.keys, actiontoken.PurposeVerifyEmail, req.Token)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var user models.User
+	// the link is for the current address or the pending one, either may
+	// have changed since the mail was sent
+	if err := h.db.First(&user, subjectID(claims)).Error; err != nil ||
+		claims.Email != user.Email && (user.PendingEmail == "" || claims.Email != user.PendingEmail) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": actiontoken.ErrInvalid.Error()})
+		return
+	}
+	if err := actiontoken.Consume(h.db, claims); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	updates := map[string]interface{}{"email_verified": true}
+	if claims.Email == user.PendingEmail {
+		// the pending address is proven, it replaces the current one
+		var taken int64
+		if err := h.db.Model(&models.User{}).Where("email = ? AND id <> ?", claims.Email, user.ID).Count(&taken).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if taken > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "email already in use"})
+			return
+		}
+		updates["email"], updates["pending_email"] = claims.Email, ""
+	}
+	if err := h.db.Model(&user).Updates(updates).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"result": "ok"})
+}
+
+type forgotPasswordRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+// ForgotPassword always answers ok so it cannot be used to probe for accounts
+func (h *Handler) ForgotPassword(c *gin.Context) {
+	var req forgotPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var user models.User
+	if err := h.db.Where("email = ?", req.Email).First(&user).Error; err == nil && !user.Disabled {
+		ttl := time.Duration(h.cfg.Server.Auth.ResetPasswordExpireMinute) * time.Minute
+		token, err := actiontoken.Issue(h.keys, actiontoken.PurposeResetPassword, actiontoken.Claims{
+			Fingerprint:      actiontoken.Fingerprint(user.Password),
+			RegisteredClaims: jwtSubject(user.ID),
+		}, ttl)
+		if err != nil {
+			log.Printf("issue reset token: %v", err)
+		} else {
+			h.sendMail(mailer.TemplateResetPassword, user, "/reset-password", token, ttl)
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"result": "ok"})
+}
+
+type resetPasswordRequest struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password" binding:"required,min=64,max=64"` // sha256 hex
+}
+
+func (h *Handler) ResetPassword(c *gin.Context) {
+	var req resetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	claims, err := actiontoken.Parse(h.keys, actiontoken.PurposeResetPassword, req.Token)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var user models.User
+	// a changed password invalidates every outstanding reset link
+	if err := h.db.First(&user, subjectID(claims)).Error; err != nil || actiontoken.Fingerprint(user.Password) != claims.Fingerprint {
+		c.JSON(http.StatusBadRequest, gin.H{"error": actiontoken.ErrInvalid.Error()})
+		return
+	}
+	if err := actiontoken.Consume(h.db, claims); err != nil {
+		if errors.Is(err, actiontoken.ErrConsumed) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+	hash, err := utils.HashPassword(h.cfg.Server.Password, req.Password)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to hash password"})
+		return
+	}
+	// the mail reached the inbox, so the address is proven too
+	err = h.db.Model(&user).Updates(map[string]interface{}{
+		"password":             hash,
+		"must_change_password": false,
+		"email_verified":       true,
+	}).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := session.NewManager(h.db, h.cfg, h.keys).RevokeAll(user.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"result": "ok"})
+}
+
+func jwtSubject(id uint) jwt.RegisteredClaims {
+	return jwt.RegisteredClaims{Subject: strconv.FormatUint(uint64(id), 10)}
+}
+
+func subjectID(claims *actiontoken.Claims) uint {
+	id, _ := strconv.ParseUint(claims.Subject, 10, 64)
+	return uint(id)
+}
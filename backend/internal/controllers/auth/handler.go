@@ -1,30 +1,50 @@
 package auth
 
 import (
+	"errors"
+	"log"
+	"math"
 	"net/http"
+	"strconv"
+	"sync"
 	"time"
 
+	"easyblog/internal/actiontoken"
 	"easyblog/internal/config"
+	"easyblog/internal/keys"
+	"easyblog/internal/mailer"
 	"easyblog/internal/models"
+	"easyblog/internal/oidc"
+	"easyblog/internal/session"
+	"easyblog/internal/throttle"
+	"easyblog/internal/utils"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v5"
 	"gorm.io/gorm"
 )
 
 type Handler struct {
-	db  *gorm.DB
-	cfg *config.Config
+	db        *gorm.DB
+	cfg       *config.Config
+	keys      *keys.KeySet
+	mail      mailer.Mailer
+	providers map[string]*oidc.Provider
 }
 
-func NewHandler(db *gorm.DB, cfg *config.Config) *Handler {
-	return &Handler{db: db, cfg: cfg}
+func NewHandler(db *gorm.DB, cfg *config.Config, ks *keys.KeySet, mail mailer.Mailer) *Handler {
+	providers := make(map[string]*oidc.Provider, len(cfg.OIDC.Providers))
+	for _, p := range cfg.OIDC.Providers {
+		providers[p.Name] = oidc.NewProvider(p)
+	}
+	return &Handler{db: db, cfg: cfg, keys: ks, mail: mail, providers: providers}
 }
 
 type registerRequest struct {
 	Username string `json:"username" binding:"required,min=3,max=64"`
 	Email    string `json:"email" binding:"required,email"`
 	Password string `json:"password" binding:"required,min=64,max=64"` // sha256 hex
+	// required in invite mode, skips approval in approval mode
+	InviteCode string `json:"invite_code" binding:"omitempty,max=64"`
 }
 
 func (h *Handler) Register(c *gin.Context) {
@@ -43,12 +63,43 @@ func (h *Handler) Register(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	user := models.User{Username: req.Username, Email: req.Email, Password: req.Password}
-	if err := h.db.Create(&user).Error; err != nil {
+	mode := h.registerMode()
+	if mode == RegisterInvite && req.InviteCode == "" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "invite code required"})
+		return
+	}
+	hash, err := utils.HashPassword(h.cfg.Server.Password, req.Password)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to hash password"})
+		return
+	}
+	user := models.User{Username: req.Username, Email: req.Email, Password: hash}
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if req.InviteCode != "" {
+			invite, err := redeemInvite(tx, req.InviteCode)
+			if err != nil {
+				return err
+			}
+			if invite.Role != "" {
+				user.Role = invite.Role
+			}
+		} else if mode == RegisterApproval {
+			user.Pending = true
+		}
+		return tx.Create(&user).Error
+	})
+	if err != nil {
+		if errors.Is(err, errInvalidInvite) {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	c.JSON(http.StatusCreated, gin.H{"id": user.ID, "username": user.Username, "email": user.Email})
+	if err := h.sendVerification(user, user.Email); err != nil {
+		log.Printf("send verification mail: %v", err)
+	}
+	c.JSON(http.StatusCreated, gin.H{"id": user.ID, "username": user.Username, "email": user.Email, "pending": user.Pending})
 }
 
 type loginRequest struct {
@@ -62,28 +113,143 @@ func (h *Handler) Login(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	guard := throttle.NewGuard(h.db, h.cfg.Server.Auth.Throttle)
+	keys := []string{throttle.EmailKey(req.Email), throttle.IPKey(c.ClientIP())}
+	// the attempt counts as a failure until the password matched
+	if wait, err := guard.Reserve(keys...); err != nil || wait > 0 {
+		tooManyAttempts(c, wait, err)
+		return
+	}
 	var user models.User
-	if err := h.db.Where("email = ?", req.Email).First(&user).Error; err != nil {
+	found := h.db.Where("email = ?", req.Email).First(&user).Error == nil
+	hash := user.Password
+	if !found {
+		// still hash so response time does not reveal whether the email exists
+		hash = h.dummyHash()
+	}
+	ok, needsRehash, err := utils.VerifyPassword(h.cfg.Server.Password, hash, req.Password)
+	if !found || err != nil || !ok {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid credentials"})
 		return
 	}
-	if user.Password != req.Password {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid credentials"})
+	guard.Reset(keys[0])
+	guard.Release(keys[1:]...)
+	if user.Disabled {
+		c.JSON(http.StatusForbidden, gin.H{"error": "account disabled"})
+		return
+	}
+	if user.Pending {
+		c.JSON(http.StatusForbidden, gin.H{"error": "account pending approval"})
+		return
+	}
+	if h.cfg.Server.Auth.RequireVerifiedEmail == "login" && !user.EmailVerified {
+		c.JSON(http.StatusForbidden, gin.H{"error": "email not verified"})
 		return
 	}
+	if needsRehash {
+		// transparently upgrade legacy or outdated hashes
+		if hash, err := utils.HashPassword(h.cfg.Server.Password, req.Password); err == nil {
+			h.db.Model(&user).Update("password", hash)
+		}
+	}
+	if user.TOTPEnabled {
+		// second step happens in LoginMFA
+		token, err := actiontoken.Issue(h.keys, actiontoken.PurposeMFALogin, actiontoken.Claims{
+			Fingerprint:      actiontoken.Fingerprint(user.Password),
+			RegisteredClaims: jwtSubject(user.ID),
+		}, mfaPendingTTL)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to sign token"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"mfa_required": true, "mfa_token": token})
+		return
+	}
+	h.startSession(c, user)
+}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"sub":   user.ID,
-		"email": user.Email,
-		"role":  user.Role,
-		"exp":   time.Now().Add(time.Duration(h.cfg.Server.JWT.ExpireHour) * time.Hour).Unix(),
+var (
+	dummyHashOnce  sync.Once
+	dummyHashValue string
+)
+
+func (h *Handler) dummyHash() string {
+	dummyHashOnce.Do(func() {
+		dummyHashValue, _ = utils.HashPassword(h.cfg.Server.Password, utils.SHA256Encode("easyblog"))
 	})
-	signed, err := token.SignedString([]byte(h.cfg.Server.JWT.Secret))
+	return dummyHashValue
+}
+
+// tooManyAttempts answers a throttled request, or a 503 when the throttle
+// could not be checked
+func tooManyAttempts(c *gin.Context, wait time.Duration, err error) {
+	if err != nil {
+		log.Printf("login throttle: %v", err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "service unavailable, try again later"})
+		return
+	}
+	secs := int(math.Ceil(wait.Seconds()))
+	c.Header("Retry-After", strconv.Itoa(secs))
+	c.JSON(http.StatusTooManyRequests, gin.H{"error": "too many attempts, try again later", "retry_after": secs})
+}
+
+func (h *Handler) startSession(c *gin.Context, user models.User) {
+	pair, err := session.NewManager(h.db, h.cfg, h.keys).Create(user, c.Request.UserAgent(), c.ClientIP())
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to sign token"})
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"token": signed})
+	c.JSON(http.StatusOK, pair)
+}
+
+type refreshRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+func (h *Handler) Refresh(c *gin.Context) {
+	var req refreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	pair, err := session.NewManager(h.db, h.cfg, h.keys).Refresh(req.RefreshToken)
+	if err != nil {
+		switch {
+		case errors.Is(err, session.ErrInvalidToken),
+			errors.Is(err, session.ErrTokenExpired),
+			errors.Is(err, session.ErrTokenReused),
+			errors.Is(err, session.ErrSessionRevoked),
+			errors.Is(err, session.ErrUserDisabled):
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+	c.JSON(http.StatusOK, pair)
+}
+
+func (h *Handler) Logout(c *gin.Context) {
+	sid := c.GetUint("session_id")
+	if err := session.NewManager(h.db, h.cfg, h.keys).Revoke(sid); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"result": "ok"})
+}
+
+func (h *Handler) LogoutAll(c *gin.Context) {
+	userObj, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	user := userObj.(models.User)
+	if err := session.NewManager(h.db, h.cfg, h.keys).RevokeAll(user.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"result": "ok"})
 }
 
 func (h *Handler) Profile(c *gin.Context) {
@@ -97,5 +263,16 @@ func (h *Handler) Profile(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "invalid user context"})
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"id": user.ID, "username": user.Username, "email": user.Email, "role": user.Role})
+	c.JSON(http.StatusOK, gin.H{
+		"id":                   user.ID,
+		"username":             user.Username,
+		"email":                user.Email,
+		"email_verified":       user.EmailVerified,
+		"pending_email":        user.PendingEmail,
+		"avatar":               user.Avatar,
+		"bio":                  user.Bio,
+		"role":                 user.Role,
+		"must_change_password": user.MustChangePassword,
+		"totp_enabled":         user.TOTPEnabled,
+	})
 }
@@ -0,0 +1,60 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"easyblog/internal/models"
+	"easyblog/internal/utils"
+
+	"gorm.io/gorm"
+)
+
+// values of the register_mode config row
+const (
+	RegisterOpen     = "open"
+	RegisterInvite   = "invite"
+	RegisterApproval = "approval"
+)
+
+var errInvalidInvite = errors.New("invalid or expired invite code")
+
+func (h *Handler) registerMode() string {
+	var cf models.ConfigModel
+	if err := h.db.Where("key = ?", "register_mode").First(&cf).Error; err != nil {
+		return RegisterOpen
+	}
+	switch cf.Value {
+	case RegisterInvite, RegisterApproval:
+		return cf.Value
+	default:
+		return RegisterOpen
+	}
+}
+
+// redeemInvite counts one use of code; the conditional update keeps
+// concurrent registrations from exceeding max_uses
+func redeemInvite(tx *gorm.DB, code string) (*models.Invite, error) {
+	var invite models.Invite
+	hash := utils.SHA256Encode(strings.TrimSpace(code))
+	if err := tx.Where("code_hash = ?", hash).First(&invite).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errInvalidInvite
+		}
+		return nil, err
+	}
+	if invite.ExpiresAt != nil && time.Now().After(*invite.ExpiresAt) {
+		return nil, errInvalidInvite
+	}
+	res := tx.Model(&models.Invite{}).
+		Where("id = ? AND (max_uses = 0 OR uses < max_uses)", invite.ID).
+		Update("uses", gorm.Expr("uses + 1"))
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return nil, errInvalidInvite
+	}
+	return &invite, nil
+}
@@ -0,0 +1,283 @@
+package auth
+
+import (
+	"crypto/rand"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"easyblog/internal/actiontoken"
+	"easyblog/internal/models"
+	"easyblog/internal/throttle"
+	"easyblog/internal/totp"
+	"easyblog/internal/utils"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	mfaPendingTTL     = 5 * time.Minute
+	recoveryCodeCount = 10
+	totpIssuer        = "EasyBlog"
+)
+
+// SetupTOTP starts enrollment by generating a new secret. It only takes
+// effect once EnableTOTP confirms a code from the authenticator app.
+func (h *Handler) SetupTOTP(c *gin.Context) {
+	user := c.MustGet("user").(models.User)
+	if user.TOTPEnabled {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "two-factor authentication already enabled"})
+		return
+	}
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.db.Model(&user).Update("totp_secret", secret).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"secret": secret,
+		"uri":    totp.ProvisioningURI(totpIssuer, user.Email, secret),
+	})
+}
+
+type totpCodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+func (h *Handler) EnableTOTP(c *gin.Context) {
+	var req totpCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	user := c.MustGet("user").(models.User)
+	if user.TOTPEnabled {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "two-factor authentication already enabled"})
+		return
+	}
+	if user.TOTPSecret == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "call setup first"})
+		return
+	}
+	step, ok := totp.Validate(user.TOTPSecret, req.Code, time.Now(), 1)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid code"})
+		return
+	}
+	var codes []string
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Updates(map[string]interface{}{
+			"totp_enabled":   true,
+			"totp_last_step": step,
+		}).Error; err != nil {
+			return err
+		}
+		var err error
+		codes, err = replaceRecoveryCodes(tx, user.ID)
+		return err
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+type disableTOTPRequest struct {
+	Password string `json:"password" binding:"required,min=64,max=64"` // sha256 hex
+	Code     string `json:"code" binding:"required"`
+}
+
+func (h *Handler) DisableTOTP(c *gin.Context) {
+	var req disableTOTPRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	user := c.MustGet("user").(models.User)
+	if !user.TOTPEnabled {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "two-factor authentication not enabled"})
+		return
+	}
+	if ok, _, err := utils.VerifyPassword(h.cfg.Server.Password, user.Password, req.Password); err != nil || !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid credentials"})
+		return
+	}
+	if !h.verifySecondFactor(user, req.Code, "") {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid code"})
+		return
+	}
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Updates(map[string]interface{}{
+			"totp_enabled": false,
+			"totp_secret":  "",
+		}).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", user.ID).Delete(&models.RecoveryCode{}).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"result": "ok"})
+}
+
+// RegenerateRecoveryCodes invalidates all previous recovery codes
+func (h *Handler) RegenerateRecoveryCodes(c *gin.Context) {
+	var req totpCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	user := c.MustGet("user").(models.User)
+	if !user.TOTPEnabled {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "two-factor authentication not enabled"})
+		return
+	}
+	if !h.verifySecondFactor(user, req.Code, "") {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid code"})
+		return
+	}
+	var codes []string
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		codes, err = replaceRecoveryCodes(tx, user.ID)
+		return err
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+type loginMFARequest struct {
+	MFAToken     string `json:"mfa_token" binding:"required"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// LoginMFA completes a login that Login answered with mfa_required
+func (h *Handler) LoginMFA(c *gin.Context) {
+	var req loginMFARequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Code == "" && req.RecoveryCode == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "code or recovery_code is required"})
+		return
+	}
+	claims, err := actiontoken.Parse(h.keys, actiontoken.PurposeMFALogin, req.MFAToken)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	var user models.User
+	if err := h.db.First(&user, subjectID(claims)).Error; err != nil ||
+		actiontoken.Fingerprint(user.Password) != claims.Fingerprint || !user.TOTPEnabled {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": actiontoken.ErrInvalid.Error()})
+		return
+	}
+	if user.Disabled {
+		c.JSON(http.StatusForbidden, gin.H{"error": "account disabled"})
+		return
+	}
+	guard := throttle.NewGuard(h.db, h.cfg.Server.Auth.Throttle)
+	keys := []string{throttle.EmailKey(user.Email), throttle.IPKey(c.ClientIP())}
+	if wait, err := guard.Reserve(keys...); err != nil || wait > 0 {
+		tooManyAttempts(c, wait, err)
+		return
+	}
+	if !h.verifySecondFactor(user, req.Code, req.RecoveryCode) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid code"})
+		return
+	}
+	guard.Reset(keys[0])
+	guard.Release(keys[1:]...)
+	if err := actiontoken.Consume(h.db, claims); err != nil {
+		if errors.Is(err, actiontoken.ErrConsumed) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+	h.startSession(c, user)
+}
+
+// verifySecondFactor accepts either a TOTP code, which may not be replayed,
+// or an unused recovery code, which is burnt on success.
+func (h *Handler) verifySecondFactor(user models.User, code, recoveryCode string) bool {
+	if code != "" {
+		step, ok := totp.Validate(user.TOTPSecret, code, time.Now(), 1)
+		if !ok {
+			return false
+		}
+		res := h.db.Model(&models.User{}).
+			Where("id = ? AND totp_last_step < ?", user.ID, step).
+			Update("totp_last_step", step)
+		return res.Error == nil && res.RowsAffected == 1
+	}
+	if recoveryCode != "" {
+		hash := utils.SHA256Encode(normalizeRecoveryCode(recoveryCode))
+		res := h.db.Model(&models.RecoveryCode{}).
+			Where("user_id = ? AND code_hash = ? AND used_at IS NULL", user.ID, hash).
+			Update("used_at", time.Now())
+		return res.Error == nil && res.RowsAffected == 1
+	}
+	return false
+}
+
+func replaceRecoveryCodes(tx *gorm.DB, userID uint) ([]string, error) {
+	if err := tx.Unscoped().Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+	codes := make([]string, recoveryCodeCount)
+	rows := make([]models.RecoveryCode, recoveryCodeCount)
+	for i := range codes {
+		code, err := newRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		codes[i] = code
+		rows[i] = models.RecoveryCode{UserID: userID, CodeHash: utils.SHA256Encode(normalizeRecoveryCode(code))}
+	}
+	if err := tx.Create(&rows).Error; err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+const recoveryAlphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+
+// newRecoveryCode returns a code like "k3m9x-7qpwa"
+func newRecoveryCode() (string, error) {
+	var sb strings.Builder
+	buf := make([]byte, 1)
+	for sb.Len() < 11 {
+		if sb.Len() == 5 {
+			sb.WriteByte('-')
+		}
+		if _, err := rand.Read(buf); err != nil {
+			return "", err
+		}
+		// skip values that would bias the modulo
+		if int(buf[0]) >= 256-256%len(recoveryAlphabet) {
+			continue
+		}
+		sb.WriteByte(recoveryAlphabet[int(buf[0])%len(recoveryAlphabet)])
+	}
+	return sb.String(), nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+}
@@ -0,0 +1,64 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"easyblog/internal/models"
+	"easyblog/internal/totp"
+)
+
+func TestVerifySecondFactorRejectsReplays(t *testing.T) {
+	h, db, _ := newProfileTestHandler(t)
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var user models.User
+	db.Where("username = ?", "user").First(&user)
+	db.Model(&user).Updates(map[string]interface{}{"totp_secret": secret, "totp_enabled": true})
+	codes, err := replaceRecoveryCodes(db, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the codes are checked against the clock, keep clear of a step boundary
+	if left := totp.Period - time.Now().Unix()%totp.Period; left < 3 {
+		time.Sleep(time.Duration(left) * time.Second)
+	}
+	step := totp.Step(time.Now())
+	code := func(step int64) string {
+		c, err := totp.Code(secret, step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	// steps run in order against the same user
+	steps := []struct {
+		name     string
+		code     string
+		recovery string
+		want     bool
+	}{
+		{"previous step", code(step - 1), "", true},
+		{"current step", code(step), "", true},
+		{"replayed step", code(step), "", false},
+		{"earlier step after a later one", code(step - 1), "", false},
+		{"outside the window", code(step + 3), "", false},
+		{"recovery code", "", codes[0], true},
+		{"recovery code reused", "", codes[0], false},
+		{"nothing", "", "", false},
+	}
+	for _, s := range steps {
+		t.Run(s.name, func(t *testing.T) {
+			db.First(&user, user.ID)
+			if got := h.verifySecondFactor(user, s.code, s.recovery); got != s.want {
+				t.Errorf("verifySecondFactor = %v, want %v", got, s.want)
+			}
+		})
+	}
+	db.First(&user, user.ID)
+	if user.TOTPLastStep != step {
+		t.Errorf("last step = %d, want %d", user.TOTPLastStep, step)
+	}
+}
@@ -0,0 +1,294 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"easyblog/internal/actiontoken"
+	"easyblog/internal/models"
+	"easyblog/internal/oidc"
+	"easyblog/internal/rbac"
+	"easyblog/internal/session"
+	"easyblog/internal/utils"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const oidcStateTTL = 10 * time.Minute
+
+var (
+	errNoLocalUser        = errors.New("no local account for this identity")
+	errRegistrationClosed = errors.New("registration is invite only")
+	errLinkUnverified     = errors.New("local account with this email is not verified")
+)
+
+// oidcStateCookie ties a login to the browser that started it: it holds the
+// hash of the state, so a callback with a state handed out to someone else,
+// as in login CSRF, is refused
+func oidcStateCookie(provider string) string {
+	return "oidc_state_" + provider
+}
+
+func (h *Handler) OIDCProviders(c *gin.Context) {
+	items := make([]gin.H, 0, len(h.cfg.OIDC.Providers))
+	for _, p := range h.cfg.OIDC.Providers {
+		items = append(items, gin.H{
+			"name":         p.Name,
+			"display_name": p.DisplayName,
+			"login_url":    "/api/auth/oidc/" + p.Name + "/login",
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{"items": items})
+}
+
+// OIDCLogin redirects the browser to the provider's authorization endpoint
+func (h *Handler) OIDCLogin(c *gin.Context) {
+	provider, ok := h.providers[c.Param("provider")]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "unknown provider"})
+		return
+	}
+	state, err1 := oidc.RandomString(32)
+	nonce, err2 := oidc.RandomString(32)
+	verifier, err3 := oidc.RandomString(48)
+	if err := errors.Join(err1, err2, err3); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	// drop stale requests while we are here
+	h.db.Where("expires_at < ?", time.Now()).Delete(&models.OIDCState{})
+	if err := h.db.Create(&models.OIDCState{
+		State:     state,
+		Provider:  provider.Config().Name,
+		Nonce:     nonce,
+		Verifier:  verifier,
+		ExpiresAt: time.Now().Add(oidcStateTTL),
+	}).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	authURL, err := provider.AuthCodeURL(c.Request.Context(), state, nonce, verifier)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+	// Lax still sends it on the provider's top level redirect back to us
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie(provider.Config().Name), utils.SHA256Encode(state), int(oidcStateTTL.Seconds()),
+		"/api/auth/oidc", "", c.Request.TLS != nil, true)
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// OIDCCallback finishes the flow and hands the tokens to the frontend in
+// the url fragment, which is never sent to servers
+func (h *Handler) OIDCCallback(c *gin.Context) {
+	provider, ok := h.providers[c.Param("provider")]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "unknown provider"})
+		return
+	}
+	if e := c.Query("error"); e != "" {
+		h.oidcRedirect(c, url.Values{"error": {e}})
+		return
+	}
+
+	// the state has to come back to the browser that asked for it
+	cookie, _ := c.Cookie(oidcStateCookie(provider.Config().Name))
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie(provider.Config().Name), "", -1, "/api/auth/oidc", "", c.Request.TLS != nil, true)
+	if cookie == "" || subtle.ConstantTimeCompare([]byte(cookie), []byte(utils.SHA256Encode(c.Query("state")))) != 1 {
+		h.oidcRedirect(c, url.Values{"error": {"invalid_state"}})
+		return
+	}
+
+	var st models.OIDCState
+	err := h.db.Where("state = ? AND provider = ?", c.Query("state"), provider.Config().Name).First(&st).Error
+	if err != nil || time.Now().After(st.ExpiresAt) {
+		h.oidcRedirect(c, url.Values{"error": {"invalid_state"}})
+		return
+	}
+	// the state is single use
+	if res := h.db.Delete(&st); res.Error != nil || res.RowsAffected == 0 {
+		h.oidcRedirect(c, url.Values{"error": {"invalid_state"}})
+		return
+	}
+
+	claims, err := provider.Exchange(c.Request.Context(), c.Query("code"), st.Verifier, st.Nonce)
+	if err != nil {
+		log.Printf("oidc %s: %v", provider.Config().Name, err)
+		h.oidcRedirect(c, url.Values{"error": {"exchange_failed"}})
+		return
+	}
+	user, err := h.resolveIdentity(provider, claims)
+	if err != nil {
+		switch {
+		case errors.Is(err, errRegistrationClosed):
+			h.oidcRedirect(c, url.Values{"error": {"registration_closed"}})
+			return
+		case errors.Is(err, errLinkUnverified):
+			h.oidcRedirect(c, url.Values{"error": {"link_not_verified"}})
+			return
+		case !errors.Is(err, errNoLocalUser):
+			log.Printf("oidc %s: %v", provider.Config().Name, err)
+		}
+		h.oidcRedirect(c, url.Values{"error": {"account_not_linked"}})
+		return
+	}
+	if user.Disabled {
+		h.oidcRedirect(c, url.Values{"error": {"account_disabled"}})
+		return
+	}
+	if user.Pending {
+		h.oidcRedirect(c, url.Values{"error": {"account_pending"}})
+		return
+	}
+	if !user.EmailVerified && claims.EmailVerified && strings.EqualFold(claims.Email, user.Email) {
+		// the provider vouches for the address
+		if err := h.db.Model(&user).Update("email_verified", true).Error; err != nil {
+			h.oidcRedirect(c, url.Values{"error": {"server_error"}})
+			return
+		}
+		user.EmailVerified = true
+	}
+	if h.cfg.Server.Auth.RequireVerifiedEmail == "login" && !user.EmailVerified {
+		h.oidcRedirect(c, url.Values{"error": {"email_not_verified"}})
+		return
+	}
+
+	if user.TOTPEnabled {
+		token, err := actiontoken.Issue(h.keys, actiontoken.PurposeMFALogin, actiontoken.Claims{
+			Fingerprint:      actiontoken.Fingerprint(user.Password),
+			RegisteredClaims: jwtSubject(user.ID),
+		}, mfaPendingTTL)
+		if err != nil {
+			h.oidcRedirect(c, url.Values{"error": {"server_error"}})
+			return
+		}
+		h.oidcRedirect(c, url.Values{"mfa_required": {"true"}, "mfa_token": {token}})
+		return
+	}
+	pair, err := session.NewManager(h.db, h.cfg, h.keys).Create(user, c.Request.UserAgent(), c.ClientIP())
+	if err != nil {
+		h.oidcRedirect(c, url.Values{"error": {"server_error"}})
+		return
+	}
+	h.oidcRedirect(c, url.Values{
+		"token":         {pair.AccessToken},
+		"refresh_token": {pair.RefreshToken},
+		"expires_in":    {strconv.FormatInt(pair.ExpiresIn, 10)},
+	})
+}
+
+func (h *Handler) oidcRedirect(c *gin.Context, fragment url.Values) {
+	c.Redirect(http.StatusFound, h.cfg.OIDC.FrontendCallback+"#"+fragment.Encode())
+}
+
+// resolveIdentity finds the local user for the provider account: first by a
+// known link, then by verified email, finally by provisioning a new user.
+// Linking by email needs the local address to be verified as well, or
+// whoever registered it first without owning it would take the account over.
+// Provisioning follows register_mode like Register: it is refused in invite
+// mode, and users wait for approval in approval mode.
+func (h *Handler) resolveIdentity(provider *oidc.Provider, claims *oidc.Claims) (models.User, error) {
+	pc := provider.Config()
+	var user models.User
+	var identity models.UserIdentity
+	err := h.db.Where("provider = ? AND subject = ?", pc.Name, claims.Subject).First(&identity).Error
+	if err == nil {
+		err = h.db.First(&user, identity.UserID).Error
+		return user, err
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return user, err
+	}
+
+	mode := h.registerMode()
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		found := false
+		if pc.LinkByEmail && claims.EmailVerified && claims.Email != "" {
+			err := tx.Where("email = ?", claims.Email).First(&user).Error
+			if err == nil {
+				if !user.EmailVerified {
+					// the owner has to verify the address first
+					return errLinkUnverified
+				}
+				found = true
+			} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+				return err
+			}
+		}
+		if !found {
+			if !pc.AutoProvision || claims.Email == "" {
+				return errNoLocalUser
+			}
+			if mode == RegisterInvite {
+				return errRegistrationClosed
+			}
+			role := models.UserRole(pc.DefaultRole)
+			if !rbac.ValidRole(role) {
+				role = models.RoleReader
+			}
+			username, err := uniqueUsername(tx, claims)
+			if err != nil {
+				return err
+			}
+			user = models.User{
+				Username:      username,
+				Email:         claims.Email,
+				Avatar:        claims.Picture,
+				Role:          role,
+				EmailVerified: claims.EmailVerified,
+				Pending:       mode == RegisterApproval,
+				// no local password, login happens through the provider
+				Password: "!",
+			}
+			if err := tx.Create(&user).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Create(&models.UserIdentity{
+			UserID:   user.ID,
+			Provider: pc.Name,
+			Subject:  claims.Subject,
+			Email:    claims.Email,
+		}).Error
+	})
+	return user, err
+}
+
+var usernameInvalid = regexp.MustCompile(`[^a-zA-Z0-9_.-]+`)
+
+func uniqueUsername(tx *gorm.DB, claims *oidc.Claims) (string, error) {
+	base := claims.PreferredUsername
+	if base == "" {
+		base, _, _ = strings.Cut(claims.Email, "@")
+	}
+	base = usernameInvalid.ReplaceAllString(base, "")
+	if len(base) < 3 {
+		base = "user"
+	}
+	if len(base) > 56 {
+		base = base[:56]
+	}
+	name := base
+	for i := 2; i < 100; i++ {
+		var count int64
+		if err := tx.Unscoped().Model(&models.User{}).Where("username = ?", name).Count(&count).Error; err != nil {
+			return "", err
+		}
+		if count == 0 {
+			return name, nil
+		}
+		name = fmt.Sprintf("%s%d", base, i)
+	}
+	return "", errors.New("could not pick a free username")
+}
@@ -0,0 +1,349 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"easyblog/internal/config"
+	"easyblog/internal/keys"
+	"easyblog/internal/models"
+	"easyblog/internal/oidc"
+	"easyblog/internal/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// mockIssuer is a minimal OpenID provider: discovery, JWKS and a token
+// endpoint that checks the PKCE verifier of codes handed out by authorize
+type mockIssuer struct {
+	t   *testing.T
+	srv *httptest.Server
+	key *rsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]mockGrant
+}
+
+type mockGrant struct {
+	challenge string
+	nonce     string
+	claims    oidc.Claims
+}
+
+func newMockIssuer(t *testing.T) *mockIssuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &mockIssuer{t: t, key: key, codes: map[string]mockGrant{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(oidc.Discovery{
+			Issuer:                m.srv.URL,
+			AuthorizationEndpoint: m.srv.URL + "/authorize",
+			TokenEndpoint:         m.srv.URL + "/token",
+			JWKSURI:               m.srv.URL + "/jwks",
+			SigningAlgs:           []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		jwk, err := oidc.NewJWK("mock", "RS256", &key.PublicKey)
+		if err != nil {
+			t.Error(err)
+		}
+		json.NewEncoder(w).Encode(oidc.JWKS{Keys: []oidc.JWK{jwk}})
+	})
+	mux.HandleFunc("/token", m.token)
+	m.srv = httptest.NewServer(mux)
+	t.Cleanup(m.srv.Close)
+	return m
+}
+
+// authorize plays the user consenting at the provider: it takes the
+// authorization url and returns a code for claims
+func (m *mockIssuer) authorize(authURL string, claims oidc.Claims) (code, state string) {
+	u, err := url.Parse(authURL)
+	if err != nil {
+		m.t.Fatal(err)
+	}
+	q := u.Query()
+	if q.Get("code_challenge_method") != "S256" {
+		m.t.Fatalf("code_challenge_method = %q", q.Get("code_challenge_method"))
+	}
+	code = "code-" + q.Get("state")[:8]
+	m.mu.Lock()
+	m.codes[code] = mockGrant{challenge: q.Get("code_challenge"), nonce: q.Get("nonce"), claims: claims}
+	m.mu.Unlock()
+	return code, q.Get("state")
+}
+
+func (m *mockIssuer) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil || r.Form.Get("grant_type") != "authorization_code" {
+		http.Error(w, `{"error":"invalid_request"}`, http.StatusBadRequest)
+		return
+	}
+	m.mu.Lock()
+	grant, ok := m.codes[r.Form.Get("code")]
+	delete(m.codes, r.Form.Get("code"))
+	m.mu.Unlock()
+	sum := sha256.Sum256([]byte(r.Form.Get("code_verifier")))
+	if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != grant.challenge {
+		http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+		return
+	}
+	claims := grant.claims
+	claims.Nonce = grant.nonce
+	claims.Issuer = m.srv.URL
+	claims.Audience = jwt.ClaimStrings{"easyblog"}
+	claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(time.Minute))
+	tok := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	tok.Header["kid"] = "mock"
+	signed, err := tok.SignedString(m.key)
+	if err != nil {
+		m.t.Error(err)
+	}
+	json.NewEncoder(w).Encode(map[string]string{"id_token": signed, "token_type": "Bearer"})
+}
+
+func newOIDCTestHandler(t *testing.T, issuer string, registerMode, requireVerified string) (*Handler, *gorm.DB) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, _ := db.DB()
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+	if err := db.AutoMigrate(&models.User{}, &models.ConfigModel{}, &models.UserIdentity{}, &models.OIDCState{},
+		&models.Session{}, &models.RefreshToken{}, &models.SigningKey{}); err != nil {
+		t.Fatal(err)
+	}
+	db.Create(&models.ConfigModel{Key: "register_mode", Value: registerMode})
+
+	cfg := &config.Config{}
+	cfg.Server.JWT.Algorithm = keys.AlgEdDSA
+	cfg.Server.JWT.AccessExpireMinute = 15
+	cfg.Server.JWT.RefreshExpireHour = 1
+	cfg.Server.Auth.RequireVerifiedEmail = requireVerified
+	cfg.OIDC.FrontendCallback = "http://spa.test/oidc"
+	cfg.OIDC.Providers = []config.OIDCProvider{{
+		Name:          "mock",
+		Issuer:        issuer,
+		ClientID:      "easyblog",
+		RedirectURL:   "http://api.test/api/auth/oidc/mock/callback",
+		AutoProvision: true,
+		LinkByEmail:   true,
+	}}
+	ks, err := keys.Load(db, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewHandler(db, cfg, ks, nil), db
+}
+
+func TestOIDCLogin(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	issuer := newMockIssuer(t)
+	tests := []struct {
+		name            string
+		registerMode    string
+		requireVerified string
+		emailVerified   bool
+		wantError       string
+		wantPending     bool
+		wantUser        bool
+	}{
+		{"provisions in open mode", RegisterOpen, "off", true, "", false, true},
+		{"refused in invite mode", RegisterInvite, "off", true, "registration_closed", false, false},
+		{"pending in approval mode", RegisterApproval, "off", true, "account_pending", true, true},
+		{"unverified email allowed when not required", RegisterOpen, "off", false, "", false, true},
+		{"unverified email refused when required", RegisterOpen, "login", false, "email_not_verified", false, true},
+		{"verified email passes when required", RegisterOpen, "login", true, "", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, db := newOIDCTestHandler(t, issuer.srv.URL, tt.registerMode, tt.requireVerified)
+			r := gin.New()
+			r.GET("/api/auth/oidc/:provider/login", h.OIDCLogin)
+			r.GET("/api/auth/oidc/:provider/callback", h.OIDCCallback)
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/auth/oidc/mock/login", nil))
+			if w.Code != http.StatusFound {
+				t.Fatalf("login: %d %s", w.Code, w.Body)
+			}
+			code, state := issuer.authorize(w.Header().Get("Location"), oidc.Claims{
+				Email:             "ada@example.com",
+				EmailVerified:     tt.emailVerified,
+				PreferredUsername: "ada",
+				RegisteredClaims:  jwt.RegisteredClaims{Subject: "ada-1"},
+			})
+
+			cookies := w.Result().Cookies()
+			w = httptest.NewRecorder()
+			q := url.Values{"code": {code}, "state": {state}}
+			r.ServeHTTP(w, withCookies(httptest.NewRequest(http.MethodGet, "/api/auth/oidc/mock/callback?"+q.Encode(), nil), cookies))
+			loc := w.Header().Get("Location")
+			if w.Code != http.StatusFound || !strings.HasPrefix(loc, "http://spa.test/oidc#") {
+				t.Fatalf("callback: %d %q", w.Code, loc)
+			}
+			fragment, _ := url.ParseQuery(strings.SplitN(loc, "#", 2)[1])
+			if got := fragment.Get("error"); got != tt.wantError {
+				t.Fatalf("error = %q, want %q", got, tt.wantError)
+			}
+			if tt.wantError == "" && (fragment.Get("token") == "" || fragment.Get("refresh_token") == "") {
+				t.Fatalf("no tokens in %q", loc)
+			}
+
+			var user models.User
+			err := db.Where("email = ?", "ada@example.com").First(&user).Error
+			if (err == nil) != tt.wantUser {
+				t.Fatalf("user exists = %v, want %v", err == nil, tt.wantUser)
+			}
+			if tt.wantUser && user.Pending != tt.wantPending {
+				t.Errorf("pending = %v, want %v", user.Pending, tt.wantPending)
+			}
+		})
+	}
+}
+
+func TestOIDCCallbackRejectsReplayedState(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	issuer := newMockIssuer(t)
+	h, _ := newOIDCTestHandler(t, issuer.srv.URL, RegisterOpen, "off")
+	r := gin.New()
+	r.GET("/api/auth/oidc/:provider/login", h.OIDCLogin)
+	r.GET("/api/auth/oidc/:provider/callback", h.OIDCCallback)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/auth/oidc/mock/login", nil))
+	code, state := issuer.authorize(w.Header().Get("Location"), oidc.Claims{
+		Email:            "ada@example.com",
+		EmailVerified:    true,
+		RegisteredClaims: jwt.RegisteredClaims{Subject: "ada-1"},
+	})
+	cookies := w.Result().Cookies()
+	callback := "/api/auth/oidc/mock/callback?" + url.Values{"code": {code}, "state": {state}}.Encode()
+	for i, want := range []string{"", "invalid_state"} {
+		w = httptest.NewRecorder()
+		r.ServeHTTP(w, withCookies(httptest.NewRequest(http.MethodGet, callback, nil), cookies))
+		fragment, _ := url.ParseQuery(strings.SplitN(w.Header().Get("Location"), "#", 2)[1])
+		if got := fragment.Get("error"); got != want {
+			t.Errorf("attempt %d: error = %q, want %q", i+1, got, want)
+		}
+	}
+}
+
+func withCookies(req *http.Request, cookies []*http.Cookie) *http.Request {
+	for _, c := range cookies {
+		req.AddCookie(c)
+	}
+	return req
+}
+
+func TestOIDCCallbackChecksStateCookie(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	issuer := newMockIssuer(t)
+	tests := []struct {
+		name      string
+		cookie    func(login []*http.Cookie) []*http.Cookie
+		wantError string
+	}{
+		{"own browser", func(login []*http.Cookie) []*http.Cookie { return login }, ""},
+		{"no cookie", func([]*http.Cookie) []*http.Cookie { return nil }, "invalid_state"},
+		{"cookie of another login", func([]*http.Cookie) []*http.Cookie {
+			return []*http.Cookie{{Name: oidcStateCookie("mock"), Value: utils.SHA256Encode("other")}}
+		}, "invalid_state"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, _ := newOIDCTestHandler(t, issuer.srv.URL, RegisterOpen, "off")
+			r := gin.New()
+			r.GET("/api/auth/oidc/:provider/login", h.OIDCLogin)
+			r.GET("/api/auth/oidc/:provider/callback", h.OIDCCallback)
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/auth/oidc/mock/login", nil))
+			login := w.Result().Cookies()
+			if len(login) != 1 || !login[0].HttpOnly || login[0].SameSite != http.SameSiteLaxMode {
+				t.Fatalf("login cookies = %+v, want one HttpOnly SameSite=Lax cookie", login)
+			}
+			code, state := issuer.authorize(w.Header().Get("Location"), oidc.Claims{
+				Email:            "ada@example.com",
+				EmailVerified:    true,
+				RegisteredClaims: jwt.RegisteredClaims{Subject: "ada-1"},
+			})
+			w = httptest.NewRecorder()
+			callback := "/api/auth/oidc/mock/callback?" + url.Values{"code": {code}, "state": {state}}.Encode()
+			r.ServeHTTP(w, withCookies(httptest.NewRequest(http.MethodGet, callback, nil), tt.cookie(login)))
+			fragment, _ := url.ParseQuery(strings.SplitN(w.Header().Get("Location"), "#", 2)[1])
+			if got := fragment.Get("error"); got != tt.wantError {
+				t.Errorf("error = %q, want %q", got, tt.wantError)
+			}
+			if cleared := w.Result().Cookies(); len(cleared) != 1 || cleared[0].MaxAge >= 0 {
+				t.Errorf("callback cookies = %+v, want the state cookie cleared", cleared)
+			}
+		})
+	}
+}
+
+func TestOIDCLinkByEmail(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	issuer := newMockIssuer(t)
+	tests := []struct {
+		name          string
+		localVerified bool
+		wantError     string
+		wantLinked    bool
+	}{
+		{"verified local account", true, "", true},
+		{"unverified local account", false, "link_not_verified", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, db := newOIDCTestHandler(t, issuer.srv.URL, RegisterOpen, "off")
+			local := models.User{Username: "ada", Email: "ada@example.com", EmailVerified: tt.localVerified, Password: "x", Role: models.RoleAuthor}
+			db.Create(&local)
+			r := gin.New()
+			r.GET("/api/auth/oidc/:provider/login", h.OIDCLogin)
+			r.GET("/api/auth/oidc/:provider/callback", h.OIDCCallback)
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/auth/oidc/mock/login", nil))
+			code, state := issuer.authorize(w.Header().Get("Location"), oidc.Claims{
+				Email:            "ada@example.com",
+				EmailVerified:    true,
+				RegisteredClaims: jwt.RegisteredClaims{Subject: "ada-1"},
+			})
+			cookies := w.Result().Cookies()
+			w = httptest.NewRecorder()
+			callback := "/api/auth/oidc/mock/callback?" + url.Values{"code": {code}, "state": {state}}.Encode()
+			r.ServeHTTP(w, withCookies(httptest.NewRequest(http.MethodGet, callback, nil), cookies))
+			fragment, _ := url.ParseQuery(strings.SplitN(w.Header().Get("Location"), "#", 2)[1])
+			if got := fragment.Get("error"); got != tt.wantError {
+				t.Fatalf("error = %q, want %q", got, tt.wantError)
+			}
+			var links int64
+			db.Model(&models.UserIdentity{}).Where("user_id = ?", local.ID).Count(&links)
+			if (links == 1) != tt.wantLinked {
+				t.Errorf("linked = %v, want %v", links == 1, tt.wantLinked)
+			}
+			db.First(&local, local.ID)
+			if local.EmailVerified != tt.localVerified {
+				t.Errorf("local account verified = %v, want %v", local.EmailVerified, tt.localVerified)
+			}
+		})
+	}
+}
@@ -0,0 +1,182 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+
+	"easyblog/internal/models"
+	"easyblog/internal/session"
+	"easyblog/internal/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type updateProfileRequest struct {
+	Username *string `json:"username" binding:"omitempty,min=3,max=64"`
+	Email    *string `json:"email" binding:"omitempty,email,max=191"`
+	Avatar   *string `json:"avatar" binding:"omitempty,max=255"`
+	Bio      *string `json:"bio" binding:"omitempty,max=500"`
+	// required to change the email address
+	CurrentPassword string `json:"current_password"` // sha256 hex
+}
+
+// UpdateProfile changes the profile of the current user. A new email address
+// only becomes pending: it replaces the current one once the link mailed to
+// it is followed, see VerifyEmail.
+func (h *Handler) UpdateProfile(c *gin.Context) {
+	var req updateProfileRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	user := c.MustGet("user").(models.User)
+
+	updates := make(map[string]interface{})
+	if req.Username != nil && *req.Username != user.Username {
+		updates["username"] = *req.Username
+	}
+	if req.Email != nil && *req.Email != user.Email && *req.Email != user.PendingEmail {
+		// a stolen session must not redirect password resets; accounts
+		// without a password, such as single sign-on ones, set one first
+		// through a password reset
+		ok, _, err := utils.VerifyPassword(h.cfg.Server.Password, user.Password, req.CurrentPassword)
+		if err != nil || !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "current password required to change the email address"})
+			return
+		}
+		var taken int64
+		if err := h.db.Model(&models.User{}).Where("email = ?", *req.Email).Count(&taken).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if taken > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "email already in use"})
+			return
+		}
+		updates["pending_email"] = *req.Email
+	} else if req.Email != nil && *req.Email == user.Email && user.PendingEmail != "" {
+		// asking for the current address again drops the pending one
+		updates["pending_email"] = ""
+	}
+	if req.Avatar != nil {
+		updates["avatar"] = *req.Avatar
+	}
+	if req.Bio != nil {
+		updates["bio"] = *req.Bio
+	}
+	if len(updates) > 0 {
+		if err := h.db.Model(&user).Updates(updates).Error; err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.Set("user", user)
+		if user.PendingEmail != "" && updates["pending_email"] == user.PendingEmail {
+			if err := h.sendVerification(user, user.PendingEmail); err != nil {
+				log.Printf("send verification mail: %v", err)
+			}
+		}
+	}
+	h.Profile(c)
+}
+
+type changePasswordRequest struct {
+	CurrentPassword string `json:"current_password" binding:"required,min=64,max=64"` // sha256 hex
+	NewPassword     string `json:"new_password" binding:"required,min=64,max=64"`     // sha256 hex
+}
+
+// ChangePassword also signs out every other session of the user
+func (h *Handler) ChangePassword(c *gin.Context) {
+	var req changePasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	user := c.MustGet("user").(models.User)
+	ok, _, err := utils.VerifyPassword(h.cfg.Server.Password, user.Password, req.CurrentPassword)
+	if err != nil || !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid credentials"})
+		return
+	}
+	hash, err := utils.HashPassword(h.cfg.Server.Password, req.NewPassword)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to hash password"})
+		return
+	}
+	err = h.db.Model(&user).Updates(map[string]interface{}{
+		"password":             hash,
+		"must_change_password": false,
+	}).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := session.NewManager(h.db, h.cfg, h.keys).RevokeOthers(user.ID, c.GetUint("session_id")); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"result": "ok"})
+}
+
+var avatarTypes = map[string]string{
+	"image/png":  ".png",
+	"image/jpeg": ".jpg",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+// UploadAvatar stores a multipart "file" field and sets it as the user's avatar
+func (h *Handler) UploadAvatar(c *gin.Context) {
+	maxBytes := h.cfg.Server.Upload.MaxAvatarKB * 1024
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBytes+1024*1024)
+	file, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "file is required"})
+		return
+	}
+	if file.Size > maxBytes {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "file too large"})
+		return
+	}
+	f, err := file.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	head := make([]byte, 512)
+	n, _ := f.Read(head)
+	f.Close()
+	ext, ok := avatarTypes[http.DetectContentType(head[:n])]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unsupported image type"})
+		return
+	}
+
+	dir := filepath.Join(h.cfg.Server.Upload.Dir, "avatars")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	raw := make([]byte, 16)
+	if _, err := rand.Read(raw); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	name := hex.EncodeToString(raw) + ext
+	if err := c.SaveUploadedFile(file, filepath.Join(dir, name)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	user := c.MustGet("user").(models.User)
+	url := path.Join(h.cfg.Server.Upload.URLPrefix, "avatars", name)
+	if err := h.db.Model(&user).Update("avatar", url).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"avatar": url})
+}
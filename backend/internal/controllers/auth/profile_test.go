@@ -0,0 +1,173 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+
+	"easyblog/internal/config"
+	"easyblog/internal/keys"
+	"easyblog/internal/mailer"
+	"easyblog/internal/models"
+	"easyblog/internal/utils"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// chanMailer hands every message to the test
+type chanMailer chan mailer.Message
+
+func (m chanMailer) Send(_ context.Context, msg mailer.Message) error {
+	m <- msg
+	return nil
+}
+
+var linkToken = regexp.MustCompile(`[?&]token=([^&\s]+)`)
+
+// nextToken waits for a mail to to and returns the token of its link
+func nextToken(t *testing.T, mail chanMailer, to string) string {
+	t.Helper()
+	select {
+	case msg := <-mail:
+		if msg.To != to {
+			t.Fatalf("mail sent to %s, want %s", msg.To, to)
+		}
+		m := linkToken.FindStringSubmatch(msg.Body)
+		if m == nil {
+			t.Fatalf("no link in %q", msg.Body)
+		}
+		token, _ := url.QueryUnescape(m[1])
+		return token
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no mail sent to %s", to)
+		return ""
+	}
+}
+
+// newProfileTestHandler serves a verified user old@example.com whose password
+// is "secret"
+func newProfileTestHandler(t *testing.T) (*Handler, *gorm.DB, chanMailer) {
+	gin.SetMode(gin.TestMode)
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, _ := db.DB()
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+	if err := db.AutoMigrate(&models.User{}, &models.ConfigModel{}, &models.UsedToken{}, &models.SigningKey{},
+		&models.RecoveryCode{}); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{}
+	cfg.Server.JWT.Algorithm = keys.AlgHS256
+	cfg.Server.JWT.Secret = "test"
+	cfg.Server.Password.Algorithm = utils.PasswordBcrypt
+	cfg.Server.Password.BcryptCost = bcrypt.MinCost
+	cfg.Server.Auth.VerifyEmailExpireHour = 24
+	cfg.Mail.BaseURL = "http://spa.test"
+	ks, err := keys.Load(db, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, err := utils.HashPassword(cfg.Server.Password, utils.SHA256Encode("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Create(&models.User{Username: "user", Email: "old@example.com", EmailVerified: true, Password: hash, Role: models.RoleReader})
+	db.Create(&models.User{Username: "other", Email: "taken@example.com", Password: hash, Role: models.RoleReader})
+	mail := make(chanMailer, 4)
+	return NewHandler(db, cfg, ks, mail), db, mail
+}
+
+// call runs handler with body as the JSON request, signed in as user when it
+// is not nil
+func call(handler gin.HandlerFunc, user *models.User, body interface{}) *httptest.ResponseRecorder {
+	r := gin.New()
+	r.POST("/", func(c *gin.Context) {
+		if user != nil {
+			c.Set("user", *user)
+		}
+	}, handler)
+	data, _ := json.Marshal(body)
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(data))
+	req.Header.Set("Content-Type", "application/json")
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestUpdateProfileEmail(t *testing.T) {
+	tests := []struct {
+		name     string
+		email    string
+		password string
+		want     int
+		pending  string
+	}{
+		{"no password", "new@example.com", "", http.StatusUnauthorized, ""},
+		{"wrong password", "new@example.com", "wrong", http.StatusUnauthorized, ""},
+		{"address in use", "taken@example.com", "secret", http.StatusConflict, ""},
+		{"same address", "old@example.com", "", http.StatusOK, ""},
+		{"new address", "new@example.com", "secret", http.StatusOK, "new@example.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, db, mail := newProfileTestHandler(t)
+			var user models.User
+			db.Where("username = ?", "user").First(&user)
+			req := gin.H{"email": tt.email}
+			if tt.password != "" {
+				req["current_password"] = utils.SHA256Encode(tt.password)
+			}
+			if w := call(h.UpdateProfile, &user, req); w.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+			db.First(&user, user.ID)
+			if user.Email != "old@example.com" || !user.EmailVerified || user.PendingEmail != tt.pending {
+				t.Errorf("email = %s verified %v pending %q, want old@example.com verified, pending %q",
+					user.Email, user.EmailVerified, user.PendingEmail, tt.pending)
+			}
+			if tt.pending == "" {
+				return
+			}
+			token := nextToken(t, mail, tt.pending)
+			if w := call(h.VerifyEmail, nil, gin.H{"token": token}); w.Code != http.StatusOK {
+				t.Fatalf("verify status = %d: %s", w.Code, w.Body)
+			}
+			db.First(&user, user.ID)
+			if user.Email != tt.pending || !user.EmailVerified || user.PendingEmail != "" {
+				t.Errorf("after verification email = %s verified %v pending %q", user.Email, user.EmailVerified, user.PendingEmail)
+			}
+		})
+	}
+}
+
+func TestVerifyEmailRejectsReplacedPending(t *testing.T) {
+	h, db, mail := newProfileTestHandler(t)
+	var user models.User
+	db.Where("username = ?", "user").First(&user)
+	password := utils.SHA256Encode("secret")
+	call(h.UpdateProfile, &user, gin.H{"email": "first@example.com", "current_password": password})
+	first := nextToken(t, mail, "first@example.com")
+	db.First(&user, user.ID)
+	call(h.UpdateProfile, &user, gin.H{"email": "second@example.com", "current_password": password})
+	nextToken(t, mail, "second@example.com")
+
+	if w := call(h.VerifyEmail, nil, gin.H{"token": first}); w.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusBadRequest, w.Body)
+	}
+	db.First(&user, user.ID)
+	if user.Email != "old@example.com" || user.PendingEmail != "second@example.com" {
+		t.Errorf("email = %s pending %s, want old@example.com pending second@example.com", user.Email, user.PendingEmail)
+	}
+}
@@ -0,0 +1,81 @@
+package auth
+
+import (
+	"net/http"
+	"time"
+
+	"easyblog/internal/models"
+	"easyblog/internal/rbac"
+	"easyblog/internal/session"
+
+	"github.com/gin-gonic/gin"
+)
+
+type accessTokenResponse struct {
+	models.AccessToken
+	Scopes []rbac.Permission `json:"scopes"`
+}
+
+func (h *Handler) ListTokens(c *gin.Context) {
+	user := c.MustGet("user").(models.User)
+	var tokens []models.AccessToken
+	if err := h.db.Where("user_id = ?", user.ID).Order("created_at DESC").Find(&tokens).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	items := make([]accessTokenResponse, len(tokens))
+	for i := range tokens {
+		items[i] = accessTokenResponse{AccessToken: tokens[i], Scopes: session.TokenScopes(&tokens[i])}
+	}
+	c.JSON(http.StatusOK, gin.H{"items": items})
+}
+
+type createTokenRequest struct {
+	Name          string            `json:"name" binding:"required,max=64"`
+	Scopes        []rbac.Permission `json:"scopes" binding:"required,min=1"`
+	ExpiresInDays int               `json:"expires_in_days" binding:"min=0,max=3650"` // 0 never expires
+}
+
+// CreateToken returns the plain token once; only its hash is kept
+func (h *Handler) CreateToken(c *gin.Context) {
+	var req createTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	user := c.MustGet("user").(models.User)
+	for _, scope := range req.Scopes {
+		if !rbac.Can(user.Role, scope) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "scope not granted to your role", "scope": scope})
+			return
+		}
+	}
+	var expiresAt *time.Time
+	if req.ExpiresInDays > 0 {
+		t := time.Now().AddDate(0, 0, req.ExpiresInDays)
+		expiresAt = &t
+	}
+	plain, token, err := session.NewManager(h.db, h.cfg, h.keys).CreateAccessToken(user, req.Name, req.Scopes, expiresAt)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{
+		"token": plain,
+		"data":  accessTokenResponse{AccessToken: *token, Scopes: req.Scopes},
+	})
+}
+
+func (h *Handler) RevokeToken(c *gin.Context) {
+	user := c.MustGet("user").(models.User)
+	res := h.db.Where("id = ? AND user_id = ?", c.Param("id"), user.ID).Delete(&models.AccessToken{})
+	if res.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": res.Error.Error()})
+		return
+	}
+	if res.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "token not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"result": "ok"})
+}
@@ -3,6 +3,7 @@ package categories
 import (
 	"easyblog/internal/config"
 	"easyblog/internal/models"
+	"easyblog/internal/slug"
 	"easyblog/internal/utils"
 	"errors"
 	"github.com/gin-gonic/gin"
@@ -35,6 +36,7 @@ func (h *Handler) List(c *gin.Context) {
 
 type createCategoryReq struct {
 	Name        string `json:"name" binding:"required"`
+	Slug        string `json:"slug" binding:"max=191"` // derived from the name when empty
 	Description string `json:"description" binding:"required"`
 }
 
@@ -48,7 +50,18 @@ func (h *Handler) Create(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "name is required"})
 		return
 	}
-	if err := h.db.Create(&models.Category{Name: req.Name, Description: req.Description}).Error; err != nil {
+	s, err := slug.For(h.db, &models.Category{}, req.Slug, req.Name, slug.KindCategory, 0)
+	if err != nil {
+		slug.WriteError(c, err)
+		return
+	}
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&models.Category{Name: req.Name, Slug: s, Description: req.Description}).Error; err != nil {
+			return err
+		}
+		return slug.Claim(tx, slug.KindCategory, s)
+	})
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -57,6 +70,7 @@ func (h *Handler) Create(c *gin.Context) {
 
 type updateCategoryReq struct {
 	Name        string `json:"name"`
+	Slug        string `json:"slug" binding:"max=191"`
 	Description string `json:"description"`
 }
 
@@ -91,8 +105,26 @@ func (h *Handler) Update(c *gin.Context) {
 		updates["description"] = req.Description
 	}
 
+	oldSlug := category.Slug
+	if req.Slug != "" && slug.Make(req.Slug) != oldSlug {
+		s, err := slug.For(h.db, &models.Category{}, req.Slug, "", slug.KindCategory, category.ID)
+		if err != nil {
+			slug.WriteError(c, err)
+			return
+		}
+		updates["slug"] = s
+	}
+
 	if len(updates) > 0 {
-		if err := h.db.Model(&category).Updates(updates).Error; err != nil {
+		err := h.db.Transaction(func(tx *gorm.DB) error {
+			if s, ok := updates["slug"].(string); ok {
+				if err := slug.Moved(tx, slug.KindCategory, oldSlug, s, category.ID); err != nil {
+					return err
+				}
+			}
+			return tx.Model(&category).Updates(updates).Error
+		})
+		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
@@ -125,3 +157,19 @@ func (h *Handler) Delete(c *gin.Context) {
 
 	c.JSON(http.StatusOK, gin.H{"result": "ok"})
 }
+
+// GetBySlug serves a category by its slug; old slugs redirect to the current one
+func (h *Handler) GetBySlug(c *gin.Context) {
+	var category models.Category
+	if err := h.db.Where("slug = ?", c.Param("slug")).First(&category).Error; err != nil {
+		if id, ok := slug.Resolve(h.db, slug.KindCategory, c.Param("slug")); ok {
+			if h.db.Select("id", "slug").First(&category, id).Error == nil {
+				slug.Redirect(c, category.Slug)
+				return
+			}
+		}
+		c.JSON(http.StatusNotFound, gin.H{"error": "category not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": category})
+}
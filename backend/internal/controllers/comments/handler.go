@@ -3,22 +3,46 @@ package comments
 import (
 	"net/http"
 
+	"easyblog/internal/config"
+	"easyblog/internal/keys"
 	"easyblog/internal/models"
+	"easyblog/internal/postaccess"
+	"easyblog/internal/rbac"
+	"easyblog/internal/render"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 )
 
-type Handler struct{ db *gorm.DB }
+type Handler struct {
+	db   *gorm.DB
+	cfg  *config.Config
+	keys *keys.KeySet
+}
 
-func NewHandler(db *gorm.DB) *Handler { return &Handler{db: db} }
+func NewHandler(db *gorm.DB, cfg *config.Config, ks *keys.KeySet) *Handler {
+	return &Handler{db: db, cfg: cfg, keys: ks}
+}
 
+// ListByPost lists the comments of a post the request may read
 func (h *Handler) ListByPost(c *gin.Context) {
+	var post models.Post
+	if err := h.db.First(&post, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+	if err := postaccess.Check(c, h.keys, post); err != nil {
+		postaccess.WriteError(c, post, err)
+		return
+	}
 	var items []models.Comment
-	if err := h.db.Where("post_id = ?", c.Param("id")).Order("created_at DESC").Find(&items).Error; err != nil {
+	if err := h.db.Where("post_id = ?", post.ID).Order("created_at DESC").Find(&items).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	for i := range items {
+		items[i].ContentHTML = render.Comment(items[i].Content)
+	}
 	c.JSON(http.StatusOK, gin.H{"items": items})
 }
 
@@ -37,16 +61,46 @@ func (h *Handler) Create(c *gin.Context) {
 		return
 	}
 	user := uVal.(models.User)
+	if h.cfg.Server.Auth.RequireVerifiedEmail != "off" && !user.EmailVerified {
+		c.JSON(http.StatusForbidden, gin.H{"error": "email not verified"})
+		return
+	}
+	// only posts the user can read take comments
+	var post models.Post
+	if err := h.db.First(&post, body.PostID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+	if err := postaccess.Check(c, h.keys, post); err != nil {
+		postaccess.WriteError(c, post, err)
+		return
+	}
 	cm := models.Comment{PostID: body.PostID, UserID: user.ID, Content: body.Content}
 	if err := h.db.Create(&cm).Error; err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	cm.ContentHTML = render.Comment(cm.Content)
 	c.JSON(http.StatusCreated, cm)
 }
 
 func (h *Handler) Delete(c *gin.Context) {
-	if err := h.db.Delete(&models.Comment{}, c.Param("id")).Error; err != nil {
+	var cm models.Comment
+	if err := h.db.First(&cm, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+	uVal, ok := c.Get("user")
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	user := uVal.(models.User)
+	if cm.UserID != user.ID && !rbac.Allowed(c, rbac.CommentsDeleteAny) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "permission denied"})
+		return
+	}
+	if err := h.db.Delete(&cm).Error; err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
@@ -39,19 +39,6 @@ func (h *Handler) Create(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	// current user
-	userVal, exists := c.Get("user")
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
-		return
-	}
-	user := userVal.(models.User)
-
-	if user.Role != models.RoleAdmin {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "you are not admin"})
-		return
-	}
-
 	friendsLink := models.FriendsLink{
 		Title:       req.Title,
 		Link:        req.Link,
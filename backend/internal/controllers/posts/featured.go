@@ -0,0 +1,99 @@
+package posts
+
+import (
+	"math"
+	"net/http"
+	"time"
+
+	"easyblog/internal/models"
+	"easyblog/internal/postaccess"
+	"easyblog/internal/postlist"
+	"easyblog/internal/utils"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Featured lists published featured posts, most recently featured first
+func (h *Handler) Featured(c *gin.Context) {
+	fields, ok := postlist.ParseFields(c)
+	if !ok {
+		return
+	}
+	page := utils.QueryInt(c, "page", 0, 0, math.MaxInt)
+	size := utils.QueryInt(c, "size", 10, 1, 20)
+	h.list(c, func(db *gorm.DB) *gorm.DB {
+		return db.Where("posts.status = ? AND posts.featured = ?", models.PostPublished, true).Scopes(postaccess.Listed(c))
+	}, "posts.featured_at DESC", page, size, fields)
+}
+
+type pinRequest struct {
+	Pinned bool `json:"pinned"`
+	// a pin without pinned_until stays until it is lifted
+	PinnedUntil *time.Time `json:"pinned_until"`
+}
+
+// Pin pins or unpins a post. Pinning is an editorial choice about the front
+// page rather than an edit, so updated_at is left alone.
+func (h *Handler) Pin(c *gin.Context) {
+	var post models.Post
+	if err := h.db.First(&post, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+	var body pinRequest
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	post.Pinned, post.PinnedUntil = body.Pinned, nil
+	if body.Pinned && body.PinnedUntil != nil {
+		if !body.PinnedUntil.After(time.Now()) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "pinned_until must be in the future"})
+			return
+		}
+		post.PinnedUntil = utc(body.PinnedUntil)
+	}
+	err := h.db.Model(&post).UpdateColumns(map[string]interface{}{
+		"pinned":       post.Pinned,
+		"pinned_until": post.PinnedUntil,
+	}).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, post)
+}
+
+// Feature marks a post as featured or takes the mark away
+func (h *Handler) Feature(c *gin.Context) {
+	var post models.Post
+	if err := h.db.First(&post, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+	var body struct {
+		Featured bool `json:"featured"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	switch {
+	case !body.Featured:
+		post.FeaturedAt = nil
+	case !post.Featured:
+		now := time.Now().UTC()
+		post.FeaturedAt = &now
+	}
+	post.Featured = body.Featured
+	err := h.db.Model(&post).UpdateColumns(map[string]interface{}{
+		"featured":    post.Featured,
+		"featured_at": post.FeaturedAt,
+	}).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, post)
+}
@@ -1,49 +1,87 @@
 package posts
 
 import (
+	"easyblog/internal/config"
+	"easyblog/internal/keys"
 	"easyblog/internal/models"
+	"easyblog/internal/postaccess"
+	"easyblog/internal/postlist"
+	"easyblog/internal/rbac"
+	"easyblog/internal/render"
+	"easyblog/internal/search"
+	"easyblog/internal/slug"
 	"easyblog/internal/utils"
 	"errors"
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 	"math"
 	"net/http"
+	"time"
 )
 
 type Handler struct {
-	db *gorm.DB
+	db    *gorm.DB
+	cfg   *config.Config
+	keys  *keys.KeySet
+	index search.Engine
 }
 
-func NewHandler(db *gorm.DB) *Handler {
-	return &Handler{db: db}
+func NewHandler(db *gorm.DB, cfg *config.Config, ks *keys.KeySet, index search.Engine) *Handler {
+	return &Handler{db: db, cfg: cfg, keys: ks, index: index}
 }
 
 type createPostRequest struct {
 	Title       string `json:"title" binding:"required"`
+	Slug        string `json:"slug" binding:"max=191"` // derived from the title when empty
 	Content     string `json:"content" binding:"required"`
 	Summary     string `json:"summary"`
 	CoverImage  string `json:"cover_image"`
 	CategoryIDs []uint `json:"category_ids"`
 	TagIDs      []uint `json:"tag_ids"`
+	// empty keeps the current visibility, and password the current password
+	Visibility models.PostVisibility `json:"visibility" binding:"omitempty,oneof=public unlisted private password"`
+	Password   string                `json:"password" binding:"max=128"`
 }
 
 func (h *Handler) List(c *gin.Context) {
-	var posts []models.Post
-	q := h.db.Preload("Author").Preload("Categories").Preload("Tags")
+	filter, ok := statusFilter(c)
+	if !ok {
+		return
+	}
+	narrow, ok := listFilters(c)
+	if !ok {
+		return
+	}
+	fields, ok := postlist.ParseFields(c)
+	if !ok {
+		return
+	}
 	if k := c.Query("q"); k != "" {
-		like := "%" + k + "%"
-		q = q.Where("title LIKE ? OR summary LIKE ?", like, like)
+		h.search(c, k, func(db *gorm.DB) *gorm.DB {
+			return narrow(filter(db)).Scopes(postaccess.Listed(c), searchable(c))
+		}, fields)
+		return
 	}
 	page := utils.QueryInt(c, "page", 0, 0, math.MaxInt)
 	size := utils.QueryInt(c, "size", 10, 1, 20)
+	// pinned posts come first, ordering them keeps later pages free of repeats
+	h.list(c, func(db *gorm.DB) *gorm.DB { return narrow(filter(db)).Scopes(postaccess.Listed(c)) }, "posts.pinned DESC, posts.created_at DESC", page, size, fields)
+}
 
+// list writes a page of the posts matching scope in order
+func (h *Handler) list(c *gin.Context, scope func(*gorm.DB) *gorm.DB, order string, page, size int, fields postlist.Fields) {
 	var total int64
-	q.Model(&models.Post{}).Count(&total)
-	if err := q.Limit(size).Offset(page * size).Order("created_at DESC").Find(&posts).Error; err != nil {
+	if err := h.db.Model(&models.Post{}).Scopes(scope).Count(&total).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	items, err := postlist.Find(h.db.Model(&models.Post{}).Scopes(scope).
+		Order(order).Limit(size).Offset(page*size), fields)
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"total": total, "items": posts})
+	postlist.Write(c, total, items, fields)
 }
 
 func (h *Handler) Get(c *gin.Context) {
@@ -52,8 +90,48 @@ func (h *Handler) Get(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
 		return
 	}
-	// increment view count
-	h.db.Model(&post).UpdateColumn("view_count", gorm.Expr("view_count + 1"))
+	if err := postaccess.Check(c, h.keys, post); err != nil {
+		postaccess.WriteError(c, post, err)
+		return
+	}
+	// increment view count, previews by editors do not count
+	if post.Status == models.PostPublished {
+		h.db.Model(&post).UpdateColumn("view_count", gorm.Expr("view_count + 1"))
+	}
+	post.ContentHTML = h.contentHTML(post)
+	post.Series = h.seriesNav(c, post)
+	c.JSON(http.StatusOK, post)
+}
+
+// GetBySlug serves a post by its permalink; old slugs redirect to the current one
+func (h *Handler) GetBySlug(c *gin.Context) {
+	var post models.Post
+	err := h.db.Preload("Author").Preload("Categories").Preload("Tags").Where("slug = ?", c.Param("slug")).First(&post).Error
+	if err == nil {
+		if err := postaccess.Check(c, h.keys, post); err != nil {
+			postaccess.WriteError(c, post, err)
+			return
+		}
+	}
+	if err != nil {
+		// follow an old slug only to a post the request may know about,
+		// otherwise the redirect would leak the new slug of a draft
+		if id, ok := slug.Resolve(h.db, slug.KindPost, c.Param("slug")); ok {
+			var target models.Post
+			if h.db.First(&target, id).Error == nil &&
+				!errors.Is(postaccess.Check(c, h.keys, target), postaccess.ErrHidden) {
+				slug.Redirect(c, target.Slug)
+				return
+			}
+		}
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+	if post.Status == models.PostPublished {
+		h.db.Model(&post).UpdateColumn("view_count", gorm.Expr("view_count + 1"))
+	}
+	post.ContentHTML = h.contentHTML(post)
+	post.Series = h.seriesNav(c, post)
 	c.JSON(http.StatusOK, post)
 }
 
@@ -71,7 +149,16 @@ func (h *Handler) Create(c *gin.Context) {
 	}
 	user := userVal.(models.User)
 
-	post := models.Post{Title: req.Title, Content: req.Content, Summary: req.Summary, CoverImage: req.CoverImage, AuthorID: user.ID}
+	postSlug, err := slug.For(h.db, &models.Post{}, req.Slug, req.Title, slug.KindPost, 0)
+	if err != nil {
+		slug.WriteError(c, err)
+		return
+	}
+	post := models.Post{Title: req.Title, Slug: postSlug, Content: req.Content, Summary: req.Summary, CoverImage: req.CoverImage, AuthorID: user.ID}
+	if !h.applyVisibility(c, &post, req) {
+		return
+	}
+	render.Analyze(post.Content).ApplyTo(&post)
 	// associations
 	if len(req.CategoryIDs) > 0 {
 		var cats []models.Category
@@ -83,10 +170,20 @@ func (h *Handler) Create(c *gin.Context) {
 		h.db.Find(&tags, req.TagIDs)
 		post.Tags = tags
 	}
-	if err := h.db.Create(&post).Error; err != nil {
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&post).Error; err != nil {
+			return err
+		}
+		if err := h.recordRevision(tx, post, user.ID); err != nil {
+			return err
+		}
+		return slug.Claim(tx, slug.KindPost, post.Slug)
+	})
+	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	h.reindex(post)
 	c.JSON(http.StatusCreated, post)
 }
 
@@ -100,22 +197,58 @@ func (h *Handler) Update(c *gin.Context) {
 		}
 		return
 	}
+	if !canModify(c, post, rbac.PostsUpdateAny) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "permission denied"})
+		return
+	}
+	relatedBefore := h.relatedInputs(post)
 
 	var req createPostRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	// the slug only changes on request so permalinks stay stable across title edits
+	oldSlug, newSlug := post.Slug, post.Slug
+	if req.Slug != "" && slug.Make(req.Slug) != post.Slug || post.Slug == "" {
+		s, err := slug.For(h.db, &models.Post{}, req.Slug, req.Title, slug.KindPost, post.ID)
+		if err != nil {
+			slug.WriteError(c, err)
+			return
+		}
+		newSlug = s
+	}
+	if !h.applyVisibility(c, &post, req) {
+		return
+	}
 
+	editor := c.MustGet("user").(models.User)
 	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := h.recordBaseline(tx, post); err != nil {
+			return err
+		}
+		if newSlug != oldSlug {
+			post.Slug = newSlug
+			if err := slug.Moved(tx, slug.KindPost, oldSlug, newSlug, post.ID); err != nil {
+				return err
+			}
+		}
 		post.Title = req.Title
 		post.Content = req.Content
-		post.Summary = req.Summary
+		// a generated summary sent back unchanged stays generated
+		if req.Summary != post.Summary || !post.SummaryAuto {
+			post.Summary = req.Summary
+			post.SummaryAuto = false
+		}
 		post.CoverImage = req.CoverImage
+		render.Analyze(post.Content).ApplyTo(&post)
 
 		if err := tx.Save(&post).Error; err != nil {
 			return err
 		}
+		if err := h.recordRevision(tx, post, editor.ID); err != nil {
+			return err
+		}
 		if len(req.CategoryIDs) > 0 {
 			var cats []models.Category
 			if err := tx.Find(&cats, req.CategoryIDs).Error; err != nil {
@@ -158,11 +291,30 @@ func (h *Handler) Update(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load updated post"})
 		return
 	}
+	h.reindex(post)
+	if h.relatedInputs(post) != relatedBefore {
+		h.refreshRelated(post)
+	}
 	c.JSON(http.StatusOK, post)
 }
 
 func (h *Handler) Delete(c *gin.Context) {
-	if err := h.db.Delete(&models.Post{}, c.Param("id")).Error; err != nil {
+	var post models.Post
+	if err := h.db.First(&post, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+	if !canModify(c, post, rbac.PostsDeleteAny) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "permission denied"})
+		return
+	}
+	if err := h.db.Delete(&post).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	h.unindex(post.ID)
+	h.relatedChanged(post.ID)
+	if err := h.db.Where("post_id = ?", post.ID).Delete(&models.SeriesPart{}).Error; err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
@@ -173,28 +325,72 @@ func (h *Handler) Delete(c *gin.Context) {
 	c.Status(http.StatusNoContent)
 }
 
+type publishRequest struct {
+	Publish bool `json:"publish"`
+	// a future publish_at schedules the post instead of publishing it now
+	PublishAt   *time.Time `json:"publish_at"`
+	UnpublishAt *time.Time `json:"unpublish_at"`
+}
+
+// utc converts t to UTC. Schedule times are compared in queries, which
+// sqlite does on the stored text, so they must share one offset.
+func utc(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	u := t.UTC()
+	return &u
+}
+
 func (h *Handler) Publish(c *gin.Context) {
 	var post models.Post
 	if err := h.db.First(&post, c.Param("id")).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
 		return
 	}
-	var body struct {
-		Publish bool `json:"publish"`
+	if !canModify(c, post, rbac.PostsPublishAny) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "permission denied"})
+		return
 	}
+	var body publishRequest
 	if err := c.ShouldBindJSON(&body); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	if body.Publish {
-		post.Status = models.PostPublished
-	} else {
+	body.PublishAt, body.UnpublishAt = utc(body.PublishAt), utc(body.UnpublishAt)
+	now := time.Now().UTC()
+	wasStatus := post.Status
+	post.PublishAt, post.UnpublishAt = nil, nil
+	switch {
+	case !body.Publish:
 		post.Status = models.PostDraft
+	case body.PublishAt != nil && body.PublishAt.After(now):
+		post.Status = models.PostScheduled
+		post.PublishAt = body.PublishAt
+	default:
+		post.Status = models.PostPublished
+		if post.PublishedAt == nil {
+			post.PublishedAt = &now
+		}
+	}
+	if body.Publish && body.UnpublishAt != nil {
+		start := now
+		if post.PublishAt != nil {
+			start = *post.PublishAt
+		}
+		if !body.UnpublishAt.After(start) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "unpublish_at must be after the publish time"})
+			return
+		}
+		post.UnpublishAt = body.UnpublishAt
 	}
 	if err := h.db.Save(&post).Error; err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if post.Status != wasStatus {
+		h.relatedChanged(post.ID)
+	}
 	c.JSON(http.StatusOK, post)
 }
 
@@ -204,33 +400,48 @@ func (h *Handler) GetPostsByCategory(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "category not found"})
 		return
 	}
-	var posts []models.Post
-	err := h.db.Model(&category).
-		Association("Posts").
-		Find(&posts)
-
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	filter, ok := statusFilter(c)
+	if !ok {
 		return
 	}
-	for i := range posts {
-		h.db.Preload("Categories").Preload("Tags").First(&posts[i], posts[i].ID)
+	fields, ok := postlist.ParseFields(c)
+	if !ok {
+		return
 	}
-	c.JSON(http.StatusOK, posts)
+	page := utils.QueryInt(c, "page", 0, 0, math.MaxInt)
+	size := utils.QueryInt(c, "size", 10, 1, 20)
+	h.list(c, func(db *gorm.DB) *gorm.DB {
+		return filter(db).Scopes(postaccess.Listed(c)).Where("posts.id IN (SELECT post_id FROM post_categories WHERE category_id = ?)", category.ID)
+	}, "posts.created_at DESC", page, size, fields)
 }
 
 func (h *Handler) GetPostsByTag(c *gin.Context) {
-	tagID := c.Param("id")
-	var posts []models.Post
-	err := h.db.
-		Joins("JOIN post_tags ON post_tags.post_id = posts.id").
-		Where("post_tags.tag_id = ?", tagID).
-		Preload("Categories").
-		Preload("Tags").
-		Find(&posts).Error
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	var tag models.Tag
+	if err := h.db.First(&tag, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "tag not found"})
 		return
 	}
-	c.JSON(http.StatusOK, posts)
+	filter, ok := statusFilter(c)
+	if !ok {
+		return
+	}
+	fields, ok := postlist.ParseFields(c)
+	if !ok {
+		return
+	}
+	page := utils.QueryInt(c, "page", 0, 0, math.MaxInt)
+	size := utils.QueryInt(c, "size", 10, 1, 20)
+	h.list(c, func(db *gorm.DB) *gorm.DB {
+		return filter(db).Scopes(postaccess.Listed(c)).Where("posts.id IN (SELECT post_id FROM post_tags WHERE tag_id = ?)", tag.ID)
+	}, "posts.created_at DESC", page, size, fields)
+}
+
+// canModify reports whether the current user owns post or holds anyPerm
+func canModify(c *gin.Context, post models.Post, anyPerm rbac.Permission) bool {
+	userVal, exists := c.Get("user")
+	if !exists {
+		return false
+	}
+	user := userVal.(models.User)
+	return post.AuthorID == user.ID || rbac.Allowed(c, anyPerm)
 }
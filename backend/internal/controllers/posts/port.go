@@ -0,0 +1,440 @@
+package posts
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"time"
+
+	"easyblog/internal/mdport"
+	"easyblog/internal/models"
+	"easyblog/internal/rbac"
+	"easyblog/internal/render"
+	"easyblog/internal/slug"
+	"easyblog/internal/utils"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ImportOptions controls how Markdown files become posts
+type ImportOptions struct {
+	// AuthorID owns created posts and is recorded as editor of updated ones
+	AuthorID uint
+	// Publish lets files that are not drafts publish, otherwise every
+	// imported post is a draft
+	Publish bool
+	// Update overwrites posts whose slug exists instead of skipping them;
+	// UpdateAny allows it for posts of other authors
+	Update    bool
+	UpdateAny bool
+	// missing tags and categories are created only with these, files naming
+	// unknown ones fail otherwise
+	CreateTags       bool
+	CreateCategories bool
+}
+
+// ImportResult reports what happened to one file
+type ImportResult struct {
+	File   string `json:"file"`
+	Status string `json:"status"` // created, updated, skipped or failed
+	PostID uint   `json:"post_id,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// errSkipped marks files whose post exists and is left alone
+var errSkipped = errors.New("post exists")
+
+// ImportFiles creates or updates a post for every file; tags and categories
+// named in the front matter are created when missing. Files are imported
+// one by one, a broken file does not stop the others.
+func (h *Handler) ImportFiles(files []mdport.File, opts ImportOptions) []ImportResult {
+	results := make([]ImportResult, 0, len(files))
+	var changed []uint
+	for _, f := range files {
+		res := ImportResult{File: f.Name}
+		post, created, err := h.importFile(f, opts)
+		switch {
+		case errors.Is(err, errSkipped):
+			res.Status = "skipped"
+			// the id of someone else's post is none of the importer's business
+			if post.AuthorID == opts.AuthorID || opts.UpdateAny {
+				res.PostID = post.ID
+			}
+		case err != nil:
+			res.Status, res.Error = "failed", err.Error()
+		default:
+			res.Status, res.PostID = "updated", post.ID
+			if created {
+				res.Status = "created"
+			}
+			h.reindex(post)
+			changed = append(changed, post.ID)
+		}
+		results = append(results, res)
+	}
+	if len(changed) > 0 {
+		h.relatedChanged(changed...)
+	}
+	return results
+}
+
+func (h *Handler) importFile(f mdport.File, opts ImportOptions) (models.Post, bool, error) {
+	var post models.Post
+	doc, err := mdport.Parse(f.Data)
+	if err != nil {
+		return post, false, err
+	}
+	meta := doc.Meta
+	if meta.Title == "" {
+		return post, false, errors.New("front matter has no title")
+	}
+	if strings.TrimSpace(doc.Body) == "" {
+		return post, false, errors.New("post has no content")
+	}
+	requested := meta.Slug
+	if requested == "" {
+		requested = mdport.SlugFromName(f.Name)
+	}
+
+	created := true
+	if s := slug.Make(requested); s != "" {
+		err := h.db.Where("slug = ?", s).Limit(1).Find(&post).Error
+		if err != nil {
+			return post, false, err
+		}
+		created = post.ID == 0
+	}
+	if !created {
+		if !opts.Update {
+			return post, false, errSkipped
+		}
+		if post.AuthorID != opts.AuthorID && !opts.UpdateAny {
+			return post, false, errors.New("permission denied")
+		}
+	} else {
+		s, err := slug.For(h.db, &models.Post{}, requested, meta.Title, slug.KindPost, 0)
+		if err != nil {
+			return post, false, err
+		}
+		post = models.Post{Slug: s, AuthorID: opts.AuthorID}
+	}
+
+	post.Title = meta.Title
+	post.Content = doc.Body
+	post.Summary, post.SummaryAuto = meta.Summary, false
+	if err := h.importVisibility(&post, meta); err != nil {
+		return post, false, err
+	}
+	now := time.Now().UTC()
+	date := now
+	if meta.Date != nil {
+		date = meta.Date.UTC()
+	}
+	post.PublishAt, post.UnpublishAt = nil, nil
+	switch {
+	case meta.Draft || !opts.Publish:
+		post.Status = models.PostDraft
+	case date.After(now):
+		post.Status = models.PostScheduled
+		post.PublishAt = &date
+	default:
+		post.Status = models.PostPublished
+		post.PublishedAt = &date
+	}
+	if created {
+		// keeps the original order of imported posts in listings
+		post.CreatedAt = date
+	}
+	render.Analyze(post.Content).ApplyTo(&post)
+
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		tags, err := tagsNamed(tx, meta.Tags, opts.CreateTags)
+		if err != nil {
+			return err
+		}
+		cats, err := categoriesNamed(tx, meta.Categories, opts.CreateCategories)
+		if err != nil {
+			return err
+		}
+		if created {
+			post.Tags, post.Categories = tags, cats
+			if err := tx.Create(&post).Error; err != nil {
+				return err
+			}
+			if err := slug.Claim(tx, slug.KindPost, post.Slug); err != nil {
+				return err
+			}
+			return h.recordRevision(tx, post, opts.AuthorID)
+		}
+		if err := h.recordBaseline(tx, post); err != nil {
+			return err
+		}
+		if err := tx.Save(&post).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&post).Association("Tags").Replace(tags); err != nil {
+			return err
+		}
+		if err := tx.Model(&post).Association("Categories").Replace(cats); err != nil {
+			return err
+		}
+		return h.recordRevision(tx, post, opts.AuthorID)
+	})
+	return post, created, err
+}
+
+// importVisibility applies visibility and password from the front matter;
+// a password alone makes the post password protected
+func (h *Handler) importVisibility(post *models.Post, meta mdport.Meta) error {
+	post.Visibility = models.PostVisibility(meta.Visibility)
+	switch post.Visibility {
+	case "":
+		post.Visibility = models.VisibilityPublic
+	case models.VisibilityPublic, models.VisibilityUnlisted, models.VisibilityPrivate, models.VisibilityPassword:
+	default:
+		return fmt.Errorf("unknown visibility %q", meta.Visibility)
+	}
+	if meta.Password != "" {
+		hash, err := utils.HashPassword(h.cfg.Server.Password, meta.Password)
+		if err != nil {
+			return err
+		}
+		post.Visibility, post.PasswordHash = models.VisibilityPassword, hash
+	}
+	if post.Visibility != models.VisibilityPassword {
+		post.PasswordHash = ""
+	} else if post.PasswordHash == "" {
+		return errors.New("password visibility needs a password")
+	}
+	return nil
+}
+
+// maxNameLen is the size of the name columns of tags and categories
+const maxNameLen = 64
+
+// tagsNamed finds the tags named in names, creating missing ones if create
+func tagsNamed(tx *gorm.DB, names []string, create bool) ([]models.Tag, error) {
+	var out []models.Tag
+	for _, name := range uniqueNames(names) {
+		if len([]rune(name)) > maxNameLen {
+			return nil, fmt.Errorf("tag %q is too long", name)
+		}
+		var tag models.Tag
+		if err := tx.Where("LOWER(name) = LOWER(?)", name).Limit(1).Find(&tag).Error; err != nil {
+			return nil, err
+		}
+		if tag.ID == 0 && !create {
+			return nil, fmt.Errorf("tag %q does not exist", name)
+		}
+		if tag.ID == 0 {
+			s, err := slug.For(tx, &models.Tag{}, "", name, slug.KindTag, 0)
+			if err != nil {
+				return nil, err
+			}
+			tag = models.Tag{Name: name, Slug: s}
+			if err := tx.Create(&tag).Error; err != nil {
+				return nil, err
+			}
+			if err := slug.Claim(tx, slug.KindTag, s); err != nil {
+				return nil, err
+			}
+		}
+		out = append(out, tag)
+	}
+	return out, nil
+}
+
+// categoriesNamed finds the categories named in names, creating missing
+// ones if create
+func categoriesNamed(tx *gorm.DB, names []string, create bool) ([]models.Category, error) {
+	var out []models.Category
+	for _, name := range uniqueNames(names) {
+		if len([]rune(name)) > maxNameLen {
+			return nil, fmt.Errorf("category %q is too long", name)
+		}
+		var cat models.Category
+		if err := tx.Where("LOWER(name) = LOWER(?)", name).Limit(1).Find(&cat).Error; err != nil {
+			return nil, err
+		}
+		if cat.ID == 0 && !create {
+			return nil, fmt.Errorf("category %q does not exist", name)
+		}
+		if cat.ID == 0 {
+			s, err := slug.For(tx, &models.Category{}, "", name, slug.KindCategory, 0)
+			if err != nil {
+				return nil, err
+			}
+			cat = models.Category{Name: name, Slug: s}
+			if err := tx.Create(&cat).Error; err != nil {
+				return nil, err
+			}
+			if err := slug.Claim(tx, slug.KindCategory, s); err != nil {
+				return nil, err
+			}
+		}
+		out = append(out, cat)
+	}
+	return out, nil
+}
+
+func uniqueNames(names []string) []string {
+	seen := map[string]bool{}
+	var out []string
+	for _, name := range names {
+		key := strings.ToLower(name)
+		if name == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		out = append(out, name)
+	}
+	return out
+}
+
+// ExportFiles writes the posts matching scope as Markdown files named after
+// their slugs, in the layout ImportFiles reads
+func (h *Handler) ExportFiles(scope func(*gorm.DB) *gorm.DB) ([]mdport.File, error) {
+	var posts []models.Post
+	if err := h.db.Scopes(scope).Preload("Tags").Preload("Categories").Order("id").Find(&posts).Error; err != nil {
+		return nil, err
+	}
+	files := make([]mdport.File, 0, len(posts))
+	for _, p := range posts {
+		meta := mdport.Meta{
+			Title: p.Title,
+			Slug:  p.Slug,
+			Draft: p.Status == models.PostDraft,
+		}
+		date := p.CreatedAt
+		switch {
+		case p.Status == models.PostScheduled && p.PublishAt != nil:
+			date = *p.PublishAt
+		case p.PublishedAt != nil:
+			date = *p.PublishedAt
+		}
+		meta.Date = &date
+		if !p.SummaryAuto {
+			meta.Summary = p.Summary
+		}
+		for _, t := range p.Tags {
+			meta.Tags = append(meta.Tags, t.Name)
+		}
+		for _, c := range p.Categories {
+			meta.Categories = append(meta.Categories, c.Name)
+		}
+		switch p.Visibility {
+		case models.VisibilityUnlisted, models.VisibilityPrivate:
+			meta.Visibility = string(p.Visibility)
+		case models.VisibilityPassword:
+			// only the hash is stored, such posts come back private
+			meta.Visibility = string(models.VisibilityPrivate)
+		}
+		data, err := mdport.Format(mdport.Doc{Meta: meta, Body: p.Content})
+		if err != nil {
+			return nil, fmt.Errorf("post %d: %w", p.ID, err)
+		}
+		files = append(files, mdport.File{Name: p.Slug + ".md", Data: data})
+	}
+	return files, nil
+}
+
+// Import takes a zip archive in the "file" form field, or Markdown files in
+// "files" fields (a directory upload), and imports them as the current
+// user. ?update=true overwrites posts with the same slug.
+func (h *Handler) Import(c *gin.Context) {
+	user := c.MustGet("user").(models.User)
+	limit := int64(h.cfg.Server.Posts.ImportMaxMB) << 20
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit)
+	form, err := c.MultipartForm()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	files, err := uploadedFiles(form)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(files) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "no markdown files found"})
+		return
+	}
+	results := h.ImportFiles(files, ImportOptions{
+		AuthorID:  user.ID,
+		Publish:   rbac.Allowed(c, rbac.PostsPublish),
+		Update:    c.Query("update") == "true",
+		UpdateAny: rbac.Allowed(c, rbac.PostsUpdateAny),
+		// the CLI runs as admin and creates them unconditionally
+		CreateTags:       rbac.Allowed(c, rbac.TagsManage),
+		CreateCategories: rbac.Allowed(c, rbac.CategoriesManage),
+	})
+	counts := map[string]int{"created": 0, "updated": 0, "skipped": 0, "failed": 0}
+	for _, r := range results {
+		counts[r.Status]++
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"items":   results,
+		"created": counts["created"],
+		"updated": counts["updated"],
+		"skipped": counts["skipped"],
+		"failed":  counts["failed"],
+	})
+}
+
+func uploadedFiles(form *multipart.Form) ([]mdport.File, error) {
+	if archives := form.File["file"]; len(archives) > 0 {
+		fh := archives[0]
+		f, err := fh.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return mdport.ReadZip(f, fh.Size)
+	}
+	var files []mdport.File
+	for _, fh := range form.File["files"] {
+		if fh.Size > mdport.MaxFileSize {
+			return nil, fmt.Errorf("%s: file too large", fh.Filename)
+		}
+		f, err := fh.Open()
+		if err != nil {
+			return nil, err
+		}
+		data, err := io.ReadAll(f)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, mdport.File{Name: fh.Filename, Data: data})
+	}
+	return mdport.Markdown(files), nil
+}
+
+// Export downloads posts as a zip of Markdown files: every post for
+// editors, the user's own posts for everyone else
+func (h *Handler) Export(c *gin.Context) {
+	user := c.MustGet("user").(models.User)
+	all := rbac.Allowed(c, rbac.PostsUpdateAny)
+	files, err := h.ExportFiles(func(db *gorm.DB) *gorm.DB {
+		if all {
+			return db
+		}
+		return db.Where("author_id = ?", user.ID)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	name := "easyblog-export-" + time.Now().Format("20060102") + ".zip"
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", `attachment; filename="`+name+`"`)
+	if err := mdport.WriteZip(c.Writer, files); err != nil {
+		// headers are gone, all that is left is to cut the download short
+		c.Error(err)
+		c.Abort()
+	}
+}
@@ -0,0 +1,160 @@
+package posts
+
+import (
+	"bytes"
+	"testing"
+
+	"easyblog/internal/mdport"
+	"easyblog/internal/models"
+
+	"gorm.io/gorm"
+)
+
+var importOptions = ImportOptions{AuthorID: 1, Publish: true, CreateTags: true, CreateCategories: true}
+
+// exportAll exports every post of h
+func exportAll(t *testing.T, h *Handler) []mdport.File {
+	t.Helper()
+	files, err := h.ExportFiles(func(db *gorm.DB) *gorm.DB { return db })
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestImportExportRoundTrip(t *testing.T) {
+	files := []mdport.File{
+		{Name: "hello.md", Data: []byte("---\ntitle: Hello\ndate: 2024-05-01T10:00:00+02:00\ntags: [go, web]\ncategories: [notes]\nsummary: A summary\n---\n\n# Hello\n\nSome text.\n")},
+		{Name: "bundle/index.md", Data: []byte("+++\ntitle = \"Bundle\"\ndate = 2024-06-01T08:00:00Z\ndraft = true\n+++\nDraft text.\n")},
+		{Name: "unlisted.md", Data: []byte("---\ntitle: Unlisted\ndate: 2024-07-01T00:00:00Z\nslug: hidden\nvisibility: unlisted\ncategories: [notes]\n---\nHidden text.\n")},
+	}
+	h, db := newTestHandler(t)
+	for _, r := range h.ImportFiles(files, importOptions) {
+		if r.Status != "created" {
+			t.Fatalf("%s: %s %s", r.File, r.Status, r.Error)
+		}
+	}
+	exported := exportAll(t, h)
+	if len(exported) != len(files) {
+		t.Fatalf("exported %d files, want %d", len(exported), len(files))
+	}
+	wantNames := []string{"hello.md", "bundle.md", "hidden.md"}
+	for i, f := range exported {
+		if f.Name != wantNames[i] {
+			t.Errorf("file %d = %s, want %s", i, f.Name, wantNames[i])
+		}
+		in, err := mdport.Parse(files[i].Data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := mdport.Parse(f.Data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if in.Body != out.Body || in.Meta.Title != out.Meta.Title || in.Meta.Draft != out.Meta.Draft ||
+			in.Meta.Summary != out.Meta.Summary || in.Meta.Visibility != out.Meta.Visibility ||
+			!in.Meta.Date.Equal(*out.Meta.Date) ||
+			len(in.Meta.Tags) != len(out.Meta.Tags) || len(in.Meta.Categories) != len(out.Meta.Categories) {
+			t.Errorf("%s: exported %+v, imported %+v", f.Name, out, in)
+		}
+	}
+	var published int64
+	db.Model(&models.Post{}).Where("status = ?", models.PostPublished).Count(&published)
+	if published != 2 {
+		t.Errorf("published posts = %d, want 2", published)
+	}
+
+	t.Run("export imports unchanged", func(t *testing.T) {
+		h2, _ := newTestHandler(t)
+		for _, r := range h2.ImportFiles(exported, importOptions) {
+			if r.Status != "created" {
+				t.Fatalf("%s: %s %s", r.File, r.Status, r.Error)
+			}
+		}
+		again := exportAll(t, h2)
+		for i := range exported {
+			if again[i].Name != exported[i].Name || !bytes.Equal(again[i].Data, exported[i].Data) {
+				t.Errorf("%s: exported again as %s:\n%s\nwant\n%s", exported[i].Name, again[i].Name, again[i].Data, exported[i].Data)
+			}
+		}
+	})
+
+	t.Run("update in place", func(t *testing.T) {
+		opts := importOptions
+		opts.Update = true
+		for _, r := range h.ImportFiles(exported, opts) {
+			if r.Status != "updated" {
+				t.Errorf("%s: %s %s", r.File, r.Status, r.Error)
+			}
+		}
+		again := exportAll(t, h)
+		for i := range exported {
+			if !bytes.Equal(again[i].Data, exported[i].Data) {
+				t.Errorf("%s: changed by updating it with itself:\n%s", exported[i].Name, again[i].Data)
+			}
+		}
+	})
+
+	t.Run("skip existing", func(t *testing.T) {
+		for _, r := range h.ImportFiles(exported, importOptions) {
+			if r.Status != "skipped" {
+				t.Errorf("%s: %s %s", r.File, r.Status, r.Error)
+			}
+		}
+	})
+}
+
+func TestImportFailures(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		opts ImportOptions
+	}{
+		{"no front matter", "# Hello\n", importOptions},
+		{"no title", "---\nslug: x\n---\ntext\n", importOptions},
+		{"no content", "---\ntitle: Empty\n---\n\n", importOptions},
+		{"unknown visibility", "---\ntitle: X\nvisibility: secret\n---\ntext\n", importOptions},
+		{"unknown tag", "---\ntitle: X\ntags: [new]\n---\ntext\n", ImportOptions{AuthorID: 1, Publish: true}},
+	}
+	h, db := newTestHandler(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := h.ImportFiles([]mdport.File{{Name: "x.md", Data: []byte(tt.data)}}, tt.opts)
+			if len(res) != 1 || res[0].Status != "failed" {
+				t.Errorf("result = %+v, want failed", res)
+			}
+		})
+	}
+	var count int64
+	db.Model(&models.Post{}).Count(&count)
+	if count != 0 {
+		t.Errorf("posts = %d, want 0", count)
+	}
+}
+
+func TestImportSkippedHidesOthersPosts(t *testing.T) {
+	h, db := newTestHandler(t)
+	files := []mdport.File{{Name: "hello.md", Data: []byte("---\ntitle: Hello\n---\ntext\n")}}
+	if res := h.ImportFiles(files, importOptions); res[0].Status != "created" {
+		t.Fatalf("%+v", res)
+	}
+	var post models.Post
+	db.First(&post)
+	tests := []struct {
+		name string
+		opts ImportOptions
+		want uint
+	}{
+		{"own post", importOptions, post.ID},
+		{"someone else's post", ImportOptions{AuthorID: 2, Publish: true}, 0},
+		{"someone else's post with update any", ImportOptions{AuthorID: 2, Publish: true, UpdateAny: true}, post.ID},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := h.ImportFiles(files, tt.opts)
+			if res[0].Status != "skipped" || res[0].PostID != tt.want {
+				t.Errorf("result = %+v, want skipped with post id %d", res[0], tt.want)
+			}
+		})
+	}
+}
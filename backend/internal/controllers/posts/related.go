@@ -0,0 +1,107 @@
+package posts
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"easyblog/internal/models"
+	"easyblog/internal/postaccess"
+	"easyblog/internal/postlist"
+	"easyblog/internal/related"
+	"easyblog/internal/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type relatedItem struct {
+	postlist.Item
+	Score float64 `json:"score"`
+}
+
+// Related suggests published posts to read after this one, ?limit of them
+func (h *Handler) Related(c *gin.Context) {
+	var post models.Post
+	if err := h.db.First(&post, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+	if err := postaccess.Check(c, h.keys, post); err != nil {
+		postaccess.WriteError(c, post, err)
+		return
+	}
+	fields, ok := postlist.ParseFields(c)
+	if !ok {
+		return
+	}
+	limit := utils.QueryInt(c, "limit", 5, 1, related.MaxEntries)
+	entries, err := related.Get(h.db, post.ID, h.relatedOptions())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ids := make([]uint, len(entries))
+	scores := make(map[uint]float64, len(entries))
+	for i, e := range entries {
+		ids[i] = e.PostID
+		scores[e.PostID] = e.Score
+	}
+	items := []relatedItem{}
+	if len(ids) > 0 {
+		// the cache may still name posts unpublished since
+		listed, err := postlist.Find(h.db.Model(&models.Post{}).
+			Where("posts.id IN ? AND posts.status = ?", ids, models.PostPublished).Scopes(postaccess.Listed(c)), fields)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		for _, it := range listed {
+			items = append(items, relatedItem{Item: it, Score: scores[it.ID]})
+		}
+		sort.SliceStable(items, func(i, j int) bool {
+			if items[i].Score != items[j].Score {
+				return items[i].Score > items[j].Score
+			}
+			return items[i].ID > items[j].ID
+		})
+		if len(items) > limit {
+			items = items[:limit]
+		}
+	}
+	postlist.Write(c, int64(len(items)), items, fields)
+}
+
+func (h *Handler) relatedOptions() related.Options {
+	return related.Options{
+		TTL:  time.Duration(h.cfg.Server.Posts.RelatedCacheHour) * time.Hour,
+		Text: h.cfg.Server.Posts.RelatedText,
+	}
+}
+
+// relatedInputs captures what the suggestions of post are computed from,
+// to tell whether an edit changed any of it
+func (h *Handler) relatedInputs(post models.Post) string {
+	var tags, cats []uint
+	h.db.Table("post_tags").Where("post_id = ?", post.ID).Order("tag_id").Pluck("tag_id", &tags)
+	h.db.Table("post_categories").Where("post_id = ?", post.ID).Order("category_id").Pluck("category_id", &cats)
+	return fmt.Sprint(post.Title, "\x00", post.Content, "\x00", tags, cats)
+}
+
+// relatedChanged updates the suggestions involving posts after they changed;
+// they are only suggestions, so failures are logged
+func (h *Handler) relatedChanged(ids ...uint) {
+	if err := related.Changed(h.db, ids...); err != nil {
+		log.Printf("related: posts %v changed: %v", ids, err)
+	}
+}
+
+// refreshRelated recomputes the suggestions of an edited post right away.
+// The posts it may rank in are recomputed on their next read.
+func (h *Handler) refreshRelated(post models.Post) {
+	h.relatedChanged(post.ID)
+	if _, err := related.Refresh(h.db, post.ID, h.relatedOptions()); err != nil {
+		log.Printf("related: refresh post %d: %v", post.ID, err)
+	}
+}
@@ -0,0 +1,223 @@
+package posts
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+
+	"easyblog/internal/diff"
+	"easyblog/internal/models"
+	"easyblog/internal/rbac"
+	"easyblog/internal/render"
+	"easyblog/internal/utils"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// recordRevision snapshots post unless it matches the latest revision and
+// prunes the oldest revisions beyond server.posts.max_revisions
+func (h *Handler) recordRevision(tx *gorm.DB, post models.Post, editorID uint) error {
+	var last models.PostRevision
+	err := tx.Where("post_id = ?", post.ID).Order("number DESC").First(&last).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	if err == nil && last.Title == post.Title && last.Summary == post.Summary && last.Content == post.Content {
+		return nil
+	}
+	rev := models.PostRevision{
+		PostID:        post.ID,
+		Number:        last.Number + 1,
+		EditorID:      editorID,
+		Title:         post.Title,
+		Summary:       post.Summary,
+		Content:       post.Content,
+		ContentHTML:   render.Post(post.Content),
+		RenderVersion: render.Version,
+	}
+	if err := tx.Create(&rev).Error; err != nil {
+		return err
+	}
+	if max := h.cfg.Server.Posts.MaxRevisions; max > 0 {
+		return tx.Where("post_id = ? AND number <= ?", post.ID, rev.Number-max).Delete(&models.PostRevision{}).Error
+	}
+	return nil
+}
+
+// recordBaseline keeps the state of posts written before revisions existed
+func (h *Handler) recordBaseline(tx *gorm.DB, post models.Post) error {
+	var count int64
+	if err := tx.Model(&models.PostRevision{}).Where("post_id = ?", post.ID).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+	return h.recordRevision(tx, post, post.AuthorID)
+}
+
+type revisionItem struct {
+	models.PostRevision
+	EditorName string `json:"editor_name"`
+}
+
+func (h *Handler) ListRevisions(c *gin.Context) {
+	post, ok := h.loadEditable(c)
+	if !ok {
+		return
+	}
+	page := utils.QueryInt(c, "page", 0, 0, math.MaxInt)
+	size := utils.QueryInt(c, "size", 20, 1, 100)
+	q := h.db.Model(&models.PostRevision{}).Where("post_id = ?", post.ID)
+	var total int64
+	q.Count(&total)
+	var revisions []revisionItem
+	err := q.Select("post_revisions.id, post_revisions.post_id, post_revisions.number, post_revisions.editor_id, " +
+		"post_revisions.title, post_revisions.summary, post_revisions.created_at, users.username AS editor_name").
+		Joins("LEFT JOIN users ON users.id = post_revisions.editor_id").
+		Order("number DESC").Limit(size).Offset(page * size).
+		Scan(&revisions).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"items": revisions, "total": total})
+}
+
+func (h *Handler) GetRevision(c *gin.Context) {
+	post, ok := h.loadEditable(c)
+	if !ok {
+		return
+	}
+	rev, ok := h.loadRevision(c, post.ID, c.Param("rev"))
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, rev)
+}
+
+// DiffRevisions compares revision ?from with revision ?to, or with the
+// current post when to is omitted. ?format=text returns the plain diff.
+func (h *Handler) DiffRevisions(c *gin.Context) {
+	post, ok := h.loadEditable(c)
+	if !ok {
+		return
+	}
+	from, ok := h.loadRevision(c, post.ID, c.Query("from"))
+	if !ok {
+		return
+	}
+	to := models.PostRevision{Title: post.Title, Summary: post.Summary, Content: post.Content}
+	toName := "current"
+	if c.Query("to") != "" {
+		if to, ok = h.loadRevision(c, post.ID, c.Query("to")); !ok {
+			return
+		}
+		toName = fmt.Sprintf("revision %d", to.Number)
+	}
+	out := diff.Unified(fmt.Sprintf("revision %d", from.Number), toName, revisionText(from), revisionText(to), 3)
+	if c.Query("format") == "text" {
+		c.Data(http.StatusOK, "text/x-diff; charset=utf-8", []byte(out))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"from": from.Number, "to": toName, "diff": out})
+}
+
+// RestoreRevision copies a revision back into the post, recorded as a new revision
+func (h *Handler) RestoreRevision(c *gin.Context) {
+	post, ok := h.loadEditable(c)
+	if !ok {
+		return
+	}
+	rev, ok := h.loadRevision(c, post.ID, c.Param("rev"))
+	if !ok {
+		return
+	}
+	user := c.MustGet("user").(models.User)
+	relatedBefore := h.relatedInputs(post)
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := h.recordBaseline(tx, post); err != nil {
+			return err
+		}
+		post.Title = rev.Title
+		post.Summary = rev.Summary
+		post.Content = rev.Content
+		analysis := render.Analyze(post.Content)
+		post.SummaryAuto = rev.Summary == analysis.Summary
+		analysis.ApplyTo(&post)
+		if err := tx.Save(&post).Error; err != nil {
+			return err
+		}
+		return h.recordRevision(tx, post, user.ID)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	h.reindex(post)
+	if h.relatedInputs(post) != relatedBefore {
+		h.refreshRelated(post)
+	}
+	c.JSON(http.StatusOK, post)
+}
+
+// contentHTML returns the rendered content of post, cached on its latest
+// revision. Stale caches from an older renderer are refreshed in place and
+// posts without revisions are rendered on every read.
+func (h *Handler) contentHTML(post models.Post) string {
+	var rev models.PostRevision
+	err := h.db.Select("id", "content", "content_html", "render_version").
+		Where("post_id = ?", post.ID).Order("number DESC").First(&rev).Error
+	if err != nil || rev.Content != post.Content {
+		return render.Post(post.Content)
+	}
+	if rev.RenderVersion == render.Version {
+		return rev.ContentHTML
+	}
+	out := render.Post(post.Content)
+	h.db.Model(&rev).Updates(map[string]interface{}{"content_html": out, "render_version": render.Version})
+	return out
+}
+
+// revisionText lays out the diffed fields as one document
+func revisionText(rev models.PostRevision) string {
+	return "Title: " + rev.Title + "\nSummary: " + rev.Summary + "\n\n" + rev.Content
+}
+
+func (h *Handler) loadEditable(c *gin.Context) (models.Post, bool) {
+	var post models.Post
+	if err := h.db.First(&post, c.Param("id")).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "post not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+		}
+		return post, false
+	}
+	if !canModify(c, post, rbac.PostsUpdateAny) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "permission denied"})
+		return post, false
+	}
+	return post, true
+}
+
+func (h *Handler) loadRevision(c *gin.Context, postID uint, number string) (models.PostRevision, bool) {
+	var rev models.PostRevision
+	n, err := strconv.Atoi(number)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid revision number"})
+		return rev, false
+	}
+	if err := h.db.Where("post_id = ? AND number = ?", postID, n).First(&rev).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "revision not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+		}
+		return rev, false
+	}
+	return rev, true
+}
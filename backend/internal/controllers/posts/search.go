@@ -0,0 +1,170 @@
+package posts
+
+import (
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"easyblog/internal/models"
+	"easyblog/internal/postlist"
+	"easyblog/internal/search"
+	"easyblog/internal/utils"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// snippetWidth is the length of the content excerpt in search results
+const snippetWidth = 160
+
+// listFilters applies ?category_id, ?tag_id, ?author_id and the ?from / ?to
+// publication date range. Dates are RFC 3339 or 2006-01-02, a bare to date
+// includes the whole day.
+func listFilters(c *gin.Context) (func(*gorm.DB) *gorm.DB, bool) {
+	ids := map[string]uint64{}
+	for _, key := range []string{"category_id", "tag_id", "author_id"} {
+		if v := c.Query(key); v != "" {
+			id, err := strconv.ParseUint(v, 10, 64)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid " + key})
+				return nil, false
+			}
+			ids[key] = id
+		}
+	}
+	from, ok := queryDate(c, "from", false)
+	if !ok {
+		return nil, false
+	}
+	to, ok := queryDate(c, "to", true)
+	if !ok {
+		return nil, false
+	}
+	return func(db *gorm.DB) *gorm.DB {
+		if id, ok := ids["category_id"]; ok {
+			db = db.Where("posts.id IN (SELECT post_id FROM post_categories WHERE category_id = ?)", id)
+		}
+		if id, ok := ids["tag_id"]; ok {
+			db = db.Where("posts.id IN (SELECT post_id FROM post_tags WHERE tag_id = ?)", id)
+		}
+		if id, ok := ids["author_id"]; ok {
+			db = db.Where("posts.author_id = ?", id)
+		}
+		// drafts have no publication date yet
+		if from != nil {
+			db = db.Where("COALESCE(posts.published_at, posts.created_at) >= ?", *from)
+		}
+		if to != nil {
+			db = db.Where("COALESCE(posts.published_at, posts.created_at) < ?", *to)
+		}
+		return db
+	}, true
+}
+
+// queryDate parses a date query parameter; end moves a bare date to the
+// start of the next day so the range is inclusive
+func queryDate(c *gin.Context, key string, end bool) (*time.Time, bool) {
+	v := c.Query(key)
+	if v == "" {
+		return nil, true
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return &t, true
+	}
+	t, err := time.ParseInLocation("2006-01-02", v, time.Local)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid " + key + ", use 2006-01-02 or RFC 3339"})
+		return nil, false
+	}
+	if end {
+		t = t.AddDate(0, 0, 1)
+	}
+	return &t, true
+}
+
+type searchHighlight struct {
+	Title   string `json:"title"`
+	Snippet string `json:"snippet"`
+}
+
+type searchItem struct {
+	postlist.Item
+	Score     float64         `json:"score"`
+	Highlight searchHighlight `json:"highlight"`
+}
+
+// search answers List when ?q is given: ranked by relevance, with matches
+// marked in the title and a snippet of the content
+func (h *Handler) search(c *gin.Context, q string, filter func(*gorm.DB) *gorm.DB, fields postlist.Fields) {
+	page := utils.QueryInt(c, "page", 0, 0, math.MaxInt)
+	size := utils.QueryInt(c, "size", 10, 1, 20)
+	terms := search.Terms(q)
+	if len(terms) == 0 {
+		c.JSON(http.StatusOK, gin.H{"total": 0, "items": []searchItem{}})
+		return
+	}
+	res, err := h.index.Search(search.Query{Terms: terms, Filter: filter, Offset: page * size, Limit: size})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ids := make([]uint, len(res.Hits))
+	for i, hit := range res.Hits {
+		ids[i] = hit.PostID
+	}
+	var posts []models.Post
+	if len(ids) > 0 {
+		if err := h.db.Preload("Author").Preload("Categories").Preload("Tags").Find(&posts, ids).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	listed, err := postlist.Items(h.db, posts, fields)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	byID := make(map[uint]int, len(posts))
+	for i, p := range posts {
+		byID[p.ID] = i
+	}
+	items := make([]searchItem, 0, len(res.Hits))
+	for _, hit := range res.Hits {
+		i, ok := byID[hit.PostID]
+		if !ok {
+			continue
+		}
+		p := posts[i]
+		text := search.Plain(p.Content)
+		// a generated summary only repeats the content
+		if !p.SummaryAuto {
+			text = p.Summary + " " + text
+		}
+		items = append(items, searchItem{
+			Item:  listed[i],
+			Score: hit.Score,
+			Highlight: searchHighlight{
+				Title:   search.Highlight(p.Title, terms),
+				Snippet: search.Snippet(strings.TrimSpace(text), terms, snippetWidth),
+			},
+		})
+	}
+	postlist.Write(c, res.Total, items, fields)
+}
+
+// reindex updates the search index after post was written. The post is
+// saved either way, so failures are only logged.
+func (h *Handler) reindex(post models.Post) {
+	if err := h.index.Index(post); err != nil {
+		log.Printf("search: index post %d: %v", post.ID, err)
+	}
+}
+
+func (h *Handler) unindex(id uint) {
+	if err := h.index.Remove(id); err != nil {
+		log.Printf("search: remove post %d: %v", id, err)
+	}
+}
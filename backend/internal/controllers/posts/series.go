@@ -0,0 +1,55 @@
+package posts
+
+import (
+	"log"
+
+	"easyblog/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// seriesNav places post within its series, counting only the parts the
+// current request may read. Posts outside a series get nil.
+func (h *Handler) seriesNav(c *gin.Context, post models.Post) *models.SeriesNav {
+	var part models.SeriesPart
+	if err := h.db.Where("post_id = ?", post.ID).Limit(1).Find(&part).Error; err != nil || part.ID == 0 {
+		return nil
+	}
+	var series models.Series
+	if err := h.db.First(&series, part.SeriesID).Error; err != nil {
+		return nil
+	}
+	var parts []models.Post
+	err := h.db.Model(&models.Post{}).Select("posts.id", "posts.title", "posts.slug", "posts.status", "posts.visibility", "posts.author_id").
+		Joins("JOIN series_parts ON series_parts.post_id = posts.id").
+		Where("series_parts.series_id = ?", series.ID).Order("series_parts.position").Find(&parts).Error
+	if err != nil {
+		log.Printf("series: load parts of %d: %v", series.ID, err)
+		return nil
+	}
+	nav := &models.SeriesNav{ID: series.ID, Title: series.Title, Slug: series.Slug}
+	var visible []models.Post
+	for _, p := range parts {
+		if p.ID == post.ID || canList(c, p) {
+			visible = append(visible, p)
+		}
+	}
+	for i, p := range visible {
+		if p.ID != post.ID {
+			continue
+		}
+		nav.Part = i + 1
+		if i > 0 {
+			nav.Prev = postLink(visible[i-1])
+		}
+		if i < len(visible)-1 {
+			nav.Next = postLink(visible[i+1])
+		}
+	}
+	nav.Parts = len(visible)
+	return nav
+}
+
+func postLink(p models.Post) *models.PostLink {
+	return &models.PostLink{ID: p.ID, Title: p.Title, Slug: p.Slug}
+}
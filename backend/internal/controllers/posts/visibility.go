@@ -0,0 +1,229 @@
+package posts
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"easyblog/internal/actiontoken"
+	"easyblog/internal/models"
+	"easyblog/internal/postaccess"
+	"easyblog/internal/postlist"
+	"easyblog/internal/rbac"
+	"easyblog/internal/throttle"
+	"easyblog/internal/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/gorm"
+)
+
+// statusFilter applies ?status to a post query. Visitors and authors only
+// get published posts on public listings; editors may ask for draft,
+// scheduled, published or all.
+func statusFilter(c *gin.Context) (func(*gorm.DB) *gorm.DB, bool) {
+	status := c.DefaultQuery("status", string(models.PostPublished))
+	if status != string(models.PostPublished) && !rbac.Allowed(c, rbac.PostsUpdateAny) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "permission denied"})
+		return nil, false
+	}
+	switch models.PostStatus(status) {
+	case models.PostDraft, models.PostScheduled, models.PostPublished:
+		return func(db *gorm.DB) *gorm.DB { return db.Where("posts.status = ?", status) }, true
+	}
+	if status == "all" {
+		return func(db *gorm.DB) *gorm.DB { return db }, true
+	}
+	c.JSON(http.StatusBadRequest, gin.H{"error": "unknown status"})
+	return nil, false
+}
+
+// canList reports whether post may be named to the current request outside
+// of its own link, as in series navigation; see postaccess.Listed
+func canList(c *gin.Context, post models.Post) bool {
+	return postaccess.CanEdit(c, post) || post.Status == models.PostPublished && postaccess.Listable(c, post)
+}
+
+// searchable keeps password posts out of search results for readers, a match
+// would give away words of the locked content
+func searchable(c *gin.Context) func(*gorm.DB) *gorm.DB {
+	locked := !rbac.Allowed(c, rbac.PostsUpdateAny)
+	return func(db *gorm.DB) *gorm.DB {
+		if locked {
+			db = db.Where("posts.visibility <> ?", models.VisibilityPassword)
+		}
+		return db
+	}
+}
+
+// applyVisibility sets the visibility asked for in req. An empty visibility
+// keeps the current one and an empty password the current password.
+func (h *Handler) applyVisibility(c *gin.Context, post *models.Post, req createPostRequest) bool {
+	if req.Visibility != "" {
+		post.Visibility = req.Visibility
+	}
+	if post.Visibility == "" {
+		post.Visibility = models.VisibilityPublic
+	}
+	if post.Visibility != models.VisibilityPassword {
+		post.PasswordHash = ""
+		return true
+	}
+	if req.Password != "" {
+		hash, err := utils.HashPassword(h.cfg.Server.Password, req.Password)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to hash password"})
+			return false
+		}
+		post.PasswordHash = hash
+	}
+	if post.PasswordHash == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "password visibility needs a password"})
+		return false
+	}
+	return true
+}
+
+// unlockKey is the throttle key for unlock attempts on post from ip. It is
+// apart from the login ones so guessing a post password does not lock anybody
+// out of their account, and per client so one guesser does not lock the
+// post for every reader.
+func unlockKey(id uint, ip string) string {
+	return "unlock:post:" + strconv.FormatUint(uint64(id), 10) + ":ip:" + ip
+}
+
+// Unlock checks the password of a password protected post and hands out a
+// token for it, both as a cookie and in the body for clients without one.
+// Wrong guesses are throttled per client and post.
+func (h *Handler) Unlock(c *gin.Context) {
+	var body struct {
+		Password string `json:"password" binding:"required,max=128"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var post models.Post
+	err := h.db.First(&post, c.Param("id")).Error
+	if err != nil || post.Status != models.PostPublished || post.Visibility != models.VisibilityPassword {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+	guard := throttle.NewGuard(h.db, h.cfg.Server.Posts.UnlockThrottle)
+	key := unlockKey(post.ID, c.ClientIP())
+	wait, err := guard.Reserve(key)
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "service unavailable, try again later"})
+		return
+	}
+	if wait > 0 {
+		secs := int(math.Ceil(wait.Seconds()))
+		c.Header("Retry-After", strconv.Itoa(secs))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "too many attempts, try again later", "retry_after": secs})
+		return
+	}
+	ok, _, err := utils.VerifyPassword(h.cfg.Server.Password, post.PasswordHash, body.Password)
+	if err != nil || !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "wrong password"})
+		return
+	}
+	guard.Reset(key)
+	ttl := time.Duration(h.cfg.Server.Posts.UnlockExpireMinute) * time.Minute
+	token, err := postaccess.Unlock(h.keys, post, ttl)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to sign token"})
+		return
+	}
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(postaccess.CookieName(post.ID), token, int(ttl.Seconds()), "/api", "", c.Request.TLS != nil, true)
+	c.JSON(http.StatusOK, gin.H{
+		"token":      token,
+		"header":     postaccess.UnlockHeader,
+		"expires_at": time.Now().Add(ttl),
+	})
+}
+
+// Mine lists the current user's own posts in every status, ?status narrows it
+func (h *Handler) Mine(c *gin.Context) {
+	user := c.MustGet("user").(models.User)
+	fields, ok := postlist.ParseFields(c)
+	if !ok {
+		return
+	}
+	status := c.Query("status")
+	page := utils.QueryInt(c, "page", 0, 0, math.MaxInt)
+	size := utils.QueryInt(c, "size", 10, 1, 50)
+	h.list(c, func(db *gorm.DB) *gorm.DB {
+		db = db.Where("posts.author_id = ?", user.ID)
+		if status != "" {
+			db = db.Where("posts.status = ?", status)
+		}
+		return db
+	}, "posts.updated_at DESC", page, size, fields)
+}
+
+// PreviewHeader carries a preview token for clients that keep it out of the
+// url altogether
+const PreviewHeader = "X-Post-Preview"
+
+// CreatePreview issues an expiring link to share an unpublished post with
+// reviewers who have no account or no edit rights. The token rides in the
+// query, where the preview response keeps it from leaking via Referer.
+func (h *Handler) CreatePreview(c *gin.Context) {
+	post, ok := h.loadEditable(c)
+	if !ok {
+		return
+	}
+	ttl := time.Duration(h.cfg.Server.Posts.PreviewExpireHour) * time.Hour
+	token, err := actiontoken.Issue(h.keys, actiontoken.PurposePostPreview, actiontoken.Claims{
+		RegisteredClaims: jwt.RegisteredClaims{Subject: strconv.FormatUint(uint64(post.ID), 10)},
+	}, ttl)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to sign token"})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{
+		"token":      token,
+		"url":        "/api/posts/preview?token=" + url.QueryEscape(token),
+		"expires_at": time.Now().Add(ttl),
+	})
+}
+
+// Preview serves the post a preview token was issued for, in any status.
+// The token comes in the X-Post-Preview header or the token query parameter.
+func (h *Handler) Preview(c *gin.Context) {
+	// the page showing the preview must not pass its url on to links and
+	// images, nor may anything keep a copy of it
+	c.Header("Referrer-Policy", "no-referrer")
+	c.Header("Cache-Control", "no-store")
+	token := c.GetHeader(PreviewHeader)
+	if token == "" {
+		token = c.Query("token")
+	}
+	claims, err := actiontoken.Parse(h.keys, actiontoken.PurposePostPreview, token)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	id, err := strconv.ParseUint(claims.Subject, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": actiontoken.ErrInvalid.Error()})
+		return
+	}
+	var post models.Post
+	if err := h.db.Preload("Author").Preload("Categories").Preload("Tags").First(&post, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+		}
+		return
+	}
+	post.ContentHTML = h.contentHTML(post)
+	post.Series = h.seriesNav(c, post)
+	c.Header("X-Robots-Tag", "noindex")
+	c.JSON(http.StatusOK, post)
+}
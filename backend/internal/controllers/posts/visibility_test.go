@@ -0,0 +1,200 @@
+package posts
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"easyblog/internal/actiontoken"
+	"easyblog/internal/config"
+	"easyblog/internal/keys"
+	"easyblog/internal/models"
+	"easyblog/internal/search"
+	"easyblog/internal/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// newTestHandler serves an empty blog with the memory search index
+func newTestHandler(t *testing.T) (*Handler, *gorm.DB) {
+	gin.SetMode(gin.TestMode)
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, _ := db.DB()
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+	if err := db.AutoMigrate(&models.User{}, &models.Post{}, &models.Category{}, &models.Tag{}, &models.PostRevision{},
+		&models.Series{}, &models.SeriesPart{}, &models.SlugRedirect{}, &models.ConfigModel{},
+		&models.RelatedPosts{}, &models.RelatedLink{}, &models.RelatedPosting{}, &models.RelatedTerm{},
+		&models.LoginAttempt{}); err != nil {
+		t.Fatal(err)
+	}
+	author := models.User{Username: "author", Email: "a@example.com", Role: models.RoleAuthor}
+	db.Create(&author)
+	cfg := &config.Config{}
+	cfg.Server.JWT.Algorithm = keys.AlgHS256
+	cfg.Server.JWT.Secret = "test"
+	cfg.Server.Password.Algorithm = utils.PasswordBcrypt
+	cfg.Server.Password.BcryptCost = bcrypt.MinCost
+	ks, err := keys.Load(db, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewHandler(db, cfg, ks, search.NewMemory(db)), db
+}
+
+// seedRenamed adds a published post "open", a draft "draft" and a private
+// post "team" by the author, each of which was renamed from "old-" and its
+// slug
+func seedRenamed(db *gorm.DB) {
+	for _, p := range []models.Post{
+		{Title: "open", Slug: "open", Status: models.PostPublished, Visibility: models.VisibilityPublic},
+		{Title: "draft", Slug: "draft", Status: models.PostDraft, Visibility: models.VisibilityPublic},
+		{Title: "team", Slug: "team", Status: models.PostPublished, Visibility: models.VisibilityPrivate},
+	} {
+		p.AuthorID = 1
+		db.Create(&p)
+		db.Create(&models.SlugRedirect{Kind: "post", OldSlug: "old-" + p.Slug, TargetID: p.ID})
+	}
+}
+
+// serve routes path to handler, signed in as user when it is not nil
+func serve(user *models.User, route, path string, handler gin.HandlerFunc, header http.Header) *httptest.ResponseRecorder {
+	r := gin.New()
+	r.GET(route, func(c *gin.Context) {
+		if user != nil {
+			c.Set("user", *user)
+		}
+	}, handler)
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestGetBySlugRedirects(t *testing.T) {
+	h, db := newTestHandler(t)
+	seedRenamed(db)
+	var author models.User
+	db.First(&author)
+	admin := models.User{Username: "admin", Role: models.RoleAdmin}
+	admin.ID = 99
+	tests := []struct {
+		name     string
+		user     *models.User
+		slug     string
+		want     int
+		location string
+	}{
+		{"published", nil, "old-open", http.StatusMovedPermanently, "/posts/slug/open"},
+		{"draft hidden", nil, "old-draft", http.StatusNotFound, ""},
+		{"draft for its author", &author, "old-draft", http.StatusMovedPermanently, "/posts/slug/draft"},
+		{"private hidden", nil, "old-team", http.StatusNotFound, ""},
+		{"private for the team", &admin, "old-team", http.StatusMovedPermanently, "/posts/slug/team"},
+		{"unknown", nil, "old-none", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(tt.user, "/posts/slug/:slug", "/posts/slug/"+tt.slug, h.GetBySlug, nil)
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+			if got := w.Header().Get("Location"); got != tt.location {
+				t.Errorf("location = %q, want %q", got, tt.location)
+			}
+		})
+	}
+}
+
+func TestPreview(t *testing.T) {
+	h, db := newTestHandler(t)
+	seedRenamed(db)
+	var draft models.Post
+	db.Where("slug = ?", "draft").First(&draft)
+	token, err := actiontoken.Issue(h.keys, actiontoken.PurposePostPreview, actiontoken.Claims{
+		RegisteredClaims: jwt.RegisteredClaims{Subject: strconv.FormatUint(uint64(draft.ID), 10)},
+	}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		path   string
+		header http.Header
+		want   int
+	}{
+		{"query", "/posts/preview?token=" + url.QueryEscape(token), nil, http.StatusOK},
+		{"header", "/posts/preview", http.Header{PreviewHeader: {token}}, http.StatusOK},
+		{"missing", "/posts/preview", nil, http.StatusUnauthorized},
+		{"forged", "/posts/preview?token=" + url.QueryEscape(token+"x"), nil, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(nil, "/posts/preview", tt.path, h.Preview, tt.header)
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+			if got := w.Header().Get("Referrer-Policy"); got != "no-referrer" {
+				t.Errorf("Referrer-Policy = %q, want no-referrer", got)
+			}
+		})
+	}
+}
+
+func TestUnlockThrottle(t *testing.T) {
+	h, db := newTestHandler(t)
+	h.cfg.Server.Posts.UnlockExpireMinute = 60
+	h.cfg.Server.Posts.UnlockThrottle = config.LoginThrottleConfig{MaxFailures: 2, LockoutMinutes: 15, ResetHour: 24}
+	hash, err := utils.HashPassword(h.cfg.Server.Password, "open sesame")
+	if err != nil {
+		t.Fatal(err)
+	}
+	post := models.Post{Title: "secret", Slug: "secret", Status: models.PostPublished, Visibility: models.VisibilityPassword, PasswordHash: hash}
+	post.AuthorID = 1
+	db.Create(&post)
+	r := gin.New()
+	r.POST("/posts/:id/unlock", h.Unlock)
+	unlock := func(ip, password string) int {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/posts/"+strconv.FormatUint(uint64(post.ID), 10)+"/unlock",
+			bytes.NewReader([]byte(`{"password":"`+password+`"}`)))
+		req.Header.Set("Content-Type", "application/json")
+		req.RemoteAddr = ip + ":1234"
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+	// steps run in order against the same post
+	steps := []struct {
+		name     string
+		ip       string
+		password string
+		want     int
+	}{
+		{"first guess", "10.0.0.1", "guess", http.StatusUnauthorized},
+		{"second guess locks out", "10.0.0.1", "guess", http.StatusUnauthorized},
+		{"guesser locked out", "10.0.0.1", "open sesame", http.StatusTooManyRequests},
+		{"other reader unlocks", "10.0.0.2", "open sesame", http.StatusOK},
+		{"success elsewhere keeps the lockout", "10.0.0.1", "open sesame", http.StatusTooManyRequests},
+		{"other reader guesses", "10.0.0.2", "guess", http.StatusUnauthorized},
+	}
+	for _, s := range steps {
+		t.Run(s.name, func(t *testing.T) {
+			if got := unlock(s.ip, s.password); got != s.want {
+				t.Errorf("status = %d, want %d", got, s.want)
+			}
+		})
+	}
+}
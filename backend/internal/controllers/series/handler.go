@@ -0,0 +1,424 @@
+package series
+
+import (
+	"errors"
+	"math"
+	"net/http"
+
+	"easyblog/internal/config"
+	"easyblog/internal/models"
+	"easyblog/internal/postaccess"
+	"easyblog/internal/postlist"
+	"easyblog/internal/rbac"
+	"easyblog/internal/slug"
+	"easyblog/internal/utils"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type Handler struct {
+	db  *gorm.DB
+	cfg *config.Config
+}
+
+func NewHandler(db *gorm.DB, cfg *config.Config) *Handler {
+	return &Handler{db: db, cfg: cfg}
+}
+
+type seriesItem struct {
+	models.Series
+	// parts the current viewer can read
+	PartCount int64 `json:"part_count"`
+}
+
+func (h *Handler) List(c *gin.Context) {
+	page := utils.QueryInt(c, "page", 0, 0, math.MaxInt)
+	size := utils.QueryInt(c, "size", 10, 1, 50)
+	q := h.db.Model(&models.Series{})
+	if v := c.Query("author_id"); v != "" {
+		q = q.Where("author_id = ?", v)
+	}
+	var total int64
+	q.Count(&total)
+	var list []models.Series
+	if err := q.Order("created_at DESC").Limit(size).Offset(page * size).Find(&list).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ids := make([]uint, len(list))
+	for i, s := range list {
+		ids[i] = s.ID
+	}
+	var counts []struct {
+		SeriesID uint
+		Count    int64
+	}
+	if len(ids) > 0 {
+		err := h.db.Model(&models.SeriesPart{}).Select("series_parts.series_id, COUNT(*) AS count").
+			Joins("JOIN posts ON posts.id = series_parts.post_id AND posts.deleted_at IS NULL").
+			Where("series_parts.series_id IN ?", ids).Scopes(visibleParts(c)).
+			Group("series_parts.series_id").Scan(&counts).Error
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	byID := map[uint]int64{}
+	for _, n := range counts {
+		byID[n.SeriesID] = n.Count
+	}
+	items := make([]seriesItem, len(list))
+	for i, s := range list {
+		items[i] = seriesItem{Series: s, PartCount: byID[s.ID]}
+	}
+	c.JSON(http.StatusOK, gin.H{"data": items, "total": total})
+}
+
+// Get returns a series with its parts in order
+func (h *Handler) Get(c *gin.Context) {
+	var s models.Series
+	if err := h.db.First(&s, c.Param("id")).Error; err != nil {
+		writeLoadError(c, err)
+		return
+	}
+	h.detail(c, s)
+}
+
+// GetBySlug serves a series by its slug; old slugs redirect to the current one
+func (h *Handler) GetBySlug(c *gin.Context) {
+	var s models.Series
+	if err := h.db.Where("slug = ?", c.Param("slug")).First(&s).Error; err != nil {
+		if id, ok := slug.Resolve(h.db, slug.KindSeries, c.Param("slug")); ok {
+			if h.db.Select("id", "slug").First(&s, id).Error == nil {
+				slug.Redirect(c, s.Slug)
+				return
+			}
+		}
+		c.JSON(http.StatusNotFound, gin.H{"error": "series not found"})
+		return
+	}
+	h.detail(c, s)
+}
+
+func (h *Handler) detail(c *gin.Context, s models.Series) {
+	fields, ok := postlist.ParseFields(c)
+	if !ok {
+		return
+	}
+	parts, err := postlist.Find(h.db.Model(&models.Post{}).
+		Joins("JOIN series_parts ON series_parts.post_id = posts.id").
+		Where("series_parts.series_id = ?", s.ID).Scopes(visibleParts(c)).
+		Order("series_parts.position"), fields)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	out, err := fields.Apply(parts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": s, "parts": out})
+}
+
+type seriesRequest struct {
+	Title       string `json:"title" binding:"required,max=200"`
+	Slug        string `json:"slug" binding:"max=191"` // derived from the title when empty
+	Description string `json:"description" binding:"max=1000"`
+}
+
+func (h *Handler) Create(c *gin.Context) {
+	var req seriesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	s, err := slug.For(h.db, &models.Series{}, req.Slug, req.Title, slug.KindSeries, 0)
+	if err != nil {
+		slug.WriteError(c, err)
+		return
+	}
+	user := c.MustGet("user").(models.User)
+	series := models.Series{Title: req.Title, Slug: s, Description: req.Description, AuthorID: user.ID}
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&series).Error; err != nil {
+			return err
+		}
+		return slug.Claim(tx, slug.KindSeries, s)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, series)
+}
+
+func (h *Handler) Update(c *gin.Context) {
+	series, ok := h.loadEditable(c, rbac.PostsUpdateAny)
+	if !ok {
+		return
+	}
+	var req seriesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	oldSlug := series.Slug
+	if req.Slug != "" && slug.Make(req.Slug) != oldSlug {
+		s, err := slug.For(h.db, &models.Series{}, req.Slug, "", slug.KindSeries, series.ID)
+		if err != nil {
+			slug.WriteError(c, err)
+			return
+		}
+		series.Slug = s
+	}
+	series.Title = req.Title
+	series.Description = req.Description
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if series.Slug != oldSlug {
+			if err := slug.Moved(tx, slug.KindSeries, oldSlug, series.Slug, series.ID); err != nil {
+				return err
+			}
+		}
+		return tx.Save(&series).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, series)
+}
+
+// Delete removes the series, its posts stay as standalone posts
+func (h *Handler) Delete(c *gin.Context) {
+	series, ok := h.loadEditable(c, rbac.PostsDeleteAny)
+	if !ok {
+		return
+	}
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("series_id = ?", series.ID).Delete(&models.SeriesPart{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&series).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// SetParts replaces the parts of a series with post_ids in reading order;
+// it adds, removes and reorders parts in one go
+func (h *Handler) SetParts(c *gin.Context) {
+	series, ok := h.loadEditable(c, rbac.PostsUpdateAny)
+	if !ok {
+		return
+	}
+	var req struct {
+		PostIDs []uint `json:"post_ids" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	seen := map[uint]bool{}
+	for _, id := range req.PostIDs {
+		if seen[id] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "duplicate post id"})
+			return
+		}
+		seen[id] = true
+	}
+	if !h.checkPosts(c, series.ID, req.PostIDs) {
+		return
+	}
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("series_id = ?", series.ID).Delete(&models.SeriesPart{}).Error; err != nil {
+			return err
+		}
+		for i, id := range req.PostIDs {
+			if err := tx.Create(&models.SeriesPart{SeriesID: series.ID, PostID: id, Position: i + 1}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	h.detail(c, series)
+}
+
+// AddPart inserts a post at position, or appends it when position is 0
+func (h *Handler) AddPart(c *gin.Context) {
+	series, ok := h.loadEditable(c, rbac.PostsUpdateAny)
+	if !ok {
+		return
+	}
+	var req struct {
+		PostID   uint `json:"post_id" binding:"required"`
+		Position int  `json:"position" binding:"min=0"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !h.checkPosts(c, series.ID, []uint{req.PostID}) {
+		return
+	}
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		// adding a part that is already in the series moves it
+		if err := tx.Where("series_id = ? AND post_id = ?", series.ID, req.PostID).Delete(&models.SeriesPart{}).Error; err != nil {
+			return err
+		}
+		if err := renumber(tx, series.ID); err != nil {
+			return err
+		}
+		var count int64
+		if err := tx.Model(&models.SeriesPart{}).Where("series_id = ?", series.ID).Count(&count).Error; err != nil {
+			return err
+		}
+		pos := req.Position
+		if pos == 0 || pos > int(count)+1 {
+			pos = int(count) + 1
+		}
+		err := tx.Model(&models.SeriesPart{}).Where("series_id = ? AND position >= ?", series.ID, pos).
+			UpdateColumn("position", gorm.Expr("position + 1")).Error
+		if err != nil {
+			return err
+		}
+		return tx.Create(&models.SeriesPart{SeriesID: series.ID, PostID: req.PostID, Position: pos}).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	h.detail(c, series)
+}
+
+// RemovePart takes a post out of the series and closes the gap
+func (h *Handler) RemovePart(c *gin.Context) {
+	series, ok := h.loadEditable(c, rbac.PostsUpdateAny)
+	if !ok {
+		return
+	}
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		res := tx.Where("series_id = ? AND post_id = ?", series.ID, c.Param("post_id")).Delete(&models.SeriesPart{})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return renumber(tx, series.ID)
+	})
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "post is not part of this series"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// renumber makes the positions of a series 1..n again
+func renumber(tx *gorm.DB, seriesID uint) error {
+	var parts []models.SeriesPart
+	if err := tx.Where("series_id = ?", seriesID).Order("position").Find(&parts).Error; err != nil {
+		return err
+	}
+	for i, p := range parts {
+		if p.Position == i+1 {
+			continue
+		}
+		if err := tx.Model(&p).UpdateColumn("position", i+1).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkPosts makes sure the posts exist, the user may edit them and they
+// are not part of another series
+func (h *Handler) checkPosts(c *gin.Context, seriesID uint, ids []uint) bool {
+	if len(ids) == 0 {
+		return true
+	}
+	var posts []models.Post
+	if err := h.db.Select("id", "author_id").Find(&posts, ids).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return false
+	}
+	if len(posts) != len(ids) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "some post IDs are invalid"})
+		return false
+	}
+	for _, p := range posts {
+		if !canModify(c, p.AuthorID, rbac.PostsUpdateAny) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "permission denied"})
+			return false
+		}
+	}
+	var taken int64
+	err := h.db.Model(&models.SeriesPart{}).Where("post_id IN ? AND series_id <> ?", ids, seriesID).Count(&taken).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return false
+	}
+	if taken > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "post already belongs to another series"})
+		return false
+	}
+	return true
+}
+
+func (h *Handler) loadEditable(c *gin.Context, anyPerm rbac.Permission) (models.Series, bool) {
+	var s models.Series
+	if err := h.db.First(&s, c.Param("id")).Error; err != nil {
+		writeLoadError(c, err)
+		return s, false
+	}
+	if !canModify(c, s.AuthorID, anyPerm) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "permission denied"})
+		return s, false
+	}
+	return s, true
+}
+
+func writeLoadError(c *gin.Context, err error) {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "series not found"})
+	} else {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+	}
+}
+
+// canModify reports whether the current user owns the record or holds anyPerm
+func canModify(c *gin.Context, ownerID uint, anyPerm rbac.Permission) bool {
+	userVal, exists := c.Get("user")
+	if !exists {
+		return false
+	}
+	return userVal.(models.User).ID == ownerID || rbac.Allowed(c, anyPerm)
+}
+
+// visibleParts hides unpublished and unlisted parts from everyone but their
+// editors
+func visibleParts(c *gin.Context) func(*gorm.DB) *gorm.DB {
+	shown := postaccess.ListedVisibilities(c)
+	return func(db *gorm.DB) *gorm.DB {
+		if rbac.Allowed(c, rbac.PostsUpdateAny) {
+			return db
+		}
+		if userVal, ok := c.Get("user"); ok {
+			return db.Where("(posts.status = ? AND posts.visibility IN ?) OR posts.author_id = ?",
+				models.PostPublished, shown, userVal.(models.User).ID)
+		}
+		return db.Where("posts.status = ? AND posts.visibility IN ?", models.PostPublished, shown)
+	}
+}
@@ -0,0 +1,163 @@
+package series
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"easyblog/internal/config"
+	"easyblog/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// newTestRouter serves the part routes for an author who owns series 1 and
+// posts 1..5; post 6 belongs to someone else and post 7 to series 2
+func newTestRouter(t *testing.T) (*gin.Engine, *gorm.DB) {
+	gin.SetMode(gin.TestMode)
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, _ := db.DB()
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+	if err := db.AutoMigrate(&models.User{}, &models.Post{}, &models.Category{}, &models.Tag{}, &models.Comment{},
+		&models.Series{}, &models.SeriesPart{}); err != nil {
+		t.Fatal(err)
+	}
+	author := models.User{Username: "author", Email: "a@example.com", Role: models.RoleAuthor}
+	db.Create(&author)
+	db.Create(&models.User{Username: "other", Email: "o@example.com", Role: models.RoleAuthor})
+	for i := 1; i <= 7; i++ {
+		owner := author.ID
+		if i == 6 {
+			owner = 2
+		}
+		db.Create(&models.Post{Title: fmt.Sprintf("p%d", i), Slug: fmt.Sprintf("p%d", i), AuthorID: owner,
+			Status: models.PostPublished, Visibility: models.VisibilityPublic})
+	}
+	db.Create(&models.Series{Title: "mine", Slug: "mine", AuthorID: author.ID})
+	db.Create(&models.Series{Title: "taken", Slug: "taken", AuthorID: author.ID})
+	db.Create(&models.SeriesPart{SeriesID: 2, PostID: 7, Position: 1})
+
+	h := NewHandler(db, &config.Config{})
+	r := gin.New()
+	r.Use(func(c *gin.Context) { c.Set("user", author) })
+	r.PUT("/series/:id/parts", h.SetParts)
+	r.POST("/series/:id/parts", h.AddPart)
+	r.DELETE("/series/:id/parts/:post_id", h.RemovePart)
+	return r, db
+}
+
+func do(r *gin.Engine, method, path, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	r.ServeHTTP(w, req)
+	return w
+}
+
+// order lists the post ids of series 1 by position
+func order(t *testing.T, db *gorm.DB) []uint {
+	var parts []models.SeriesPart
+	db.Where("series_id = ?", 1).Order("position").Find(&parts)
+	ids := []uint{}
+	for i, p := range parts {
+		if p.Position != i+1 {
+			t.Errorf("positions not 1..n: %+v", parts)
+		}
+		ids = append(ids, p.PostID)
+	}
+	return ids
+}
+
+func TestParts(t *testing.T) {
+	type step struct {
+		method, path, body string
+		status             int
+	}
+	tests := []struct {
+		name  string
+		steps []step
+		want  []uint
+	}{
+		{"set in order", []step{
+			{"PUT", "/series/1/parts", `{"post_ids":[3,1,2]}`, 200},
+		}, []uint{3, 1, 2}},
+		{"append", []step{
+			{"PUT", "/series/1/parts", `{"post_ids":[1,2]}`, 200},
+			{"POST", "/series/1/parts", `{"post_id":3}`, 200},
+		}, []uint{1, 2, 3}},
+		{"insert at position", []step{
+			{"PUT", "/series/1/parts", `{"post_ids":[1,2]}`, 200},
+			{"POST", "/series/1/parts", `{"post_id":3,"position":1}`, 200},
+		}, []uint{3, 1, 2}},
+		{"position past the end appends", []step{
+			{"PUT", "/series/1/parts", `{"post_ids":[1]}`, 200},
+			{"POST", "/series/1/parts", `{"post_id":2,"position":9}`, 200},
+		}, []uint{1, 2}},
+		{"adding a part again moves it", []step{
+			{"PUT", "/series/1/parts", `{"post_ids":[1,2,3]}`, 200},
+			{"POST", "/series/1/parts", `{"post_id":3,"position":2}`, 200},
+		}, []uint{1, 3, 2}},
+		{"remove closes the gap", []step{
+			{"PUT", "/series/1/parts", `{"post_ids":[1,2,3]}`, 200},
+			{"DELETE", "/series/1/parts/2", "", 204},
+		}, []uint{1, 3}},
+		{"remove unknown part", []step{
+			{"DELETE", "/series/1/parts/4", "", 404},
+		}, []uint{}},
+		{"duplicate ids", []step{
+			{"PUT", "/series/1/parts", `{"post_ids":[1,1]}`, 400},
+		}, []uint{}},
+		{"unknown post", []step{
+			{"PUT", "/series/1/parts", `{"post_ids":[1,99]}`, 400},
+		}, []uint{}},
+		{"post of another author", []step{
+			{"POST", "/series/1/parts", `{"post_id":6}`, 403},
+		}, []uint{}},
+		{"post in another series", []step{
+			{"PUT", "/series/1/parts", `{"post_ids":[1,7]}`, 409},
+		}, []uint{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, db := newTestRouter(t)
+			for _, s := range tt.steps {
+				if w := do(r, s.method, s.path, s.body); w.Code != s.status {
+					t.Fatalf("%s %s: %d %s, want %d", s.method, s.path, w.Code, w.Body, s.status)
+				}
+			}
+			if got := order(t, db); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parts = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPartsResponseInOrder(t *testing.T) {
+	r, _ := newTestRouter(t)
+	w := do(r, "PUT", "/series/1/parts?fields=title", `{"post_ids":[2,5,1]}`)
+	var body struct {
+		Parts []struct {
+			ID    uint   `json:"id"`
+			Title string `json:"title"`
+		} `json:"parts"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, p := range body.Parts {
+		got = append(got, p.Title)
+	}
+	if want := []string{"p2", "p5", "p1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("parts = %v, want %v", got, want)
+	}
+}
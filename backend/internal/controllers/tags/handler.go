@@ -3,6 +3,7 @@ package tags
 import (
 	"easyblog/internal/config"
 	"easyblog/internal/models"
+	"easyblog/internal/slug"
 	"easyblog/internal/utils"
 	"errors"
 	"github.com/gin-gonic/gin"
@@ -47,6 +48,7 @@ func (h *Handler) List(c *gin.Context) {
 
 type createTagReq struct {
 	Name string `json:"name" binding:"required"`
+	Slug string `json:"slug" binding:"max=191"` // derived from the name when empty
 }
 
 func (h *Handler) Create(c *gin.Context) {
@@ -59,7 +61,18 @@ func (h *Handler) Create(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "name is required"})
 		return
 	}
-	if err := h.db.Create(&models.Tag{Name: req.Name}).Error; err != nil {
+	s, err := slug.For(h.db, &models.Tag{}, req.Slug, req.Name, slug.KindTag, 0)
+	if err != nil {
+		slug.WriteError(c, err)
+		return
+	}
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&models.Tag{Name: req.Name, Slug: s}).Error; err != nil {
+			return err
+		}
+		return slug.Claim(tx, slug.KindTag, s)
+	})
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -68,6 +81,7 @@ func (h *Handler) Create(c *gin.Context) {
 
 type updateTagReq struct {
 	Name string `json:"name"`
+	Slug string `json:"slug" binding:"max=191"`
 }
 
 func (h *Handler) Update(c *gin.Context) {
@@ -98,8 +112,26 @@ func (h *Handler) Update(c *gin.Context) {
 		updates["name"] = req.Name
 	}
 
+	oldSlug := tag.Slug
+	if req.Slug != "" && slug.Make(req.Slug) != oldSlug {
+		s, err := slug.For(h.db, &models.Tag{}, req.Slug, "", slug.KindTag, tag.ID)
+		if err != nil {
+			slug.WriteError(c, err)
+			return
+		}
+		updates["slug"] = s
+	}
+
 	if len(updates) > 0 {
-		if err := h.db.Model(&tag).Updates(updates).Error; err != nil {
+		err := h.db.Transaction(func(tx *gorm.DB) error {
+			if s, ok := updates["slug"].(string); ok {
+				if err := slug.Moved(tx, slug.KindTag, oldSlug, s, tag.ID); err != nil {
+					return err
+				}
+			}
+			return tx.Model(&tag).Updates(updates).Error
+		})
+		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
@@ -131,3 +163,19 @@ func (h *Handler) Delete(c *gin.Context) {
 	}
 	c.JSON(http.StatusOK, gin.H{"result": "ok"})
 }
+
+// GetBySlug serves a tag by its slug; old slugs redirect to the current one
+func (h *Handler) GetBySlug(c *gin.Context) {
+	var tag models.Tag
+	if err := h.db.Where("slug = ?", c.Param("slug")).First(&tag).Error; err != nil {
+		if id, ok := slug.Resolve(h.db, slug.KindTag, c.Param("slug")); ok {
+			if h.db.Select("id", "slug").First(&tag, id).Error == nil {
+				slug.Redirect(c, tag.Slug)
+				return
+			}
+		}
+		c.JSON(http.StatusNotFound, gin.H{"error": "tag not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": tag})
+}
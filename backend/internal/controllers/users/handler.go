@@ -0,0 +1,60 @@
+package users
+
+import (
+	"errors"
+	"math"
+	"net/http"
+
+	"easyblog/internal/models"
+	"easyblog/internal/postaccess"
+	"easyblog/internal/postlist"
+	"easyblog/internal/utils"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type Handler struct{ db *gorm.DB }
+
+func NewHandler(db *gorm.DB) *Handler { return &Handler{db: db} }
+
+// Get returns the public author profile with their published posts
+func (h *Handler) Get(c *gin.Context) {
+	var user models.User
+	if err := h.db.Where("username = ? AND disabled = ?", c.Param("username"), false).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	fields, ok := postlist.ParseFields(c)
+	if !ok {
+		return
+	}
+	page := utils.QueryInt(c, "page", 0, 0, math.MaxInt)
+	size := utils.QueryInt(c, "size", 10, 1, 20)
+	q := h.db.Model(&models.Post{}).Where("author_id = ? AND status = ?", user.ID, models.PostPublished).
+		Scopes(postaccess.Listed(c))
+	var total int64
+	q.Count(&total)
+	items, err := postlist.Find(q.Limit(size).Offset(page*size).Order("created_at DESC"), fields)
+	var posts interface{}
+	if err == nil {
+		posts, err = fields.Apply(items)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"id":       user.ID,
+		"username": user.Username,
+		"avatar":   user.Avatar,
+		"bio":      user.Bio,
+		"role":     user.Role,
+		"posts":    gin.H{"total": total, "items": posts},
+	})
+}
@@ -3,8 +3,12 @@ package database
 import (
 	"easyblog/internal/config"
 	"easyblog/internal/models"
+	"easyblog/internal/render"
+	"easyblog/internal/slug"
+	"easyblog/internal/utils"
 	"errors"
 	"fmt"
+	"log"
 
 	"gorm.io/driver/mysql"
 	"gorm.io/driver/postgres"
@@ -43,29 +47,59 @@ func Initialize(cfg *config.Config) (*gorm.DB, error) {
 		return nil, err
 	}
 
+	if err := backfillSlugs(db); err != nil {
+		return nil, err
+	}
+
 	if err := db.AutoMigrate(
 		&models.User{},
 		&models.Category{},
 		&models.Tag{},
 		&models.Post{},
+		&models.PostRevision{},
+		&models.Series{},
+		&models.SeriesPart{},
+		&models.RelatedPosts{},
+		&models.RelatedLink{},
+		&models.RelatedPosting{},
+		&models.RelatedTerm{},
 		&models.Comment{},
 		&models.ConfigModel{},
 		&models.FriendsLink{},
+		&models.Session{},
+		&models.RefreshToken{},
+		&models.UsedToken{},
+		&models.RecoveryCode{},
+		&models.AccessToken{},
+		&models.LoginAttempt{},
+		&models.SigningKey{},
+		&models.UserIdentity{},
+		&models.OIDCState{},
+		&models.Invite{},
+		&models.SlugRedirect{},
 	); err != nil {
 		return nil, err
 	}
 
-	// initial
-	if err = db.Where("role = ?", models.RoleAdmin).First(&models.User{}).Error; err != nil {
-		if err = db.Create(&models.User{
-			Username: "admin",
-			Email:    "admin@easyblog.com",
-			Password: "8c6976e5b5410415bde908bd4dee15dfb167a9c873fc4bb8a81f6f2ab448a918", // admin
-			Avatar:   "",
-			Role:     "admin",
-		}).Error; err != nil {
-			return nil, err
-		}
+	if err := backfillPostStats(db); err != nil {
+		return nil, err
+	}
+
+	if err := releaseDeletedUsers(db); err != nil {
+		return nil, err
+	}
+
+	if err := normalizeScheduleTimes(db); err != nil {
+		return nil, err
+	}
+
+	// roles before RBAC only knew "user"
+	if err := db.Model(&models.User{}).Where("role = ?", "user").Update("role", models.RoleReader).Error; err != nil {
+		return nil, err
+	}
+
+	if err := seedAdmin(db, cfg); err != nil {
+		return nil, err
 	}
 	if err = db.Where("key = ?", "enable_register").First(&models.ConfigModel{}).Error; err != nil {
 		if err = db.Create(&models.ConfigModel{
@@ -75,6 +109,27 @@ func Initialize(cfg *config.Config) (*gorm.DB, error) {
 			return nil, err
 		}
 	}
+	// open, invite or approval; only applies while enable_register is true
+	if err := db.Where("key = ?", "register_mode").First(&models.ConfigModel{}).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			if err := db.Create(&models.ConfigModel{
+				Key:   "register_mode",
+				Value: "open",
+			}).Error; err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := db.Where("key = ?", "require_admin_2fa").First(&models.ConfigModel{}).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			if err := db.Create(&models.ConfigModel{
+				Key:   "require_admin_2fa",
+				Value: "false",
+			}).Error; err != nil {
+				return nil, err
+			}
+		}
+	}
 	if err := db.Where("key = ?", "sites_name").First(&models.ConfigModel{}).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			if err := db.Create(&models.ConfigModel{
@@ -88,3 +143,156 @@ func Initialize(cfg *config.Config) (*gorm.DB, error) {
 
 	return db, nil
 }
+
+// backfillSlugs adds the slug column to tables created before slugs existed
+// and fills it, so the unique index can be created afterwards
+func backfillSlugs(db *gorm.DB) error {
+	tables := []struct {
+		model    interface{}
+		source   string
+		fallback string
+	}{
+		{&models.Post{}, "title", slug.KindPost},
+		{&models.Category{}, "name", slug.KindCategory},
+		{&models.Tag{}, "name", slug.KindTag},
+	}
+	m := db.Migrator()
+	for _, t := range tables {
+		if !m.HasTable(t.model) || m.HasColumn(t.model, "Slug") {
+			continue
+		}
+		if err := m.AddColumn(t.model, "Slug"); err != nil {
+			return err
+		}
+		var rows []struct {
+			ID     uint
+			Source string
+		}
+		if err := db.Unscoped().Model(t.model).Select("id, " + t.source + " AS source").Order("id").Scan(&rows).Error; err != nil {
+			return err
+		}
+		for _, r := range rows {
+			s, err := slug.Unique(db, t.model, slug.Make(r.Source), fmt.Sprintf("%s-%d", t.fallback, r.ID), r.ID)
+			if err != nil {
+				return err
+			}
+			if err := db.Unscoped().Model(t.model).Where("id = ?", r.ID).UpdateColumn("slug", s).Error; err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// releaseDeletedUsers renames users deleted before deletion released their
+// username and email
+func releaseDeletedUsers(db *gorm.DB) error {
+	var users []models.User
+	if err := db.Unscoped().Where("deleted_at IS NOT NULL AND username NOT LIKE ?", "deleted-%").
+		Find(&users).Error; err != nil {
+		return err
+	}
+	for _, u := range users {
+		if err := db.Unscoped().Model(&u).UpdateColumns(map[string]interface{}{
+			"username": models.ReleasedName(u.ID, u.Username, 64),
+			"email":    models.ReleasedName(u.ID, u.Email, 128),
+		}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// normalizeScheduleTimes converts schedule and pin expiry times stored with
+// the offset of the client to UTC. Sqlite compares times as text, so the scheduler would
+// otherwise run mixed offsets early or late.
+func normalizeScheduleTimes(db *gorm.DB) error {
+	if db.Dialector.Name() != "sqlite" {
+		return nil
+	}
+	var posts []models.Post
+	err := db.Unscoped().Select("id", "publish_at", "unpublish_at", "pinned_until").
+		Where("publish_at NOT LIKE ? OR unpublish_at NOT LIKE ? OR pinned_until NOT LIKE ?", "%+00:00", "%+00:00", "%+00:00").
+		Find(&posts).Error
+	if err != nil {
+		return err
+	}
+	for _, p := range posts {
+		updates := map[string]interface{}{}
+		if p.PublishAt != nil {
+			updates["publish_at"] = p.PublishAt.UTC()
+		}
+		if p.UnpublishAt != nil {
+			updates["unpublish_at"] = p.UnpublishAt.UTC()
+		}
+		if p.PinnedUntil != nil {
+			updates["pinned_until"] = p.PinnedUntil.UTC()
+		}
+		if err := db.Unscoped().Model(&models.Post{}).Where("id = ?", p.ID).UpdateColumns(updates).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// backfillPostStats computes word counts, reading time, table of contents and
+// missing summaries for posts saved before they were computed on save
+func backfillPostStats(db *gorm.DB) error {
+	var batch []models.Post
+	return db.Select("id", "content", "summary", "summary_auto").
+		Where("COALESCE(word_count, 0) = 0 AND content <> ''").
+		FindInBatches(&batch, 100, func(tx *gorm.DB, _ int) error {
+			for _, p := range batch {
+				render.Analyze(p.Content).ApplyTo(&p)
+				err := db.Model(&p).Select("word_count", "reading_minutes", "toc", "summary", "summary_auto").
+					UpdateColumns(&p).Error
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		}).Error
+}
+
+// defaultAdminPassword is what the login page sends for the password "admin"
+var defaultAdminPassword = utils.SHA256Encode("admin")
+
+// seedAdmin creates the first admin with the well known password "admin",
+// which must be changed on first login. Admins seeded before that rule who
+// still have it are held to it too.
+func seedAdmin(db *gorm.DB, cfg *config.Config) error {
+	var admin models.User
+	if err := db.Where("role = ?", models.RoleAdmin).Order("id").Limit(1).Find(&admin).Error; err != nil {
+		return err
+	}
+	if admin.ID != 0 {
+		var seeded models.User
+		err := db.Where("email = ? AND must_change_password = ?", "admin@easyblog.com", false).Limit(1).Find(&seeded).Error
+		if err != nil || seeded.ID == 0 {
+			return err
+		}
+		if ok, _, err := utils.VerifyPassword(cfg.Server.Password, seeded.Password, defaultAdminPassword); err != nil || !ok {
+			return nil
+		}
+		log.Printf("user %s still has the default password, it must be changed on next login", seeded.Email)
+		return db.Model(&seeded).Update("must_change_password", true).Error
+	}
+	password, err := utils.HashPassword(cfg.Server.Password, defaultAdminPassword)
+	if err != nil {
+		return err
+	}
+	err = db.Create(&models.User{
+		Username:      "admin",
+		Email:         "admin@easyblog.com",
+		Password:      password,
+		Role:          models.RoleAdmin,
+		EmailVerified: true,
+		// the seeded password is public, nothing but a new one is accepted
+		MustChangePassword: true,
+	}).Error
+	if err != nil {
+		return err
+	}
+	log.Printf("created user admin@easyblog.com with password \"admin\", it must be changed on first login")
+	return nil
+}
@@ -0,0 +1,119 @@
+package database
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"easyblog/internal/config"
+	"easyblog/internal/models"
+	"easyblog/internal/utils"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestNormalizeScheduleTimes(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, _ := db.DB()
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+	if err := db.AutoMigrate(&models.Post{}); err != nil {
+		t.Fatal(err)
+	}
+
+	instant := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		at   time.Time
+	}{
+		{"utc", instant},
+		{"east of utc", instant.In(time.FixedZone("JST", 9*3600))},
+		{"west of utc", instant.In(time.FixedZone("PDT", -7*3600))},
+	}
+	for i, tt := range tests {
+		at := tt.at
+		post := models.Post{Slug: tt.name, Status: models.PostScheduled, PublishAt: &at}
+		if i == 2 {
+			post.Status, post.PublishAt, post.UnpublishAt = models.PostPublished, nil, &at
+		}
+		if err := db.Create(&post).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := normalizeScheduleTimes(db); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var raw string
+			db.Raw("SELECT COALESCE(publish_at, unpublish_at) FROM posts WHERE slug = ?", tt.name).Scan(&raw)
+			if !strings.HasSuffix(raw, "+00:00") {
+				t.Errorf("stored %q, want utc", raw)
+			}
+			var post models.Post
+			db.Where("slug = ?", tt.name).First(&post)
+			got := post.PublishAt
+			if got == nil {
+				got = post.UnpublishAt
+			}
+			if got == nil || !got.Equal(instant) {
+				t.Errorf("time = %v, want %v", got, instant)
+			}
+		})
+	}
+}
+
+func TestSeedAdmin(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Server.Password.Algorithm = utils.PasswordBcrypt
+	cfg.Server.Password.BcryptCost = bcrypt.MinCost
+	hash := func(password string) string {
+		h, err := utils.HashPassword(cfg.Server.Password, utils.SHA256Encode(password))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return h
+	}
+	tests := []struct {
+		name     string
+		existing *models.User
+		want     bool
+	}{
+		{"fresh install", nil, true},
+		{"seeded before, default password", &models.User{Username: "admin", Email: "admin@easyblog.com", Password: hash("admin"), Role: models.RoleAdmin}, true},
+		{"seeded before, password changed", &models.User{Username: "admin", Email: "admin@easyblog.com", Password: hash("s3cret"), Role: models.RoleAdmin}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			sqlDB, _ := db.DB()
+			sqlDB.SetMaxOpenConns(1)
+			t.Cleanup(func() { sqlDB.Close() })
+			if err := db.AutoMigrate(&models.User{}); err != nil {
+				t.Fatal(err)
+			}
+			if tt.existing != nil {
+				db.Create(tt.existing)
+			}
+			if err := seedAdmin(db, cfg); err != nil {
+				t.Fatal(err)
+			}
+			var admins []models.User
+			db.Where("role = ?", models.RoleAdmin).Find(&admins)
+			if len(admins) != 1 {
+				t.Fatalf("admins = %d, want 1", len(admins))
+			}
+			if admins[0].MustChangePassword != tt.want {
+				t.Errorf("must_change_password = %v, want %v", admins[0].MustChangePassword, tt.want)
+			}
+		})
+	}
+}
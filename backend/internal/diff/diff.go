@@ -0,0 +1,222 @@
+// Package diff produces line based unified diffs using Myers' algorithm.
+package diff
+
+import (
+	"fmt"
+	"strings"
+)
+
+type opKind byte
+
+const (
+	opEqual  opKind = ' '
+	opDelete opKind = '-'
+	opInsert opKind = '+'
+)
+
+type op struct {
+	kind opKind
+	// positions in a and b before the operation applies
+	a, b int
+}
+
+// Unified returns the differences between a and b in unified diff format
+// with context lines around each change. It is empty when a equals b.
+func Unified(aName, bName, a, b string, context int) string {
+	al, bl := splitLines(a), splitLines(b)
+	ops := myers(al, bl)
+	hunks := group(ops, context)
+	if len(hunks) == 0 {
+		return ""
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "--- %s\n+++ %s\n", aName, bName)
+	for _, h := range hunks {
+		aStart, aLen, bStart, bLen := hunkRange(h)
+		fmt.Fprintf(&sb, "@@ -%s +%s @@\n", rangeStr(aStart, aLen), rangeStr(bStart, bLen))
+		for _, o := range h {
+			var line string
+			if o.kind == opInsert {
+				line = bl[o.b]
+			} else {
+				line = al[o.a]
+			}
+			sb.WriteByte(byte(o.kind))
+			sb.WriteString(line)
+			sb.WriteByte('\n')
+		}
+	}
+	return sb.String()
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+// maxEdits bounds the work for unrelated texts; past it the whole text is
+// reported as replaced
+const maxEdits = 2000
+
+// myers computes a shortest edit script between a and b
+func myers(a, b []string) []op {
+	n, m := len(a), len(b)
+	max := n + m
+	offset := max + 1
+	v := make([]int, 2*max+3)
+	var trace [][]int
+	for d := 0; d <= max; d++ {
+		if d > maxEdits {
+			return replaceAll(n, m)
+		}
+		// only diagonals -d-1..d+1 are read when backtracking step d
+		trace = append(trace, append([]int(nil), v[offset-d-1:offset+d+2]...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || k != d && v[offset+k-1] < v[offset+k+1] {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				return backtrack(trace, n, m, d)
+			}
+		}
+	}
+	return nil
+}
+
+func backtrack(trace [][]int, n, m, d int) []op {
+	x, y := n, m
+	var ops []op
+	for ; d > 0; d-- {
+		v := trace[d]
+		at := func(k int) int { return v[k+d+1] }
+		k := x - y
+		var prevK int
+		if k == -d || k != d && at(k-1) < at(k+1) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := at(prevK)
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			x--
+			y--
+			ops = append(ops, op{opEqual, x, y})
+		}
+		if x == prevX {
+			y--
+			ops = append(ops, op{opInsert, x, y})
+		} else {
+			x--
+			ops = append(ops, op{opDelete, x, y})
+		}
+	}
+	for x > 0 && y > 0 {
+		x--
+		y--
+		ops = append(ops, op{opEqual, x, y})
+	}
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+	return ops
+}
+
+func replaceAll(n, m int) []op {
+	ops := make([]op, 0, n+m)
+	for i := 0; i < n; i++ {
+		ops = append(ops, op{opDelete, i, 0})
+	}
+	for j := 0; j < m; j++ {
+		ops = append(ops, op{opInsert, n, j})
+	}
+	return ops
+}
+
+// group splits the edit script into hunks with up to context equal lines
+// around the changes
+func group(ops []op, context int) [][]op {
+	var hunks [][]op
+	var cur []op
+	lastChange := -1
+	for i, o := range ops {
+		if o.kind == opEqual {
+			continue
+		}
+		start := i - context
+		if start < 0 {
+			start = 0
+		}
+		if cur != nil && start <= lastChange+context+1 {
+			// close enough to the previous change to share the hunk
+			cur = append(cur, ops[lastChange+1:i+1]...)
+		} else {
+			if cur != nil {
+				hunks = append(hunks, closeHunk(cur, ops, lastChange, context))
+			}
+			cur = append([]op(nil), ops[start:i+1]...)
+		}
+		lastChange = i
+	}
+	if cur != nil {
+		hunks = append(hunks, closeHunk(cur, ops, lastChange, context))
+	}
+	return hunks
+}
+
+func closeHunk(cur, ops []op, lastChange, context int) []op {
+	end := lastChange + 1 + context
+	if end > len(ops) {
+		end = len(ops)
+	}
+	return append(cur, ops[lastChange+1:end]...)
+}
+
+// hunkRange returns 1-based start lines and lengths of a hunk
+func hunkRange(h []op) (aStart, aLen, bStart, bLen int) {
+	aStart, bStart = -1, -1
+	for _, o := range h {
+		switch o.kind {
+		case opEqual:
+			aLen++
+			bLen++
+		case opDelete:
+			aLen++
+		case opInsert:
+			bLen++
+		}
+		if aStart < 0 && o.kind != opInsert {
+			aStart = o.a
+		}
+		if bStart < 0 && o.kind != opDelete {
+			bStart = o.b
+		}
+	}
+	// insert only or delete only hunks anchor at the neighbouring line
+	if aStart < 0 {
+		aStart = h[0].a - 1
+	}
+	if bStart < 0 {
+		bStart = h[0].b - 1
+	}
+	return aStart + 1, aLen, bStart + 1, bLen
+}
+
+func rangeStr(start, length int) string {
+	if length == 1 {
+		return fmt.Sprint(start)
+	}
+	return fmt.Sprintf("%d,%d", start, length)
+}
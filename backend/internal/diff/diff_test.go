@@ -0,0 +1,105 @@
+package diff
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestUnified(t *testing.T) {
+	nine := "l1\nl2\nl3\nl4\nl5\nl6\nl7\nl8\nl9\n"
+	changed := "l1\nL2\nl3\nl4\nl5\nl6\nl7\nl9\nl10\n"
+	tests := []struct {
+		name    string
+		a, b    string
+		context int
+		want    string
+	}{
+		{"equal", "same\ntext\n", "same\ntext\n", 3, ""},
+		{"line ending only", "a\r\nb\r\n", "a\nb", 3, ""},
+		{
+			"separate hunks", nine, changed, 1,
+			"--- a\n+++ b\n" +
+				"@@ -1,3 +1,3 @@\n l1\n-l2\n+L2\n l3\n" +
+				"@@ -7,3 +7,3 @@\n l7\n-l8\n l9\n+l10\n",
+		},
+		{
+			"hunks merged by context", nine, changed, 3,
+			"--- a\n+++ b\n" +
+				"@@ -1,9 +1,9 @@\n l1\n-l2\n+L2\n l3\n l4\n l5\n l6\n l7\n-l8\n l9\n+l10\n",
+		},
+		{"insert only", "a\nb\nc\n", "a\nb\nX\nc\n", 0, "--- a\n+++ b\n@@ -2,0 +3 @@\n+X\n"},
+		{"delete only", "a\nb\nX\nc\n", "a\nb\nc\n", 0, "--- a\n+++ b\n@@ -3 +2,0 @@\n-X\n"},
+		{"from empty", "", "x\ny\n", 3, "--- a\n+++ b\n@@ -0,0 +1,2 @@\n+x\n+y\n"},
+		{"to empty", "x\ny\n", "", 3, "--- a\n+++ b\n@@ -1,2 +0,0 @@\n-x\n-y\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Unified("a", "b", tt.a, tt.b, tt.context); got != tt.want {
+				t.Errorf("Unified =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+// applying the diff to a must give b, also past maxEdits where the whole
+// text is reported as replaced
+func TestUnifiedApplies(t *testing.T) {
+	var long, other []string
+	for i := 0; i < maxEdits+10; i++ {
+		long = append(long, "a"+strings.Repeat("x", i%7))
+		other = append(other, "b"+strings.Repeat("y", i%5))
+	}
+	tests := []struct {
+		name string
+		a, b string
+	}{
+		{"interleaved", "1\n2\n3\n4\n5\n6\n", "0\n2\n3\n3\n5\n7\n"},
+		{"reordered", "a\nb\nc\nd\n", "d\nc\nb\na\n"},
+		{"unrelated past maxEdits", strings.Join(long, "\n"), strings.Join(other, "\n")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := apply(t, splitLines(tt.a), Unified("a", "b", tt.a, tt.b, 2))
+			if want := splitLines(tt.b); strings.Join(got, "\n") != strings.Join(want, "\n") {
+				t.Errorf("patched a = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+// apply patches a with a unified diff, relying only on the hunk bodies
+func apply(t *testing.T, a []string, patch string) []string {
+	var out []string
+	pos := 0
+	for _, line := range strings.Split(strings.TrimSuffix(patch, "\n"), "\n") {
+		switch {
+		case strings.HasPrefix(line, "---"), strings.HasPrefix(line, "+++"), line == "":
+		case strings.HasPrefix(line, "@@"):
+			start, length, _ := strings.Cut(strings.Fields(line)[1][1:], ",")
+			aStart, err := strconv.Atoi(start)
+			if err != nil {
+				t.Fatalf("bad hunk header %q", line)
+			}
+			// empty ranges name the line before the hunk
+			if length == "0" {
+				aStart++
+			}
+			for pos < aStart-1 {
+				out = append(out, a[pos])
+				pos++
+			}
+		case line[0] == ' ':
+			out = append(out, a[pos])
+			pos++
+		case line[0] == '-':
+			if a[pos] != line[1:] {
+				t.Fatalf("deleted line %q does not match %q", line[1:], a[pos])
+			}
+			pos++
+		case line[0] == '+':
+			out = append(out, line[1:])
+		}
+	}
+	return append(out, a[pos:]...)
+}
@@ -0,0 +1,513 @@
+// Package keys holds the keys used to sign and verify the tokens issued by
+// the server. Asymmetric keys are published as a JWKS so other services can
+// verify access tokens without sharing a secret. Tokens only the server reads
+// back, such as action tokens, are HMACs under a secret derived from the
+// private key instead, so they never verify against the JWKS.
+package keys
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"easyblog/internal/config"
+	"easyblog/internal/models"
+	"easyblog/internal/oidc"
+
+	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/gorm"
+)
+
+const (
+	AlgEdDSA = "EdDSA"
+	AlgRS256 = "RS256"
+	AlgHS256 = "HS256"
+
+	// other instances may rotate the database keys, so pick them up now and then
+	reloadInterval = 5 * time.Minute
+	// refetch on unknown kid at most this often
+	missInterval = 10 * time.Second
+)
+
+var (
+	ErrUnknownKey  = errors.New("unknown signing key")
+	ErrStaticKeys  = errors.New("keys are configured in files and cannot be rotated here")
+	ErrUnsupported = errors.New("unsupported jwt algorithm")
+)
+
+// Key is a single verification key, with the private half when it can sign
+type Key struct {
+	Kid       string
+	Algorithm string
+	Public    crypto.PublicKey
+	Private   crypto.Signer
+	CreatedAt time.Time
+	RetiredAt *time.Time
+	// HMAC secret of internal tokens, nil for keys without a private half
+	internal []byte
+}
+
+type KeySet struct {
+	db  *gorm.DB
+	cfg *config.Config
+
+	mu       sync.RWMutex
+	signing  *Key
+	verify   map[string]*Key
+	loadedAt time.Time
+	missAt   time.Time
+}
+
+// Load prepares the key set from the configuration. Without configured key
+// files the keys live in the database and one is generated on first start.
+func Load(db *gorm.DB, cfg *config.Config) (*KeySet, error) {
+	ks := &KeySet{db: db, cfg: cfg}
+	switch ks.Algorithm() {
+	case AlgHS256:
+		if cfg.Server.JWT.Secret == "" {
+			return nil, errors.New("jwt secret is required for HS256")
+		}
+		key := &Key{Algorithm: AlgHS256}
+		key.internal = internalSecret([]byte(cfg.Server.JWT.Secret))
+		ks.signing = key
+		ks.verify = map[string]*Key{"": key}
+		return ks, nil
+	case AlgEdDSA, AlgRS256:
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupported, cfg.Server.JWT.Algorithm)
+	}
+	if ks.Managed() {
+		if err := ks.reload(); err != nil {
+			return nil, err
+		}
+		return ks, nil
+	}
+	if err := ks.loadFiles(); err != nil {
+		return nil, err
+	}
+	return ks, nil
+}
+
+func (ks *KeySet) Algorithm() string {
+	if ks.cfg.Server.JWT.Algorithm == "" {
+		return AlgEdDSA
+	}
+	return ks.cfg.Server.JWT.Algorithm
+}
+
+// Managed reports whether keys are generated and rotated in the database
+func (ks *KeySet) Managed() bool {
+	return ks.Algorithm() != AlgHS256 && len(ks.cfg.Server.JWT.Keys) == 0
+}
+
+// current returns the signing key, picking up rotations by other instances
+func (ks *KeySet) current() (*Key, error) {
+	if ks.Managed() {
+		ks.mu.RLock()
+		stale := time.Since(ks.loadedAt) > reloadInterval
+		ks.mu.RUnlock()
+		if stale {
+			if err := ks.reload(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	ks.mu.RLock()
+	key := ks.signing
+	ks.mu.RUnlock()
+	if key == nil {
+		return nil, ErrUnknownKey
+	}
+	return key, nil
+}
+
+// Sign signs claims with the current key and sets its kid header, and the
+// typ header unless typ is empty
+func (ks *KeySet) Sign(typ string, claims jwt.Claims) (string, error) {
+	key, err := ks.current()
+	if err != nil {
+		return "", err
+	}
+	token := jwt.NewWithClaims(jwt.GetSigningMethod(key.Algorithm), claims)
+	if typ != "" {
+		token.Header["typ"] = typ
+	}
+	if key.Algorithm == AlgHS256 {
+		return token.SignedString([]byte(ks.cfg.Server.JWT.Secret))
+	}
+	token.Header["kid"] = key.Kid
+	return token.SignedString(key.Private)
+}
+
+// SignInternal signs claims that only this server reads back. The HMAC
+// secret is derived from the current private key and never published.
+func (ks *KeySet) SignInternal(claims jwt.Claims) (string, error) {
+	key, err := ks.current()
+	if err != nil {
+		return "", err
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	if key.Kid != "" {
+		token.Header["kid"] = key.Kid
+	}
+	return token.SignedString(key.internal)
+}
+
+// ParseInternal verifies a token issued by SignInternal
+func (ks *KeySet) ParseInternal(token string, claims jwt.Claims, opts ...jwt.ParserOption) (*jwt.Token, error) {
+	opts = append(opts, jwt.WithValidMethods([]string{AlgHS256}))
+	return jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		key, err := ks.lookup(kid)
+		if err != nil {
+			return nil, err
+		}
+		if key.internal == nil {
+			return nil, ErrUnknownKey
+		}
+		return key.internal, nil
+	}, opts...)
+}
+
+// internalSecret derives the HMAC secret of internal tokens from the
+// secret material of a key
+func internalSecret(material []byte) []byte {
+	mac := hmac.New(sha256.New, material)
+	mac.Write([]byte("easyblog internal tokens"))
+	return mac.Sum(nil)
+}
+
+func privateSecret(priv crypto.Signer) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return nil, err
+	}
+	return internalSecret(der), nil
+}
+
+// Parse verifies the signature of token with the key named by its kid.
+// Only the algorithm of that key is accepted, so an asymmetric public key can
+// never be used as an HMAC secret.
+func (ks *KeySet) Parse(token string, claims jwt.Claims, opts ...jwt.ParserOption) (*jwt.Token, error) {
+	opts = append(opts, jwt.WithValidMethods([]string{AlgEdDSA, AlgRS256, AlgHS256}))
+	return jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		key, err := ks.lookup(kid)
+		if err != nil {
+			return nil, err
+		}
+		if t.Method.Alg() != key.Algorithm {
+			return nil, fmt.Errorf("unexpected signing method %s", t.Method.Alg())
+		}
+		if key.Algorithm == AlgHS256 {
+			return []byte(ks.cfg.Server.JWT.Secret), nil
+		}
+		return key.Public, nil
+	}, opts...)
+}
+
+// JWKS returns the public keys that currently verify tokens
+func (ks *KeySet) JWKS() oidc.JWKS {
+	set := oidc.JWKS{Keys: []oidc.JWK{}}
+	for _, k := range ks.Keys() {
+		if k.Algorithm == AlgHS256 {
+			continue
+		}
+		jwk, err := oidc.NewJWK(k.Kid, k.Algorithm, k.Public)
+		if err == nil {
+			set.Keys = append(set.Keys, jwk)
+		}
+	}
+	return set
+}
+
+// Keys lists the verification keys, the signing key first
+func (ks *KeySet) Keys() []*Key {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	out := make([]*Key, 0, len(ks.verify))
+	for _, k := range ks.verify {
+		out = append(out, k)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i] == ks.signing || out[j] == ks.signing {
+			return out[i] == ks.signing
+		}
+		return out[i].CreatedAt.After(out[j].CreatedAt)
+	})
+	return out
+}
+
+// SigningKid is the kid of the key new tokens are signed with
+func (ks *KeySet) SigningKid() string {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	if ks.signing == nil {
+		return ""
+	}
+	return ks.signing.Kid
+}
+
+// Rotate generates a new signing key. Previous keys keep verifying tokens
+// for retired_key_hour so sessions survive the rotation.
+func (ks *KeySet) Rotate() error {
+	if !ks.Managed() {
+		return ErrStaticKeys
+	}
+	err := ks.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.SigningKey{}).Where("retired_at IS NULL").
+			Update("retired_at", time.Now()).Error; err != nil {
+			return err
+		}
+		_, err := ks.generate(tx)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return ks.reload()
+}
+
+func (ks *KeySet) lookup(kid string) (*Key, error) {
+	ks.mu.RLock()
+	key, ok := ks.verify[kid]
+	missed := time.Since(ks.missAt) < missInterval
+	ks.mu.RUnlock()
+	if ok {
+		return key, nil
+	}
+	if !ks.Managed() || missed {
+		return nil, ErrUnknownKey
+	}
+	// possibly rotated by another instance
+	ks.mu.Lock()
+	ks.missAt = time.Now()
+	ks.mu.Unlock()
+	if err := ks.reload(); err != nil {
+		return nil, err
+	}
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	if key, ok := ks.verify[kid]; ok {
+		return key, nil
+	}
+	return nil, ErrUnknownKey
+}
+
+// reload reads the database keys, generating a signing key when there is
+// none for the configured algorithm, and drops keys retired too long ago
+func (ks *KeySet) reload() error {
+	cutoff := time.Now().Add(-time.Duration(ks.cfg.Server.JWT.RetiredKeyHour) * time.Hour)
+	var rows []models.SigningKey
+	err := ks.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("retired_at IS NOT NULL AND retired_at < ?", cutoff).Delete(&models.SigningKey{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Order("id desc").Find(&rows).Error; err != nil {
+			return err
+		}
+		for _, r := range rows {
+			if r.RetiredAt == nil && r.Algorithm == ks.Algorithm() {
+				return nil
+			}
+		}
+		// first start or the algorithm changed
+		if err := tx.Model(&models.SigningKey{}).Where("retired_at IS NULL").
+			Update("retired_at", time.Now()).Error; err != nil {
+			return err
+		}
+		row, err := ks.generate(tx)
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		for i := range rows {
+			if rows[i].RetiredAt == nil {
+				rows[i].RetiredAt = &now
+			}
+		}
+		rows = append([]models.SigningKey{*row}, rows...)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	verify := make(map[string]*Key, len(rows))
+	var signing *Key
+	for _, r := range rows {
+		key, err := parsePrivateKey([]byte(r.PrivateKey))
+		if err != nil {
+			return fmt.Errorf("signing key %s: %w", r.Kid, err)
+		}
+		secret, err := privateSecret(key)
+		if err != nil {
+			return fmt.Errorf("signing key %s: %w", r.Kid, err)
+		}
+		k := &Key{
+			Kid:       r.Kid,
+			Algorithm: r.Algorithm,
+			Public:    key.Public(),
+			Private:   key,
+			CreatedAt: r.CreatedAt,
+			RetiredAt: r.RetiredAt,
+			internal:  secret,
+		}
+		verify[k.Kid] = k
+		// rows are newest first
+		if signing == nil && r.RetiredAt == nil && r.Algorithm == ks.Algorithm() {
+			signing = k
+		}
+	}
+	ks.mu.Lock()
+	ks.verify = verify
+	ks.signing = signing
+	ks.loadedAt = time.Now()
+	ks.mu.Unlock()
+	return nil
+}
+
+func (ks *KeySet) generate(tx *gorm.DB) (*models.SigningKey, error) {
+	var (
+		priv crypto.Signer
+		err  error
+	)
+	switch ks.Algorithm() {
+	case AlgEdDSA:
+		_, priv, err = ed25519.GenerateKey(rand.Reader)
+	case AlgRS256:
+		priv, err = rsa.GenerateKey(rand.Reader, 2048)
+	default:
+		return nil, ErrUnsupported
+	}
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return nil, err
+	}
+	kid := make([]byte, 8)
+	if _, err := rand.Read(kid); err != nil {
+		return nil, err
+	}
+	row := &models.SigningKey{
+		Kid:        hex.EncodeToString(kid),
+		Algorithm:  ks.Algorithm(),
+		PrivateKey: string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+	}
+	if err := tx.Create(row).Error; err != nil {
+		return nil, err
+	}
+	return row, nil
+}
+
+// loadFiles reads the configured PEM keys; the first one with a private key signs
+func (ks *KeySet) loadFiles() error {
+	verify := make(map[string]*Key, len(ks.cfg.Server.JWT.Keys))
+	var signing *Key
+	for i, kc := range ks.cfg.Server.JWT.Keys {
+		if kc.Kid == "" {
+			return fmt.Errorf("jwt key %d: kid is required", i)
+		}
+		k := &Key{Kid: kc.Kid}
+		switch {
+		case kc.PrivateKeyFile != "":
+			data, err := os.ReadFile(kc.PrivateKeyFile)
+			if err != nil {
+				return fmt.Errorf("jwt key %s: %w", kc.Kid, err)
+			}
+			priv, err := parsePrivateKey(data)
+			if err != nil {
+				return fmt.Errorf("jwt key %s: %w", kc.Kid, err)
+			}
+			k.Private, k.Public = priv, priv.Public()
+			if k.internal, err = privateSecret(priv); err != nil {
+				return fmt.Errorf("jwt key %s: %w", kc.Kid, err)
+			}
+		case kc.PublicKeyFile != "":
+			data, err := os.ReadFile(kc.PublicKeyFile)
+			if err != nil {
+				return fmt.Errorf("jwt key %s: %w", kc.Kid, err)
+			}
+			pub, err := parsePublicKey(data)
+			if err != nil {
+				return fmt.Errorf("jwt key %s: %w", kc.Kid, err)
+			}
+			k.Public = pub
+		default:
+			return fmt.Errorf("jwt key %s: private_key_file or public_key_file is required", kc.Kid)
+		}
+		alg, err := algorithmOf(k.Public)
+		if err != nil {
+			return fmt.Errorf("jwt key %s: %w", kc.Kid, err)
+		}
+		k.Algorithm = alg
+		verify[k.Kid] = k
+		if signing == nil && k.Private != nil && alg == ks.Algorithm() {
+			signing = k
+		}
+	}
+	if signing == nil {
+		return fmt.Errorf("no %s private key configured", ks.Algorithm())
+	}
+	ks.verify = verify
+	ks.signing = signing
+	return nil
+}
+
+func algorithmOf(pub crypto.PublicKey) (string, error) {
+	switch pub.(type) {
+	case ed25519.PublicKey:
+		return AlgEdDSA, nil
+	case *rsa.PublicKey:
+		return AlgRS256, nil
+	default:
+		return "", fmt.Errorf("%w: key type %T", ErrUnsupported, pub)
+	}
+}
+
+func parsePrivateKey(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("%w: key type %T", ErrUnsupported, key)
+	}
+	if _, err := algorithmOf(signer.Public()); err != nil {
+		return nil, err
+	}
+	return signer, nil
+}
+
+func parsePublicKey(data []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data")
+	}
+	if key, err := x509.ParsePKCS1PublicKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	return x509.ParsePKIXPublicKey(block.Bytes)
+}
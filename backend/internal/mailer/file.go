@@ -0,0 +1,51 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// FileMailer writes every message as an .eml file, for development and tests
+type FileMailer struct {
+	From string
+	Dir  string
+
+	mu  sync.Mutex
+	seq int
+}
+
+func (m *FileMailer) Send(_ context.Context, msg Message) error {
+	if err := os.MkdirAll(m.Dir, 0o755); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	m.seq++
+	name := fmt.Sprintf("%s-%04d.eml", time.Now().Format("20060102-150405"), m.seq)
+	m.mu.Unlock()
+	return os.WriteFile(filepath.Join(m.Dir, name), build(m.From, msg), 0o644)
+}
+
+// LogMailer only prints messages to the server log, for development. Links
+// carry live credentials, so their tokens are redacted; use FileMailer to
+// follow them.
+type LogMailer struct {
+	From string
+}
+
+func (m *LogMailer) Send(_ context.Context, msg Message) error {
+	log.Printf("mail to %s: %s\n%s", msg.To, msg.Subject, redact(msg.Body))
+	return nil
+}
+
+var tokenParam = regexp.MustCompile(`([?&#]token=)[^&#\s]+`)
+
+// redact blanks the token query parameter of every link in s
+func redact(s string) string {
+	return tokenParam.ReplaceAllString(s, "${1}REDACTED")
+}
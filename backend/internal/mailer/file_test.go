@@ -0,0 +1,26 @@
+package mailer
+
+import "testing"
+
+func TestRedact(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"no links here", "no links here"},
+		{
+			"Verify: http://localhost:5173/verify-email?token=eyJhbGci.eyJzdWIi.c2ln\n",
+			"Verify: http://localhost:5173/verify-email?token=REDACTED\n",
+		},
+		{
+			"https://blog/reset?lang=en&token=abc%2Bdef&x=1",
+			"https://blog/reset?lang=en&token=REDACTED&x=1",
+		},
+		{"a?token=one b?token=two", "a?token=REDACTED b?token=REDACTED"},
+		{"mytoken=keep", "mytoken=keep"},
+	}
+	for _, tt := range tests {
+		if got := redact(tt.in); got != tt.want {
+			t.Errorf("redact(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package mailer
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"text/template"
+
+	"easyblog/internal/config"
+)
+
+const (
+	TemplateVerifyEmail   = "verify_email"
+	TemplateResetPassword = "reset_password"
+)
+
+var defaultTemplates = map[string]config.MailTemplate{
+	TemplateVerifyEmail: {
+		Subject: "[{{.SiteName}}] Verify your email address",
+		Body: `Hi {{.Username}},
+
+Please confirm your email address by opening the link below:
+
+{{.Link}}
+
+The link expires in {{.ExpiresIn}}. If you did not sign up, ignore this email.
+`,
+	},
+	TemplateResetPassword: {
+		Subject: "[{{.SiteName}}] Reset your password",
+		Body: `Hi {{.Username}},
+
+Someone requested a password reset for your account. Open the link below to choose a new password:
+
+{{.Link}}
+
+The link expires in {{.ExpiresIn}}. If you did not request this, ignore this email.
+`,
+	},
+}
+
+type TemplateData struct {
+	SiteName  string
+	Username  string
+	Link      string
+	ExpiresIn string
+}
+
+// Render builds the named message, preferring templates overridden in config
+func Render(cfg config.MailConfig, name, to string, data TemplateData) (Message, error) {
+	tpl, ok := defaultTemplates[name]
+	if !ok {
+		return Message{}, fmt.Errorf("unknown mail template: %s", name)
+	}
+	if o, ok := cfg.Templates[name]; ok {
+		if o.Subject != "" {
+			tpl.Subject = o.Subject
+		}
+		if o.Body != "" {
+			tpl.Body = o.Body
+		}
+		if o.BodyFile != "" {
+			b, err := os.ReadFile(o.BodyFile)
+			if err != nil {
+				return Message{}, err
+			}
+			tpl.Body = string(b)
+		}
+	}
+	subject, err := execute(name+".subject", tpl.Subject, data)
+	if err != nil {
+		return Message{}, err
+	}
+	body, err := execute(name+".body", tpl.Body, data)
+	if err != nil {
+		return Message{}, err
+	}
+	return Message{To: to, Subject: subject, Body: body}, nil
+}
+
+func execute(name, text string, data TemplateData) (string, error) {
+	t, err := template.New(name).Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,202 @@
+package mdport
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// MaxFileSize bounds a single Markdown file, larger ones are rejected
+// rather than read into memory
+const MaxFileSize = 8 << 20
+
+// Archives are bounded as a whole too, a small zip may unpack to far more
+const (
+	// MaxArchiveSize bounds the Markdown read from one zip archive
+	MaxArchiveSize = 256 << 20
+	// MaxArchiveEntries bounds the entries of one zip archive, of any kind
+	MaxArchiveEntries = 10000
+)
+
+var (
+	ErrArchiveTooLarge = fmt.Errorf("archive holds more than %d MB of markdown", MaxArchiveSize>>20)
+	ErrTooManyEntries  = fmt.Errorf("archive has more than %d entries", MaxArchiveEntries)
+)
+
+// File is a Markdown file, Name is its slash separated path inside the
+// archive or directory
+type File struct {
+	Name string
+	Data []byte
+}
+
+// isMarkdown skips everything but posts: other files, hidden files and
+// Hugo's _index.md section pages
+func isMarkdown(name string) bool {
+	for _, part := range strings.Split(name, "/") {
+		if strings.HasPrefix(part, ".") || strings.HasPrefix(part, "_") {
+			return false
+		}
+	}
+	switch strings.ToLower(path.Ext(name)) {
+	case ".md", ".markdown":
+		return true
+	}
+	return false
+}
+
+// SlugFromName derives a slug from the file name like Hugo does; page
+// bundles (post/index.md) are named after their directory
+func SlugFromName(name string) string {
+	base := strings.TrimSuffix(path.Base(name), path.Ext(name))
+	if strings.EqualFold(base, "index") {
+		if dir := path.Base(path.Dir(name)); dir != "." && dir != "/" {
+			return dir
+		}
+	}
+	return base
+}
+
+// ReadZip returns the Markdown files of a zip archive. It fails with
+// ErrTooManyEntries or ErrArchiveTooLarge as soon as the archive exceeds
+// MaxArchiveEntries or MaxArchiveSize.
+func ReadZip(r io.ReaderAt, size int64) ([]File, error) {
+	return readZip(r, size, MaxArchiveEntries, MaxArchiveSize)
+}
+
+func readZip(r io.ReaderAt, size int64, maxEntries int, maxSize int64) ([]File, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+	if len(zr.File) > maxEntries {
+		return nil, ErrTooManyEntries
+	}
+	var files []File
+	var total int64
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() || !isMarkdown(f.Name) {
+			continue
+		}
+		if f.UncompressedSize64 > MaxFileSize {
+			return nil, fmt.Errorf("%s: file too large", f.Name)
+		}
+		if total+int64(f.UncompressedSize64) > maxSize {
+			return nil, ErrArchiveTooLarge
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", f.Name, err)
+		}
+		// the header size may lie, never read past either limit
+		limit := min(MaxFileSize, maxSize-total)
+		data, err := io.ReadAll(io.LimitReader(rc, limit+1))
+		rc.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", f.Name, err)
+		}
+		if int64(len(data)) > limit {
+			if limit < MaxFileSize {
+				return nil, ErrArchiveTooLarge
+			}
+			return nil, fmt.Errorf("%s: file too large", f.Name)
+		}
+		total += int64(len(data))
+		files = append(files, File{Name: f.Name, Data: data})
+	}
+	sortFiles(files)
+	return files, nil
+}
+
+// ReadDir returns the Markdown files below dir
+func ReadDir(dir string) ([]File, error) {
+	var files []File
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if d.IsDir() {
+			if rel != "." && (strings.HasPrefix(d.Name(), ".") || strings.HasPrefix(d.Name(), "_")) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !isMarkdown(rel) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if info.Size() > MaxFileSize {
+			return fmt.Errorf("%s: file too large", rel)
+		}
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		files = append(files, File{Name: rel, Data: data})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sortFiles(files)
+	return files, nil
+}
+
+// Markdown keeps the Markdown files of files, sorted by name
+func Markdown(files []File) []File {
+	var out []File
+	for _, f := range files {
+		if isMarkdown(f.Name) {
+			out = append(out, f)
+		}
+	}
+	sortFiles(out)
+	return out
+}
+
+func sortFiles(files []File) {
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+}
+
+// WriteZip writes files into a zip archive
+func WriteZip(w io.Writer, files []File) error {
+	zw := zip.NewWriter(w)
+	for _, f := range files {
+		fw, err := zw.Create(f.Name)
+		if err != nil {
+			return err
+		}
+		if _, err := fw.Write(f.Data); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// WriteDir writes files below dir, creating it when missing
+func WriteDir(dir string, files []File) error {
+	for _, f := range files {
+		p := filepath.Join(dir, filepath.FromSlash(f.Name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(p, f.Data, 0o644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
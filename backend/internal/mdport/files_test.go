@@ -0,0 +1,119 @@
+package mdport
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// zipOf packs entries, name and content pairs, into a zip archive
+func zipOf(t *testing.T, entries ...string) *bytes.Reader {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for i := 0; i < len(entries); i += 2 {
+		w, err := zw.Create(entries[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(entries[i+1]))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return bytes.NewReader(buf.Bytes())
+}
+
+func names(files []File) []string {
+	out := []string{}
+	for _, f := range files {
+		out = append(out, f.Name)
+	}
+	return out
+}
+
+func TestReadZip(t *testing.T) {
+	tests := []struct {
+		name       string
+		entries    []string
+		maxEntries int
+		maxSize    int64
+		want       []string
+		wantErr    error
+	}{
+		{"markdown only", []string{"b.md", "b", "a.markdown", "a", "img.png", "x", ".hidden.md", "h", "_index.md", "i", "post/index.md", "p"},
+			10, 100, []string{"a.markdown", "b.md", "post/index.md"}, nil},
+		{"entries at the limit", []string{"a.md", "a", "b.png", "b"}, 2, 100, []string{"a.md"}, nil},
+		{"too many entries", []string{"a.md", "a", "b.png", "b", "c.png", "c"}, 2, 100, nil, ErrTooManyEntries},
+		{"size at the limit", []string{"a.md", "12345", "b.md", "12345"}, 10, 10, []string{"a.md", "b.md"}, nil},
+		{"too large", []string{"a.md", "12345", "b.md", "123456"}, 10, 10, nil, ErrArchiveTooLarge},
+		{"other files do not count", []string{"a.md", "12345", "b.png", strings.Repeat("x", 100)}, 10, 10, []string{"a.md"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := zipOf(t, tt.entries...)
+			files, err := readZip(r, r.Size(), tt.maxEntries, tt.maxSize)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && !reflect.DeepEqual(names(files), tt.want) {
+				t.Errorf("files = %v, want %v", names(files), tt.want)
+			}
+		})
+	}
+}
+
+func TestReadZipFileTooLarge(t *testing.T) {
+	r := zipOf(t, "big.md", strings.Repeat("x", MaxFileSize+1))
+	if _, err := ReadZip(r, r.Size()); err == nil || !strings.Contains(err.Error(), "file too large") {
+		t.Errorf("err = %v, want file too large", err)
+	}
+}
+
+func TestZipRoundTrip(t *testing.T) {
+	files := []File{{Name: "a.md", Data: []byte("a")}, {Name: "dir/b.md", Data: []byte("b")}}
+	var buf bytes.Buffer
+	if err := WriteZip(&buf, files); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadZip(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, files) {
+		t.Errorf("got %v, want %v", got, files)
+	}
+}
+
+func TestDirRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	files := []File{{Name: "a.md", Data: []byte("a")}, {Name: "post/index.md", Data: []byte("p")}}
+	if err := WriteDir(dir, append(files, File{Name: "_draft/c.md", Data: []byte("c")})); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, files) {
+		t.Errorf("got %v, want %v", got, files)
+	}
+}
+
+func TestSlugFromName(t *testing.T) {
+	tests := []struct{ name, want string }{
+		{"hello-world.md", "hello-world"},
+		{"posts/2024/hello.markdown", "hello"},
+		{"hello/index.md", "hello"},
+		{"hello/INDEX.md", "hello"},
+		{"index.md", "index"},
+	}
+	for _, tt := range tests {
+		if got := SlugFromName(tt.name); got != tt.want {
+			t.Errorf("SlugFromName(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,222 @@
+// Package mdport reads and writes posts as Markdown files with front matter,
+// the layout static site generators such as Hugo and Hexo use. Imports take
+// YAML (---) or TOML (+++) front matter; exports write YAML.
+package mdport
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+	"go.yaml.in/yaml/v3"
+)
+
+// Meta is the front matter of a post. Keys other generators use for the same
+// thing are accepted on import, see Parse.
+type Meta struct {
+	Title      string
+	Date       *time.Time
+	Slug       string
+	Draft      bool
+	Summary    string
+	Tags       []string
+	Categories []string
+	// public, unlisted or private; empty means public
+	Visibility string
+	Password   string
+}
+
+// Doc is a parsed Markdown file
+type Doc struct {
+	Meta Meta
+	Body string
+}
+
+var ErrNoFrontMatter = errors.New("missing front matter")
+
+// Parse splits data into front matter and body. Files without front matter
+// are rejected, they carry no title.
+func Parse(data []byte) (Doc, error) {
+	text := strings.TrimPrefix(string(data), "\uFEFF")
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	var fence string
+	switch {
+	case strings.HasPrefix(text, "---\n"):
+		fence = "---"
+	case strings.HasPrefix(text, "+++\n"):
+		fence = "+++"
+	default:
+		return Doc{}, ErrNoFrontMatter
+	}
+	lines := strings.SplitAfter(text, "\n")
+	end := -1
+	for i := 1; i < len(lines); i++ {
+		if strings.TrimRight(lines[i], " \n") == fence {
+			end = i
+			break
+		}
+	}
+	if end < 0 {
+		return Doc{}, fmt.Errorf("front matter is not closed by %s", fence)
+	}
+	head := strings.Join(lines[1:end], "")
+	body := strings.Join(lines[end+1:], "")
+
+	raw := map[string]interface{}{}
+	var err error
+	if fence == "---" {
+		err = yaml.Unmarshal([]byte(head), &raw)
+	} else {
+		err = toml.Unmarshal([]byte(head), &raw)
+	}
+	if err != nil {
+		return Doc{}, fmt.Errorf("front matter: %w", err)
+	}
+	meta, err := metaFrom(raw)
+	if err != nil {
+		return Doc{}, err
+	}
+	// Format pads the body with newlines, trimming them keeps round trips exact
+	return Doc{Meta: meta, Body: strings.Trim(body, "\n")}, nil
+}
+
+func metaFrom(raw map[string]interface{}) (Meta, error) {
+	var m Meta
+	m.Title = str(raw["title"])
+	m.Slug = str(raw["slug"])
+	m.Summary = str(first(raw, "summary", "description", "excerpt"))
+	m.Visibility = str(raw["visibility"])
+	m.Password = str(raw["password"])
+	switch v := raw["draft"].(type) {
+	case bool:
+		m.Draft = v
+	case string:
+		m.Draft = v == "true"
+	}
+	// hexo marks drafts by leaving them unpublished
+	if v, ok := raw["published"].(bool); ok && !v {
+		m.Draft = true
+	}
+	if v := first(raw, "date", "publishDate"); v != nil {
+		t, err := date(v)
+		if err != nil {
+			return m, err
+		}
+		m.Date = &t
+	}
+	m.Tags = list(raw["tags"])
+	m.Categories = list(raw["categories"])
+	return m, nil
+}
+
+func first(raw map[string]interface{}, keys ...string) interface{} {
+	for _, k := range keys {
+		if v, ok := raw[k]; ok && v != nil {
+			return v
+		}
+	}
+	return nil
+}
+
+func str(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return strings.TrimSpace(v)
+	default:
+		return strings.TrimSpace(fmt.Sprint(v))
+	}
+}
+
+// list flattens a name or a list of names. Hexo nests lists for category
+// hierarchies, every level becomes a category of its own.
+func list(v interface{}) []string {
+	var out []string
+	var walk func(v interface{})
+	walk = func(v interface{}) {
+		switch v := v.(type) {
+		case []interface{}:
+			for _, e := range v {
+				walk(e)
+			}
+		case nil:
+		default:
+			if s := str(v); s != "" {
+				out = append(out, s)
+			}
+		}
+	}
+	walk(v)
+	return out
+}
+
+var dateLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+func date(v interface{}) (time.Time, error) {
+	switch v := v.(type) {
+	case time.Time:
+		return v, nil
+	case toml.LocalDateTime:
+		return v.AsTime(time.Local), nil
+	case toml.LocalDate:
+		return v.AsTime(time.Local), nil
+	case string:
+		for _, layout := range dateLayouts {
+			if t, err := time.ParseInLocation(layout, strings.TrimSpace(v), time.Local); err == nil {
+				return t, nil
+			}
+		}
+	}
+	return time.Time{}, fmt.Errorf("unsupported date %v", v)
+}
+
+// exportMeta fixes the key order of written front matter
+type exportMeta struct {
+	Title      string    `yaml:"title"`
+	Date       time.Time `yaml:"date"`
+	Slug       string    `yaml:"slug"`
+	Draft      bool      `yaml:"draft,omitempty"`
+	Summary    string    `yaml:"summary,omitempty"`
+	Tags       []string  `yaml:"tags,omitempty"`
+	Categories []string  `yaml:"categories,omitempty"`
+	Visibility string    `yaml:"visibility,omitempty"`
+}
+
+// Format writes doc with YAML front matter
+func Format(doc Doc) ([]byte, error) {
+	m := exportMeta{
+		Title:      doc.Meta.Title,
+		Slug:       doc.Meta.Slug,
+		Draft:      doc.Meta.Draft,
+		Summary:    doc.Meta.Summary,
+		Tags:       doc.Meta.Tags,
+		Categories: doc.Meta.Categories,
+		Visibility: doc.Meta.Visibility,
+	}
+	if doc.Meta.Date != nil {
+		m.Date = *doc.Meta.Date
+	}
+	head, err := yaml.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	buf.WriteString("---\n")
+	buf.Write(head)
+	buf.WriteString("---\n\n")
+	buf.WriteString(doc.Body)
+	if !strings.HasSuffix(doc.Body, "\n") {
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,81 @@
+package mdport
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	date := time.Date(2024, 5, 1, 10, 0, 0, 0, time.FixedZone("", 2*3600))
+	local := time.Date(2024, 5, 1, 0, 0, 0, 0, time.Local)
+	tests := []struct {
+		name    string
+		in      string
+		want    Doc
+		wantErr bool
+	}{
+		{"yaml", "---\ntitle: Hello\ndate: 2024-05-01T10:00:00+02:00\nslug: hi\ntags: [a, b]\ncategories: c\nsummary: s\n---\n\nBody\n",
+			Doc{Meta: Meta{Title: "Hello", Date: &date, Slug: "hi", Tags: []string{"a", "b"}, Categories: []string{"c"}, Summary: "s"}, Body: "Body"}, false},
+		{"toml", "+++\ntitle = \"Hello\"\ndate = 2024-05-01\ndraft = true\ndescription = \"d\"\n+++\nBody",
+			Doc{Meta: Meta{Title: "Hello", Date: &local, Draft: true, Summary: "d"}, Body: "Body"}, false},
+		{"hexo", "---\ntitle: Hello\ndate: 2024-05-01 00:00\npublished: false\ncategories:\n  - [a, b]\n  - c\nexcerpt: e\n---\nBody",
+			Doc{Meta: Meta{Title: "Hello", Date: &local, Draft: true, Categories: []string{"a", "b", "c"}, Summary: "e"}, Body: "Body"}, false},
+		{"bom and crlf", "\uFEFF---\r\ntitle: Hello\r\nvisibility: unlisted\r\n---\r\nBody\r\n",
+			Doc{Meta: Meta{Title: "Hello", Visibility: "unlisted"}, Body: "Body"}, false},
+		{"no front matter", "# Hello\n", Doc{}, true},
+		{"not closed", "---\ntitle: Hello\n", Doc{}, true},
+		{"bad date", "---\ntitle: Hello\ndate: yesterday\n---\n", Doc{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse([]byte(tt.in))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !equalDocs(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+	if _, err := Parse([]byte("text")); !errors.Is(err, ErrNoFrontMatter) {
+		t.Errorf("err = %v, want ErrNoFrontMatter", err)
+	}
+}
+
+// equalDocs compares dates by instant
+func equalDocs(a, b Doc) bool {
+	if (a.Meta.Date == nil) != (b.Meta.Date == nil) ||
+		a.Meta.Date != nil && !a.Meta.Date.Equal(*b.Meta.Date) {
+		return false
+	}
+	a.Meta.Date, b.Meta.Date = nil, nil
+	return reflect.DeepEqual(a, b)
+}
+
+func TestFormatRoundTrip(t *testing.T) {
+	date := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+	docs := []Doc{
+		{Meta: Meta{Title: "Hello", Date: &date, Slug: "hello", Tags: []string{"a", "b"}, Categories: []string{"c"}}, Body: "# Hello\n\ntext"},
+		{Meta: Meta{Title: "Draft: with a colon", Date: &date, Slug: "draft", Draft: true, Summary: "s", Visibility: "private"}, Body: "---\nnot front matter"},
+	}
+	for _, doc := range docs {
+		t.Run(doc.Meta.Slug, func(t *testing.T) {
+			data, err := Format(doc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := Parse(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !equalDocs(got, doc) {
+				t.Errorf("got %+v, want %+v", got, doc)
+			}
+		})
+	}
+}
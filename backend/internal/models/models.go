@@ -1,14 +1,21 @@
 package models
 
 import (
+	"fmt"
+	"time"
+	"unicode/utf8"
+
 	"gorm.io/gorm"
 )
 
 type UserRole string
 
 const (
-	RoleUser  UserRole = "user"
-	RoleAdmin UserRole = "admin"
+	RoleReader      UserRole = "reader"
+	RoleContributor UserRole = "contributor"
+	RoleAuthor      UserRole = "author"
+	RoleEditor      UserRole = "editor"
+	RoleAdmin       UserRole = "admin"
 )
 
 type User struct {
@@ -17,18 +24,71 @@ type User struct {
 	Email    string   `gorm:"uniqueIndex;size:128" json:"email"`
 	Password string   `json:"-"`
 	Avatar   string   `json:"avatar"`
-	Role     UserRole `gorm:"size:16;default:user" json:"role"`
+	Bio      string   `gorm:"size:500" json:"bio"`
+	Role     UserRole `gorm:"size:16;default:reader" json:"role"`
+	Disabled bool     `gorm:"default:false" json:"disabled"`
+	// waiting for an admin to approve the registration
+	Pending       bool `gorm:"default:false" json:"pending"`
+	EmailVerified bool `gorm:"default:false" json:"email_verified"`
+	// a new address waiting for verification, Email stays in use until the
+	// link sent to it is followed
+	PendingEmail string `gorm:"size:191" json:"-"`
+	// TOTP two-factor authentication, secret is set during enrollment
+	TOTPSecret   string `gorm:"size:64" json:"-"`
+	TOTPEnabled  bool   `gorm:"default:false" json:"totp_enabled"`
+	TOTPLastStep int64  `json:"-"`
+	// set when an admin assigned a temporary password
+	MustChangePassword bool `gorm:"default:false" json:"must_change_password"`
+}
+
+// ReleasedName is what a unique column of a deleted user is renamed to, so
+// the username and email can be taken again; the id keeps it unique and the
+// original stays readable for audits. size is the column size.
+func ReleasedName(id uint, name string, size int) string {
+	s := fmt.Sprintf("deleted-%d-%s", id, name)
+	for len(s) > size {
+		_, n := utf8.DecodeLastRuneInString(s)
+		s = s[:len(s)-n]
+	}
+	return s
+}
+
+// Author is the public part of a user, the only one embedded in posts so
+// email, role and account state never leak through public endpoints
+type Author struct {
+	ID       uint   `json:"id"`
+	Username string `json:"username"`
+	Avatar   string `json:"avatar"`
+	Bio      string `json:"bio"`
+}
+
+func (Author) TableName() string { return "users" }
+
+// Invite lets someone register while registration is invite only or
+// moderated. Only the hash of the code is stored.
+type Invite struct {
+	gorm.Model
+	CodeHash  string     `gorm:"uniqueIndex;size:64" json:"-"`
+	Prefix    string     `gorm:"size:16" json:"prefix"` // shown to tell codes apart
+	Note      string     `gorm:"size:255" json:"note"`
+	Role      UserRole   `gorm:"size:16" json:"role"` // empty keeps the default role
+	MaxUses   int        `json:"max_uses"`            // 0 is unlimited
+	Uses      int        `json:"uses"`
+	ExpiresAt *time.Time `json:"expires_at"`
+	CreatedBy uint       `json:"created_by"`
 }
 
 type Category struct {
 	gorm.Model
 	Name        string `gorm:"uniqueIndex;size:64" json:"name"`
+	Slug        string `gorm:"uniqueIndex;size:191" json:"slug"`
 	Description string `gorm:"size:255" json:"description"`
 }
 
 type Tag struct {
 	gorm.Model
 	Name string `gorm:"uniqueIndex;size:64" json:"name"`
+	Slug string `gorm:"uniqueIndex;size:191" json:"slug"`
 }
 
 type PostStatus string
@@ -36,20 +96,145 @@ type PostStatus string
 const (
 	PostDraft     PostStatus = "draft"
 	PostPublished PostStatus = "published"
+	// waits for PublishAt, see internal/scheduler
+	PostScheduled PostStatus = "scheduled"
+)
+
+// PostVisibility limits who may read a published post, see internal/postaccess
+type PostVisibility string
+
+const (
+	VisibilityPublic PostVisibility = "public"
+	// readable through its link but left out of every listing
+	VisibilityUnlisted PostVisibility = "unlisted"
+	// only for signed in team members
+	VisibilityPrivate PostVisibility = "private"
+	// readable after unlocking it with the post's password
+	VisibilityPassword PostVisibility = "password"
 )
 
 type Post struct {
 	gorm.Model
 	Title      string     `gorm:"size:200" json:"title"`
+	Slug       string     `gorm:"uniqueIndex;size:191" json:"slug"`
 	Content    string     `gorm:"type:text" json:"content"`
 	Summary    string     `gorm:"size:500" json:"summary"`
 	CoverImage string     `json:"cover_image"`
 	AuthorID   uint       `json:"author_id"`
-	Author     *User      `gorm:"foreignKey:AuthorID" json:"author,omitempty"`
-	Status     PostStatus `gorm:"size:16;default:draft" json:"status"`
-	ViewCount  uint       `json:"view_count"`
-	Categories []Category `gorm:"many2many:post_categories;" json:"categories,omitempty"`
-	Tags       []Tag      `gorm:"many2many:post_tags;" json:"tags,omitempty"`
+	Author     *Author    `gorm:"foreignKey:AuthorID" json:"author,omitempty"`
+	Status     PostStatus `gorm:"size:16;default:draft;index" json:"status"`
+	// Visibility limits the readers of a published post, PasswordHash guards password posts
+	Visibility   PostVisibility `gorm:"size:16;default:public;index" json:"visibility"`
+	PasswordHash string         `json:"-"`
+	// PublishAt is set while scheduled, UnpublishAt takes a published post back to draft
+	PublishAt   *time.Time `gorm:"index" json:"publish_at"`
+	UnpublishAt *time.Time `gorm:"index" json:"unpublish_at"`
+	PublishedAt *time.Time `json:"published_at"`
+	ViewCount   uint       `json:"view_count"`
+	// pinned posts lead the post list, PinnedUntil unpins automatically
+	Pinned      bool       `gorm:"default:false;index" json:"pinned"`
+	PinnedUntil *time.Time `gorm:"index" json:"pinned_until"`
+	Featured    bool       `gorm:"default:false;index" json:"featured"`
+	FeaturedAt  *time.Time `json:"featured_at"`
+	// computed from Content on every save, SummaryAuto marks a generated summary
+	SummaryAuto    bool       `gorm:"default:false" json:"summary_auto"`
+	WordCount      int        `json:"word_count"`
+	ReadingMinutes int        `json:"reading_minutes"`
+	TOC            []TOCEntry `gorm:"serializer:json;type:text" json:"toc"`
+	Categories     []Category `gorm:"many2many:post_categories;" json:"categories,omitempty"`
+	Tags           []Tag      `gorm:"many2many:post_tags;" json:"tags,omitempty"`
+	// ContentHTML is the rendered Content, filled on single post reads
+	ContentHTML string `gorm:"-" json:"content_html,omitempty"`
+	// Series places the post among the other parts of its series, filled on single post reads
+	Series *SeriesNav `gorm:"-" json:"series,omitempty"`
+}
+
+// TOCEntry is a heading of a post, ID is its anchor in the rendered html
+type TOCEntry struct {
+	Level int    `json:"level"`
+	Text  string `json:"text"`
+	ID    string `json:"id"`
+}
+
+// Series groups posts published as ordered parts, like a multi-part tutorial
+type Series struct {
+	gorm.Model
+	Title       string `gorm:"size:200" json:"title"`
+	Slug        string `gorm:"uniqueIndex;size:191" json:"slug"`
+	Description string `gorm:"size:1000" json:"description"`
+	AuthorID    uint   `json:"author_id"`
+}
+
+// SeriesPart puts a post into a series, a post belongs to one series at most
+type SeriesPart struct {
+	ID       uint `gorm:"primarykey" json:"-"`
+	SeriesID uint `gorm:"index:idx_series_position" json:"series_id"`
+	PostID   uint `gorm:"uniqueIndex" json:"post_id"`
+	Position int  `gorm:"index:idx_series_position" json:"position"` // counts up from 1
+}
+
+// SeriesNav is where a post sits in its series
+type SeriesNav struct {
+	ID    uint      `json:"id"`
+	Title string    `json:"title"`
+	Slug  string    `json:"slug"`
+	Part  int       `json:"part"`
+	Parts int       `json:"parts"`
+	Prev  *PostLink `json:"prev"`
+	Next  *PostLink `json:"next"`
+}
+
+type PostLink struct {
+	ID    uint   `json:"id"`
+	Title string `json:"title"`
+	Slug  string `json:"slug"`
+}
+
+// RelatedPosts caches the posts recommended after a post, best first
+type RelatedPosts struct {
+	PostID     uint           `gorm:"primaryKey;autoIncrement:false" json:"post_id"`
+	Entries    []RelatedEntry `gorm:"serializer:json;type:text" json:"entries"`
+	ComputedAt time.Time      `json:"computed_at"`
+}
+
+type RelatedEntry struct {
+	PostID uint    `json:"post_id"`
+	Score  float64 `json:"score"`
+}
+
+// RelatedLink records that the cached suggestions of PostID include
+// RelatedID, so a change to RelatedID only drops the caches naming it
+type RelatedLink struct {
+	PostID    uint `gorm:"primaryKey;autoIncrement:false"`
+	RelatedID uint `gorm:"primaryKey;autoIncrement:false;index"`
+}
+
+// RelatedPosting counts a term in the text of a published post
+type RelatedPosting struct {
+	Term   string  `gorm:"primaryKey;size:191"`
+	PostID uint    `gorm:"primaryKey;autoIncrement:false;index"`
+	Freq   float64 `gorm:"not null"`
+}
+
+// RelatedTerm is the number of published posts whose text has Term, kept
+// up to date with the postings
+type RelatedTerm struct {
+	Term string `gorm:"primaryKey;size:191"`
+	Docs int64  `gorm:"not null"`
+}
+
+// PostRevision is a snapshot of a post saved on every edit
+type PostRevision struct {
+	ID            uint      `gorm:"primarykey" json:"id"`
+	PostID        uint      `gorm:"uniqueIndex:idx_post_revision" json:"post_id"`
+	Number        int       `gorm:"uniqueIndex:idx_post_revision" json:"number"` // counts up per post
+	EditorID      uint      `json:"editor_id"`
+	Title         string    `gorm:"size:200" json:"title"`
+	Summary       string    `gorm:"size:500" json:"summary"`
+	Content       string    `gorm:"type:text" json:"content,omitempty"`
+	ContentHTML   string    `gorm:"type:text" json:"-"` // Content rendered by render.Post
+	RenderVersion int       `json:"-"`                  // render.Version of ContentHTML
+	CreatedAt     time.Time `json:"created_at"`
 }
 
 type Comment struct {
@@ -57,6 +242,8 @@ type Comment struct {
 	PostID  uint   `json:"post_id"`
 	UserID  uint   `json:"user_id"`
 	Content string `gorm:"type:text" json:"content"`
+	// ContentHTML is the rendered Content, never stored
+	ContentHTML string `gorm:"-" json:"content_html"`
 }
 
 type FriendsLink struct {
@@ -66,3 +253,12 @@ type FriendsLink struct {
 	Description string `gorm:"size:255" json:"description"`
 	Link        string `gorm:"size:255" json:"link"`
 }
+
+// SlugRedirect points an old slug at the record that used it
+type SlugRedirect struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	Kind      string    `gorm:"size:16;uniqueIndex:idx_slug_redirect" json:"kind"`
+	OldSlug   string    `gorm:"size:191;uniqueIndex:idx_slug_redirect" json:"old_slug"`
+	TargetID  uint      `gorm:"index" json:"target_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
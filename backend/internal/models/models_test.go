@@ -0,0 +1,30 @@
+package models
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestReleasedName(t *testing.T) {
+	tests := []struct {
+		id   uint
+		name string
+		size int
+		want string
+	}{
+		{7, "bob", 64, "deleted-7-bob"},
+		{7, "bob@example.com", 128, "deleted-7-bob@example.com"},
+		{12, strings.Repeat("a", 64), 64, "deleted-12-" + strings.Repeat("a", 53)},
+		{3, "日本語", 14, "deleted-3-日"},
+	}
+	for _, tt := range tests {
+		got := ReleasedName(tt.id, tt.name, tt.size)
+		if got != tt.want {
+			t.Errorf("ReleasedName(%d, %q, %d) = %q, want %q", tt.id, tt.name, tt.size, got, tt.want)
+		}
+		if len(got) > tt.size || !utf8.ValidString(got) {
+			t.Errorf("ReleasedName(%d, %q, %d) = %q does not fit", tt.id, tt.name, tt.size, got)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Session groups a family of rotating refresh tokens issued from one login
+type Session struct {
+	gorm.Model
+	UserID    uint       `gorm:"index" json:"user_id"`
+	UserAgent string     `gorm:"size:255" json:"user_agent"`
+	IP        string     `gorm:"size:64" json:"ip"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+type RefreshToken struct {
+	gorm.Model
+	SessionID uint       `gorm:"index"`
+	TokenHash string     `gorm:"uniqueIndex;size:64"`
+	ExpiresAt time.Time  `gorm:"index"`
+	UsedAt    *time.Time `gorm:"index"`
+}
+
+// UsedToken records consumed single-use action tokens
+type UsedToken struct {
+	ID        uint      `gorm:"primarykey"`
+	JTI       string    `gorm:"uniqueIndex;size:64"`
+	ExpiresAt time.Time `gorm:"index"`
+}
+
+// RecoveryCode is a single-use fallback for a lost TOTP device
+type RecoveryCode struct {
+	gorm.Model
+	UserID   uint       `gorm:"index"`
+	CodeHash string     `gorm:"size:64"`
+	UsedAt   *time.Time `json:"used_at,omitempty"`
+}
+
+// AccessToken is a personal access token for automation, only its hash is stored
+type AccessToken struct {
+	gorm.Model
+	UserID     uint       `gorm:"index" json:"user_id"`
+	Name       string     `gorm:"size:64" json:"name"`
+	Prefix     string     `gorm:"size:16" json:"prefix"` // shown to tell tokens apart
+	TokenHash  string     `gorm:"uniqueIndex;size:64" json:"-"`
+	Scopes     string     `gorm:"size:1024" json:"-"` // comma separated permissions
+	LastUsedAt *time.Time `json:"last_used_at"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+}
+
+// LoginAttempt tracks consecutive failed logins per subject (email or ip)
+type LoginAttempt struct {
+	ID            uint      `gorm:"primarykey" json:"id"`
+	Subject       string    `gorm:"uniqueIndex;size:191" json:"subject"`
+	Failures      int       `json:"failures"`
+	LastFailureAt time.Time `json:"last_failure_at"`
+}
+
+// UserIdentity links a user to an account at an external OIDC provider
+type UserIdentity struct {
+	gorm.Model
+	UserID   uint   `gorm:"index" json:"user_id"`
+	Provider string `gorm:"size:64;uniqueIndex:idx_identity_provider_subject" json:"provider"`
+	Subject  string `gorm:"size:191;uniqueIndex:idx_identity_provider_subject" json:"subject"`
+	Email    string `gorm:"size:128" json:"email"`
+}
+
+// OIDCState holds the pending authorization request between redirect and callback
+type OIDCState struct {
+	ID        uint      `gorm:"primarykey"`
+	State     string    `gorm:"uniqueIndex;size:64"`
+	Provider  string    `gorm:"size:64"`
+	Nonce     string    `gorm:"size:64"`
+	Verifier  string    `gorm:"size:128"`
+	ExpiresAt time.Time `gorm:"index"`
+}
+
+// SigningKey is a generated token signing key, kept for verification after rotation
+type SigningKey struct {
+	ID         uint       `gorm:"primarykey" json:"id"`
+	Kid        string     `gorm:"uniqueIndex;size:64" json:"kid"`
+	Algorithm  string     `gorm:"size:16" json:"algorithm"`
+	PrivateKey string     `gorm:"type:text" json:"-"` // PKCS#8 PEM
+	CreatedAt  time.Time  `json:"created_at"`
+	RetiredAt  *time.Time `json:"retired_at"`
+}
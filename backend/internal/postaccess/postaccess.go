@@ -0,0 +1,151 @@
+// Package postaccess decides who may read a post. Unpublished posts are
+// only visible to whoever may edit them; published posts are further
+// limited by their visibility:
+//
+//   - public posts are readable and listed everywhere
+//   - unlisted posts are readable through their link but never listed
+//   - private posts are for team members holding posts:read_private
+//   - password posts are listed, but reading them takes an unlock token
+//     issued by the post's unlock endpoint
+//
+// Editors of a post always get through.
+package postaccess
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"easyblog/internal/actiontoken"
+	"easyblog/internal/keys"
+	"easyblog/internal/models"
+	"easyblog/internal/rbac"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/gorm"
+)
+
+// UnlockHeader carries an unlock token for clients that do not keep cookies
+const UnlockHeader = "X-Post-Unlock"
+
+var (
+	// ErrHidden is reported as a plain 404 so hidden posts do not leak
+	ErrHidden = errors.New("not found")
+	ErrLocked = errors.New("password required")
+)
+
+// CanEdit reports whether the current user owns post or may edit any post
+func CanEdit(c *gin.Context, post models.Post) bool {
+	userVal, exists := c.Get("user")
+	if !exists {
+		return false
+	}
+	return userVal.(models.User).ID == post.AuthorID || rbac.Allowed(c, rbac.PostsUpdateAny)
+}
+
+// Check returns nil when the current request may read post, ErrHidden or
+// ErrLocked otherwise
+func Check(c *gin.Context, ks *keys.KeySet, post models.Post) error {
+	if CanEdit(c, post) {
+		return nil
+	}
+	if post.Status != models.PostPublished {
+		return ErrHidden
+	}
+	switch post.Visibility {
+	case models.VisibilityPrivate:
+		if !rbac.Allowed(c, rbac.PostsReadPrivate) {
+			return ErrHidden
+		}
+	case models.VisibilityPassword:
+		if !Unlocked(c, ks, post) {
+			return ErrLocked
+		}
+	}
+	return nil
+}
+
+// WriteError answers a failed Check. A locked post names itself so clients
+// can ask for its password.
+func WriteError(c *gin.Context, post models.Post, err error) {
+	if errors.Is(err, ErrLocked) {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error":      err.Error(),
+			"id":         post.ID,
+			"title":      post.Title,
+			"slug":       post.Slug,
+			"visibility": post.Visibility,
+		})
+		return
+	}
+	c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+}
+
+// Listed narrows a post query to the visibilities that may appear in
+// listings for the current request: never unlisted posts, private ones only
+// for team members
+func Listed(c *gin.Context) func(*gorm.DB) *gorm.DB {
+	shown := ListedVisibilities(c)
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("posts.visibility IN ?", shown)
+	}
+}
+
+// ListedVisibilities are the visibilities Listed lets through
+func ListedVisibilities(c *gin.Context) []models.PostVisibility {
+	shown := []models.PostVisibility{models.VisibilityPublic, models.VisibilityPassword}
+	if rbac.Allowed(c, rbac.PostsReadPrivate) {
+		shown = append(shown, models.VisibilityPrivate)
+	}
+	return shown
+}
+
+// Listable is Listed for a loaded post
+func Listable(c *gin.Context, post models.Post) bool {
+	switch post.Visibility {
+	case models.VisibilityUnlisted:
+		return false
+	case models.VisibilityPrivate:
+		return rbac.Allowed(c, rbac.PostsReadPrivate)
+	}
+	return true
+}
+
+// Unlock issues a token that opens post until the token expires or the
+// post's password changes
+func Unlock(ks *keys.KeySet, post models.Post, ttl time.Duration) (string, error) {
+	return actiontoken.Issue(ks, actiontoken.PurposePostUnlock, actiontoken.Claims{
+		Fingerprint:      actiontoken.Fingerprint(post.PasswordHash),
+		RegisteredClaims: jwt.RegisteredClaims{Subject: strconv.FormatUint(uint64(post.ID), 10)},
+	}, ttl)
+}
+
+// CookieName is the cookie holding the unlock token of one post
+func CookieName(id uint) string {
+	return "post_unlock_" + strconv.FormatUint(uint64(id), 10)
+}
+
+// Unlocked reports whether the request carries a valid unlock token for
+// post, in its cookie or the X-Post-Unlock header
+func Unlocked(c *gin.Context, ks *keys.KeySet, post models.Post) bool {
+	tokens := []string{c.GetHeader(UnlockHeader)}
+	if v, err := c.Cookie(CookieName(post.ID)); err == nil {
+		tokens = append(tokens, v)
+	}
+	for _, token := range tokens {
+		if token == "" {
+			continue
+		}
+		claims, err := actiontoken.Parse(ks, actiontoken.PurposePostUnlock, token)
+		if err != nil {
+			continue
+		}
+		if claims.Subject == strconv.FormatUint(uint64(post.ID), 10) &&
+			claims.Fingerprint == actiontoken.Fingerprint(post.PasswordHash) {
+			return true
+		}
+	}
+	return false
+}
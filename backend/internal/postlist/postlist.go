@@ -0,0 +1,217 @@
+// Package postlist builds the compact post entries returned by every post
+// listing. Listings leave out the content and anything private about the
+// author, and ?fields= trims entries further.
+package postlist
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"easyblog/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Ref names a category or tag of a listed post
+type Ref struct {
+	ID   uint   `json:"id"`
+	Name string `json:"name"`
+	Slug string `json:"slug"`
+}
+
+type Item struct {
+	ID             uint                  `json:"id"`
+	Title          string                `json:"title"`
+	Slug           string                `json:"slug"`
+	Summary        string                `json:"summary"`
+	CoverImage     string                `json:"cover_image"`
+	Status         models.PostStatus     `json:"status"`
+	Visibility     models.PostVisibility `json:"visibility"`
+	Author         *models.Author        `json:"author"`
+	Categories     []Ref                 `json:"categories"`
+	Tags           []Ref                 `json:"tags"`
+	Pinned         bool                  `json:"pinned"`
+	Featured       bool                  `json:"featured"`
+	ViewCount      uint                  `json:"view_count"`
+	CommentCount   int64                 `json:"comment_count"`
+	WordCount      int                   `json:"word_count"`
+	ReadingMinutes int                   `json:"reading_minutes"`
+	PublishedAt    *time.Time            `json:"published_at"`
+	CreatedAt      time.Time             `json:"created_at"`
+	UpdatedAt      time.Time             `json:"updated_at"`
+}
+
+// Fields is the set of requested entry fields besides id, which is always sent
+type Fields map[string]bool
+
+var allFields = []string{
+	"title", "slug", "summary", "cover_image", "status", "visibility", "author", "categories", "tags",
+	"pinned", "featured", "view_count", "comment_count", "word_count", "reading_minutes", "published_at", "created_at", "updated_at",
+}
+
+// ParseFields reads ?fields=title,slug,...; without it every field is sent.
+// Unknown names are rejected so typos do not silently return less.
+func ParseFields(c *gin.Context) (Fields, bool) {
+	f := Fields{}
+	v := c.Query("fields")
+	if v == "" {
+		for _, name := range allFields {
+			f[name] = true
+		}
+		return f, true
+	}
+	known := map[string]bool{"id": true}
+	for _, name := range allFields {
+		known[name] = true
+	}
+	for _, name := range strings.Split(v, ",") {
+		name = strings.TrimSpace(name)
+		if !known[name] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "unknown field " + name})
+			return nil, false
+		}
+		if name != "id" {
+			f[name] = true
+		}
+	}
+	return f, true
+}
+
+// columns of posts needed for fields
+func (f Fields) columns() []string {
+	cols := []string{"posts.id"}
+	for _, name := range []string{"title", "slug", "summary", "cover_image", "status", "visibility", "pinned", "featured",
+		"view_count", "word_count", "reading_minutes", "published_at", "created_at", "updated_at"} {
+		if f[name] {
+			cols = append(cols, "posts."+name)
+		}
+	}
+	if f["summary"] && !f["visibility"] {
+		// summaries of password posts are withheld
+		cols = append(cols, "posts.visibility")
+	}
+	if f["author"] {
+		cols = append(cols, "posts.author_id")
+	}
+	return cols
+}
+
+// Find runs q, a query on posts with filters, order and paging applied, and
+// loads only what fields needs
+func Find(q *gorm.DB, fields Fields) ([]Item, error) {
+	q = q.Select(fields.columns())
+	if fields["author"] {
+		q = q.Preload("Author")
+	}
+	if fields["categories"] {
+		q = q.Preload("Categories")
+	}
+	if fields["tags"] {
+		q = q.Preload("Tags")
+	}
+	var posts []models.Post
+	if err := q.Find(&posts).Error; err != nil {
+		return nil, err
+	}
+	return Items(q.Session(&gorm.Session{NewDB: true}), posts, fields)
+}
+
+// Items converts loaded posts, counting comments when asked for
+func Items(db *gorm.DB, posts []models.Post, fields Fields) ([]Item, error) {
+	var counts map[uint]int64
+	if fields["comment_count"] && len(posts) > 0 {
+		ids := make([]uint, len(posts))
+		for i, p := range posts {
+			ids[i] = p.ID
+		}
+		var rows []struct {
+			PostID uint
+			Count  int64
+		}
+		err := db.Model(&models.Comment{}).Select("post_id, COUNT(*) AS count").
+			Where("post_id IN ?", ids).Group("post_id").Scan(&rows).Error
+		if err != nil {
+			return nil, err
+		}
+		counts = make(map[uint]int64, len(rows))
+		for _, r := range rows {
+			counts[r.PostID] = r.Count
+		}
+	}
+	items := make([]Item, len(posts))
+	for i, p := range posts {
+		items[i] = item(p, fields, counts)
+	}
+	return items, nil
+}
+
+func item(p models.Post, f Fields, comments map[uint]int64) Item {
+	it := Item{
+		ID:             p.ID,
+		Title:          p.Title,
+		Slug:           p.Slug,
+		Summary:        p.Summary,
+		CoverImage:     p.CoverImage,
+		Status:         p.Status,
+		Visibility:     p.Visibility,
+		Author:         p.Author,
+		Categories:     make([]Ref, len(p.Categories)),
+		Tags:           make([]Ref, len(p.Tags)),
+		Pinned:         p.Pinned,
+		Featured:       p.Featured,
+		ViewCount:      p.ViewCount,
+		WordCount:      p.WordCount,
+		ReadingMinutes: p.ReadingMinutes,
+		PublishedAt:    p.PublishedAt,
+		CreatedAt:      p.CreatedAt,
+		UpdatedAt:      p.UpdatedAt,
+	}
+	for i, c := range p.Categories {
+		it.Categories[i] = Ref{ID: c.ID, Name: c.Name, Slug: c.Slug}
+	}
+	for i, t := range p.Tags {
+		it.Tags[i] = Ref{ID: t.ID, Name: t.Name, Slug: t.Slug}
+	}
+	if p.Visibility == models.VisibilityPassword {
+		it.Summary = ""
+	}
+	it.CommentCount = comments[p.ID]
+	return it
+}
+
+// Write sends a page of entries
+func Write(c *gin.Context, total int64, items interface{}, fields Fields) {
+	out, err := fields.Apply(items)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"total": total, "items": out})
+}
+
+// Apply drops the fields not asked for from items. Entries may embed Item
+// next to extra keys such as search scores, only Item keys are dropped.
+func (f Fields) Apply(items interface{}) (interface{}, error) {
+	if len(f) == len(allFields) {
+		return items, nil
+	}
+	raw, err := json.Marshal(items)
+	if err != nil {
+		return nil, err
+	}
+	var rows []map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &rows); err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		for _, name := range allFields {
+			if !f[name] {
+				delete(row, name)
+			}
+		}
+	}
+	return rows, nil
+}
@@ -0,0 +1,115 @@
+package postlist
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"testing"
+
+	"easyblog/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestParseFields(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		name   string
+		query  string
+		ok     bool
+		fields []string
+	}{
+		{"all by default", "", true, allFields},
+		{"subset", "?fields=title,slug", true, []string{"slug", "title"}},
+		{"id is implied", "?fields=id,title", true, []string{"title"}},
+		{"spaces trimmed", "?fields=title,%20tags", true, []string{"tags", "title"}},
+		{"unknown rejected", "?fields=title,content", false, nil},
+		{"private author fields rejected", "?fields=author.email", false, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest(http.MethodGet, "/api/posts"+tt.query, nil)
+			f, ok := ParseFields(c)
+			if ok != tt.ok {
+				t.Fatalf("ok = %v, want %v", ok, tt.ok)
+			}
+			if !ok {
+				if w.Code != http.StatusBadRequest {
+					t.Errorf("status = %d, want 400", w.Code)
+				}
+				return
+			}
+			var got []string
+			for name := range f {
+				got = append(got, name)
+			}
+			sort.Strings(got)
+			want := append([]string(nil), tt.fields...)
+			sort.Strings(want)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("fields = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestColumns(t *testing.T) {
+	tests := []struct {
+		name   string
+		fields Fields
+		want   []string
+	}{
+		{"id only", Fields{}, []string{"posts.id"}},
+		{"plain columns", Fields{"title": true, "slug": true}, []string{"posts.id", "posts.title", "posts.slug"}},
+		{"summary needs visibility", Fields{"summary": true}, []string{"posts.id", "posts.summary", "posts.visibility"}},
+		{"author needs author id", Fields{"author": true}, []string{"posts.id", "posts.author_id"}},
+		{"relations are preloaded", Fields{"tags": true, "comment_count": true}, []string{"posts.id"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.fields.columns(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("columns = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApply(t *testing.T) {
+	type scored struct {
+		Item
+		Score float64 `json:"score"`
+	}
+	items := []scored{{Item: item(models.Post{Title: "t", Slug: "s", Summary: "x"}, nil, nil), Score: 2}}
+	items[0].ID = 7
+	tests := []struct {
+		name   string
+		fields Fields
+		keys   []string
+	}{
+		{"subset keeps id and extras", Fields{"title": true}, []string{"id", "score", "title"}},
+		{"none", Fields{}, []string{"id", "score"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := tt.fields.Apply(items)
+			if err != nil {
+				t.Fatal(err)
+			}
+			raw, _ := json.Marshal(out)
+			var rows []map[string]json.RawMessage
+			json.Unmarshal(raw, &rows)
+			var keys []string
+			for k := range rows[0] {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			if !reflect.DeepEqual(keys, tt.keys) {
+				t.Errorf("keys = %v, want %v", keys, tt.keys)
+			}
+		})
+	}
+}
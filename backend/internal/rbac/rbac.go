@@ -0,0 +1,103 @@
+package rbac
+
+import (
+	"easyblog/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+type Permission string
+
+const (
+	PostsCreate     Permission = "posts:create"
+	PostsUpdate     Permission = "posts:update" // own posts
+	PostsUpdateAny  Permission = "posts:update_any"
+	PostsDelete     Permission = "posts:delete" // own posts
+	PostsDeleteAny  Permission = "posts:delete_any"
+	PostsPublish    Permission = "posts:publish" // own posts
+	PostsPublishAny Permission = "posts:publish_any"
+	// read posts with private visibility
+	PostsReadPrivate Permission = "posts:read_private"
+
+	CategoriesManage Permission = "categories:manage"
+	TagsManage       Permission = "tags:manage"
+	FriendsManage    Permission = "friends:manage"
+
+	CommentsCreate    Permission = "comments:create"
+	CommentsDelete    Permission = "comments:delete" // own comments
+	CommentsDeleteAny Permission = "comments:delete_any"
+
+	ConfigManage Permission = "config:manage"
+	UsersManage  Permission = "users:manage"
+	RolesRead    Permission = "roles:read"
+)
+
+var (
+	readerPermissions = []Permission{
+		CommentsCreate, CommentsDelete,
+	}
+	contributorPermissions = extend(readerPermissions,
+		PostsCreate, PostsUpdate, PostsDelete, PostsReadPrivate,
+	)
+	authorPermissions = extend(contributorPermissions,
+		PostsPublish,
+	)
+	editorPermissions = extend(authorPermissions,
+		PostsUpdateAny, PostsDeleteAny, PostsPublishAny,
+		CategoriesManage, TagsManage, CommentsDeleteAny,
+	)
+	adminPermissions = extend(editorPermissions,
+		FriendsManage, ConfigManage, UsersManage, RolesRead,
+	)
+)
+
+// RolePermissions maps every role onto the permissions it grants
+var RolePermissions = map[models.UserRole][]Permission{
+	models.RoleReader:      readerPermissions,
+	models.RoleContributor: contributorPermissions,
+	models.RoleAuthor:      authorPermissions,
+	models.RoleEditor:      editorPermissions,
+	models.RoleAdmin:       adminPermissions,
+}
+
+func extend(base []Permission, extra ...Permission) []Permission {
+	out := make([]Permission, 0, len(base)+len(extra))
+	return append(append(out, base...), extra...)
+}
+
+// Can reports whether role grants perm
+func Can(role models.UserRole, perm Permission) bool {
+	for _, p := range RolePermissions[role] {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidRole reports whether role is a known role
+func ValidRole(role models.UserRole) bool {
+	_, ok := RolePermissions[role]
+	return ok
+}
+
+// Allowed checks perm for the user of the current request. Requests made
+// with a personal access token are further limited to the token scopes.
+func Allowed(c *gin.Context, perm Permission) bool {
+	userVal, exists := c.Get("user")
+	if !exists {
+		return false
+	}
+	if !Can(userVal.(models.User).Role, perm) {
+		return false
+	}
+	if scopes, ok := c.Get("token_scopes"); ok {
+		for _, p := range scopes.([]Permission) {
+			if p == perm {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
@@ -0,0 +1,196 @@
+// Package related suggests further reading for a post. Published posts are
+// scored by the tags and categories they share with it, rarer ones counting
+// more, and optionally by the TF-IDF cosine similarity of their text.
+//
+// Suggestions are cached in the database. When a post changes, Changed drops
+// the caches it may rank in; the rest expire after a while, as document
+// frequencies drift. Text is scored from postings kept in the database, so a
+// suggestion reads a bounded number of candidate posts instead of the corpus.
+package related
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"easyblog/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// MaxEntries is the number of suggestions kept per post
+const MaxEntries = 20
+
+// weights of the signals; a shared tag is more specific than a category
+const (
+	tagWeight      = 2.0
+	categoryWeight = 1.0
+	textWeight     = 3.0
+	// text similarity below this is noise such as common words
+	minSimilarity = 0.05
+)
+
+// Options controls how suggestions are computed and cached
+type Options struct {
+	TTL  time.Duration
+	Text bool
+}
+
+// Get returns the cached suggestions for postID, computing them when
+// missing or expired
+func Get(db *gorm.DB, postID uint, opts Options) ([]models.RelatedEntry, error) {
+	var cached models.RelatedPosts
+	if err := db.Where("post_id = ?", postID).Limit(1).Find(&cached).Error; err != nil {
+		return nil, err
+	}
+	if cached.PostID != 0 && time.Since(cached.ComputedAt) < opts.TTL {
+		return cached.Entries, nil
+	}
+	return Refresh(db, postID, opts)
+}
+
+// Refresh computes the suggestions for postID and stores them
+func Refresh(db *gorm.DB, postID uint, opts Options) ([]models.RelatedEntry, error) {
+	entries, err := Compute(db, postID, opts.Text)
+	if err != nil {
+		return nil, err
+	}
+	row := models.RelatedPosts{PostID: postID, Entries: entries, ComputedAt: time.Now()}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&row).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("post_id = ?", postID).Delete(&models.RelatedLink{}).Error; err != nil {
+			return err
+		}
+		if len(entries) == 0 {
+			return nil
+		}
+		links := make([]models.RelatedLink, len(entries))
+		for i, e := range entries {
+			links[i] = models.RelatedLink{PostID: postID, RelatedID: e.PostID}
+		}
+		return tx.Create(&links).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// Invalidate drops the cached suggestions of ids, they are computed again
+// on their next read
+func Invalidate(db *gorm.DB, ids ...uint) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("post_id IN ?", ids).Delete(&models.RelatedPosts{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("post_id IN ?", ids).Delete(&models.RelatedLink{}).Error
+	})
+}
+
+// Changed updates the postings of ids after they were edited, published,
+// unpublished or deleted, and drops the caches involving them: their own,
+// those suggesting them and, for published posts, those of the posts they
+// now suggest, which is where they rank too
+func Changed(db *gorm.DB, ids ...uint) error {
+	involved := map[uint]bool{}
+	for _, id := range ids {
+		post, err := reindex(db, id)
+		if err != nil {
+			return err
+		}
+		involved[id] = true
+		var linked []uint
+		if err := db.Model(&models.RelatedLink{}).Where("related_id = ?", id).Pluck("post_id", &linked).Error; err != nil {
+			return err
+		}
+		for _, l := range linked {
+			involved[l] = true
+		}
+		if post.Status != models.PostPublished {
+			continue
+		}
+		entries, err := Compute(db, id, true)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			involved[e.PostID] = true
+		}
+	}
+	list := make([]uint, 0, len(involved))
+	for id := range involved {
+		list = append(list, id)
+	}
+	return Invalidate(db, list...)
+}
+
+// Compute scores the published posts against postID, best first
+func Compute(db *gorm.DB, postID uint, text bool) ([]models.RelatedEntry, error) {
+	scores := map[uint]float64{}
+	if err := shared(db, "post_tags", "tag_id", postID, tagWeight, scores); err != nil {
+		return nil, err
+	}
+	if err := shared(db, "post_categories", "category_id", postID, categoryWeight, scores); err != nil {
+		return nil, err
+	}
+	if text {
+		if err := similar(db, postID, scores); err != nil {
+			return nil, err
+		}
+	}
+	entries := make([]models.RelatedEntry, 0, len(scores))
+	for id, score := range scores {
+		entries = append(entries, models.RelatedEntry{PostID: id, Score: math.Round(score*1000) / 1000})
+	}
+	// newer posts win ties
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Score != entries[j].Score {
+			return entries[i].Score > entries[j].Score
+		}
+		return entries[i].PostID > entries[j].PostID
+	})
+	if len(entries) > MaxEntries {
+		entries = entries[:MaxEntries]
+	}
+	return entries, nil
+}
+
+// shared adds weight times the inverse document frequency of every term in
+// join (a tag or category link table) that postID shares with another post
+func shared(db *gorm.DB, join, column string, postID uint, weight float64, scores map[uint]float64) error {
+	var own []uint
+	if err := db.Table(join).Where("post_id = ?", postID).Pluck(column, &own).Error; err != nil {
+		return err
+	}
+	if len(own) == 0 {
+		return nil
+	}
+	var total int64
+	if err := db.Model(&models.Post{}).Where("status = ?", models.PostPublished).Count(&total).Error; err != nil {
+		return err
+	}
+	var links []struct {
+		PostID uint
+		TermID uint
+	}
+	err := db.Table(join).Select(join+".post_id, "+join+"."+column+" AS term_id").
+		Joins("JOIN posts ON posts.id = "+join+".post_id AND posts.deleted_at IS NULL").
+		Where("posts.status = ? AND posts.id <> ?", models.PostPublished, postID).
+		Where(join+"."+column+" IN ?", own).Scan(&links).Error
+	if err != nil {
+		return err
+	}
+	df := map[uint]int{}
+	for _, l := range links {
+		df[l.TermID]++
+	}
+	for _, l := range links {
+		scores[l.PostID] += weight * math.Log(1+float64(total)/float64(df[l.TermID]))
+	}
+	return nil
+}
@@ -0,0 +1,211 @@
+package related
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"easyblog/internal/models"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func newTestDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, _ := db.DB()
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+	err = db.AutoMigrate(&models.Post{}, &models.Tag{}, &models.Category{}, &models.ConfigModel{},
+		&models.RelatedPosts{}, &models.RelatedLink{}, &models.RelatedPosting{}, &models.RelatedTerm{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// addPost creates a post and lets the related posts know
+func addPost(t *testing.T, db *gorm.DB, status models.PostStatus, title, content string, tags ...models.Tag) models.Post {
+	post := models.Post{Title: title, Slug: fmt.Sprint("p", time.Now().UnixNano()), Content: content, Status: status, Tags: tags}
+	if err := db.Create(&post).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := Changed(db, post.ID); err != nil {
+		t.Fatal(err)
+	}
+	return post
+}
+
+func ids(entries []models.RelatedEntry) []uint {
+	out := make([]uint, len(entries))
+	for i, e := range entries {
+		out[i] = e.PostID
+	}
+	return out
+}
+
+func TestCompute(t *testing.T) {
+	db := newTestDB(t)
+	golang := models.Tag{Name: "go", Slug: "go"}
+	db.Create(&golang)
+	target := addPost(t, db, models.PostPublished, "Channels in Go", "Goroutines talk over channels, buffered channels queue values.", golang)
+	text := addPost(t, db, models.PostPublished, "Buffered channels", "A buffered channel queues values for goroutines.")
+	tagged := addPost(t, db, models.PostPublished, "Modules", "Versioning dependencies with modules.", golang)
+	addPost(t, db, models.PostPublished, "Sourdough", "Flour, water and patience make bread.")
+	addPost(t, db, models.PostDraft, "Draft on channels", "Goroutines talk over buffered channels.")
+
+	tests := []struct {
+		name string
+		text bool
+		want []uint
+	}{
+		{"tags and text", true, []uint{tagged.ID, text.ID}},
+		{"tags only", false, []uint{tagged.ID}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, err := Compute(db, target.ID, tt.text)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := ids(entries); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("suggestions = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChangedInvalidatesInvolvedPosts(t *testing.T) {
+	db := newTestDB(t)
+	a := addPost(t, db, models.PostPublished, "Channels", "Goroutines talk over buffered channels.")
+	b := addPost(t, db, models.PostPublished, "Buffered channels", "A buffered channel queues values for goroutines.")
+	c := addPost(t, db, models.PostPublished, "Sourdough", "Flour, water and patience make sourdough bread.")
+	d := addPost(t, db, models.PostPublished, "Rye bread", "Rye flour makes a dense sourdough bread.")
+	opts := Options{TTL: time.Hour, Text: true}
+
+	tests := []struct {
+		name   string
+		change func(t *testing.T) uint
+		kept   []uint
+	}{
+		{"edit", func(t *testing.T) uint {
+			db.Model(&a).Update("content", "Goroutines block on unbuffered channels.")
+			return a.ID
+		}, []uint{c.ID, d.ID}},
+		{"unpublish", func(t *testing.T) uint {
+			db.Model(&d).Update("status", models.PostDraft)
+			return d.ID
+		}, []uint{a.ID, b.ID}},
+		{"delete", func(t *testing.T) uint {
+			db.Delete(&b)
+			return b.ID
+		}, []uint{c.ID, d.ID}},
+		{"publish", func(t *testing.T) uint {
+			return addPost(t, db, models.PostPublished, "Bread", "Sourdough bread from flour and water.").ID
+		}, []uint{a.ID, d.ID}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var live []uint
+			db.Model(&models.Post{}).Where("id IN ?", []uint{a.ID, b.ID, c.ID, d.ID}).Pluck("id", &live)
+			for _, id := range live {
+				if _, err := Refresh(db, id, opts); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := Changed(db, tt.change(t)); err != nil {
+				t.Fatal(err)
+			}
+			var cached []uint
+			db.Model(&models.RelatedPosts{}).Order("post_id").Pluck("post_id", &cached)
+			if fmt.Sprint(cached) != fmt.Sprint(tt.kept) {
+				t.Errorf("cached = %v, want %v", cached, tt.kept)
+			}
+		})
+	}
+}
+
+// checkDocFreqs compares the stored document frequencies to a count over the
+// published posts
+func checkDocFreqs(t *testing.T, db *gorm.DB) {
+	t.Helper()
+	var posts []models.Post
+	indexable(db).Find(&posts)
+	want := map[string]int64{}
+	for _, p := range posts {
+		for term := range termCounts(p) {
+			want[term]++
+		}
+	}
+	var terms []models.RelatedTerm
+	db.Find(&terms)
+	got := map[string]int64{}
+	for _, r := range terms {
+		got[r.Term] = r.Docs
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("document frequencies = %v, want %v", got, want)
+	}
+}
+
+func TestDocFreqsFollowChanges(t *testing.T) {
+	db := newTestDB(t)
+	a := addPost(t, db, models.PostPublished, "Channels", "Buffered channels queue values.")
+	b := addPost(t, db, models.PostDraft, "Select", "Select waits on channels.")
+	c := addPost(t, db, models.PostPublished, "Bread", "Flour and water.")
+
+	steps := []struct {
+		name   string
+		change func() uint
+	}{
+		{"publish", func() uint { db.Model(&b).Update("status", models.PostPublished); return b.ID }},
+		{"edit", func() uint { db.Model(&a).Update("content", "Unbuffered channels block."); return a.ID }},
+		{"unpublish", func() uint { db.Model(&c).Update("status", models.PostDraft); return c.ID }},
+		{"delete", func() uint { db.Delete(&b); return b.ID }},
+		{"republish", func() uint { db.Model(&c).Update("status", models.PostPublished); return c.ID }},
+	}
+	checkDocFreqs(t, db)
+	for _, s := range steps {
+		t.Run(s.name, func(t *testing.T) {
+			if err := Changed(db, s.change()); err != nil {
+				t.Fatal(err)
+			}
+			checkDocFreqs(t, db)
+		})
+	}
+
+	t.Run("catch up", func(t *testing.T) {
+		db.Where("post_id = ?", a.ID).Delete(&models.RelatedPosting{})
+		if err := CatchUp(db); err != nil {
+			t.Fatal(err)
+		}
+		checkDocFreqs(t, db)
+		var indexed int64
+		db.Model(&models.RelatedPosting{}).Distinct("post_id").Count(&indexed)
+		if indexed != 2 {
+			t.Errorf("indexed posts = %d, want 2", indexed)
+		}
+	})
+}
+
+func TestSimilarScoresBoundedCandidates(t *testing.T) {
+	db := newTestDB(t)
+	target := addPost(t, db, models.PostPublished, "Channels", "Buffered channels and goroutines.")
+	for i := 0; i < maxCandidates+20; i++ {
+		addPost(t, db, models.PostPublished, "Channels", "Buffered channels and goroutines.")
+	}
+	// unrelated posts, so the shared words are not in every post
+	for i := 0; i < 50; i++ {
+		addPost(t, db, models.PostPublished, "Bread", "Flour, water and patience.")
+	}
+	scores := map[uint]float64{}
+	if err := similar(db, target.ID, scores); err != nil {
+		t.Fatal(err)
+	}
+	if len(scores) != maxCandidates {
+		t.Errorf("scored %d posts, want %d", len(scores), maxCandidates)
+	}
+}
@@ -0,0 +1,290 @@
+package related
+
+import (
+	"log"
+	"math"
+	"sort"
+	"unicode/utf8"
+
+	"easyblog/internal/models"
+	"easyblog/internal/search"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// the highest weighted terms of a post that pick its candidates
+	candidateTerms = 32
+	// posts scored by text per suggestion, however large the blog is
+	maxCandidates = 100
+	// longer tokens are noise such as urls and would not fit the term column
+	maxTermLen = 64
+	// rows per insert and values per IN list, well below every driver's
+	// limit on bound parameters
+	chunk = 200
+)
+
+// CatchUp rebuilds the postings when they do not cover the published posts,
+// e.g. on first start or after the tokenizer changed
+func CatchUp(db *gorm.DB) error {
+	var posts, indexed int64
+	if err := indexable(db.Model(&models.Post{})).Count(&posts).Error; err != nil {
+		return err
+	}
+	if err := db.Model(&models.RelatedPosting{}).Distinct("post_id").Count(&indexed).Error; err != nil {
+		return err
+	}
+	var version models.ConfigModel
+	if err := db.Where("key = ?", "related_index_version").Limit(1).Find(&version).Error; err != nil {
+		return err
+	}
+	if indexed == posts && version.Value == search.IndexVersion {
+		return nil
+	}
+	all := db.Session(&gorm.Session{AllowGlobalUpdate: true})
+	if err := all.Delete(&models.RelatedPosting{}).Error; err != nil {
+		return err
+	}
+	if err := all.Delete(&models.RelatedTerm{}).Error; err != nil {
+		return err
+	}
+	var batch []models.Post
+	res := indexable(db.Select("id", "title", "content", "status")).FindInBatches(&batch, chunk, func(*gorm.DB, int) error {
+		for _, p := range batch {
+			if err := db.Transaction(func(tx *gorm.DB) error { return index(tx, p) }); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if res.Error != nil {
+		return res.Error
+	}
+	version.Key, version.Value = "related_index_version", search.IndexVersion
+	if err := db.Save(&version).Error; err != nil {
+		return err
+	}
+	log.Printf("related: indexed %d posts", posts)
+	return nil
+}
+
+// indexable narrows db to the posts with postings: published ones with text
+func indexable(db *gorm.DB) *gorm.DB {
+	return db.Where("status = ? AND content <> ''", models.PostPublished)
+}
+
+func hasPostings(p models.Post) bool {
+	return p.Status == models.PostPublished && p.Content != ""
+}
+
+// reindex replaces the postings of post id, which may have been deleted
+func reindex(db *gorm.DB, id uint) (models.Post, error) {
+	var post models.Post
+	if err := db.Select("id", "title", "content", "status").Limit(1).Find(&post, id).Error; err != nil {
+		return post, err
+	}
+	post.ID = id
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := unindex(tx, id); err != nil {
+			return err
+		}
+		return index(tx, post)
+	})
+	return post, err
+}
+
+// unindex removes the postings of post id and their document frequencies
+func unindex(tx *gorm.DB, id uint) error {
+	var terms []string
+	if err := tx.Model(&models.RelatedPosting{}).Where("post_id = ?", id).Pluck("term", &terms).Error; err != nil {
+		return err
+	}
+	if len(terms) == 0 {
+		return nil
+	}
+	if err := tx.Where("post_id = ?", id).Delete(&models.RelatedPosting{}).Error; err != nil {
+		return err
+	}
+	for i := 0; i < len(terms); i += chunk {
+		part := terms[i:min(i+chunk, len(terms))]
+		err := tx.Model(&models.RelatedTerm{}).Where("term IN ?", part).
+			Update("docs", gorm.Expr("docs - 1")).Error
+		if err != nil {
+			return err
+		}
+		if err := tx.Where("term IN ? AND docs <= 0", part).Delete(&models.RelatedTerm{}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// index adds the postings of post, when it should have any, and counts it
+// in the document frequency of its terms
+func index(tx *gorm.DB, post models.Post) error {
+	if !hasPostings(post) {
+		return nil
+	}
+	tf := termCounts(post)
+	if len(tf) == 0 {
+		return nil
+	}
+	postings := make([]models.RelatedPosting, 0, len(tf))
+	terms := make([]models.RelatedTerm, 0, len(tf))
+	for t, f := range tf {
+		postings = append(postings, models.RelatedPosting{Term: t, PostID: post.ID, Freq: f})
+		terms = append(terms, models.RelatedTerm{Term: t, Docs: 1})
+	}
+	if err := tx.CreateInBatches(postings, chunk).Error; err != nil {
+		return err
+	}
+	return tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "term"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{"docs": gorm.Expr("related_terms.docs + 1")}),
+	}).CreateInBatches(terms, chunk).Error
+}
+
+// docFreqs looks up the number of posts with each of terms
+func docFreqs(db *gorm.DB, terms []string) (map[string]int64, error) {
+	df := make(map[string]int64, len(terms))
+	for i := 0; i < len(terms); i += chunk {
+		var rows []models.RelatedTerm
+		if err := db.Where("term IN ?", terms[i:min(i+chunk, len(terms))]).Find(&rows).Error; err != nil {
+			return nil, err
+		}
+		for _, r := range rows {
+			df[r.Term] = r.Docs
+		}
+	}
+	return df, nil
+}
+
+// similar adds the TF-IDF cosine similarity of the text of postID to the
+// candidate posts that are close enough
+func similar(db *gorm.DB, postID uint, scores map[uint]float64) error {
+	var self models.Post
+	if err := db.Select("id", "title", "content", "status").First(&self, postID).Error; err != nil {
+		return err
+	}
+	own := termCounts(self)
+	if len(own) == 0 {
+		return nil
+	}
+	var n int64
+	if err := indexable(db.Model(&models.Post{})).Count(&n).Error; err != nil {
+		return err
+	}
+	terms := make([]string, 0, len(own))
+	for t := range own {
+		terms = append(terms, t)
+	}
+	df, err := docFreqs(db, terms)
+	if err != nil {
+		return err
+	}
+	// the post itself is part of the corpus for document frequencies, even
+	// when it has no postings
+	var selfDocs int64
+	if !hasPostings(self) {
+		selfDocs = 1
+		n++
+	}
+	for t := range own {
+		df[t] = max(df[t]+selfDocs, 1)
+	}
+	weight := func(f float64, docs int64) float64 {
+		return (1 + math.Log(f)) * math.Log(float64(n)/float64(docs))
+	}
+	target := make(map[string]float64, len(own))
+	var targetNorm float64
+	for t, f := range own {
+		if w := weight(f, df[t]); w > 0 {
+			target[t] = w
+			targetNorm += w * w
+		}
+	}
+	if targetNorm == 0 {
+		return nil
+	}
+	targetNorm = math.Sqrt(targetNorm)
+	ids, err := candidates(db, postID, target, df)
+	if err != nil || len(ids) == 0 {
+		return err
+	}
+	var rows []struct {
+		PostID uint
+		Term   string
+		Freq   float64
+		Docs   int64
+	}
+	err = db.Table("related_postings").
+		Select("related_postings.post_id, related_postings.term, related_postings.freq, related_terms.docs").
+		Joins("JOIN related_terms ON related_terms.term = related_postings.term").
+		Where("related_postings.post_id IN ?", ids).Scan(&rows).Error
+	if err != nil {
+		return err
+	}
+	dots := make(map[uint]float64, len(ids))
+	norms := make(map[uint]float64, len(ids))
+	for _, r := range rows {
+		docs := r.Docs
+		if _, ok := own[r.Term]; ok {
+			docs += selfDocs
+		}
+		w := weight(r.Freq, docs)
+		if w <= 0 {
+			continue
+		}
+		norms[r.PostID] += w * w
+		dots[r.PostID] += w * target[r.Term]
+	}
+	for id, dot := range dots {
+		if dot == 0 {
+			continue
+		}
+		if sim := dot / (math.Sqrt(norms[id]) * targetNorm); sim >= minSimilarity {
+			scores[id] += textWeight * sim
+		}
+	}
+	return nil
+}
+
+// candidates picks the posts sharing the most of the candidateTerms highest
+// weighted terms of a post that other posts have too, at most maxCandidates
+// of them
+func candidates(db *gorm.DB, postID uint, target map[string]float64, df map[string]int64) ([]uint, error) {
+	terms := make([]string, 0, len(target))
+	for t := range target {
+		if df[t] > 1 {
+			terms = append(terms, t)
+		}
+	}
+	if len(terms) == 0 {
+		return nil, nil
+	}
+	sort.Slice(terms, func(i, j int) bool {
+		if target[terms[i]] != target[terms[j]] {
+			return target[terms[i]] > target[terms[j]]
+		}
+		return terms[i] < terms[j]
+	})
+	if len(terms) > candidateTerms {
+		terms = terms[:candidateTerms]
+	}
+	var ids []uint
+	err := db.Model(&models.RelatedPosting{}).Where("term IN ? AND post_id <> ?", terms, postID).
+		Group("post_id").Order("COUNT(*) DESC, post_id DESC").Limit(maxCandidates).Pluck("post_id", &ids).Error
+	return ids, err
+}
+
+// termCounts counts the search tokens of a post's title and text
+func termCounts(p models.Post) map[string]float64 {
+	tf := map[string]float64{}
+	for _, t := range search.Tokens(p.Title + " " + search.Plain(p.Content)) {
+		if utf8.RuneCountInString(t) <= maxTermLen {
+			tf[t]++
+		}
+	}
+	return tf
+}
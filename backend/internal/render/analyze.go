@@ -0,0 +1,173 @@
+package render
+
+import (
+	"math"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"easyblog/internal/models"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+)
+
+// reading speeds, CJK text is read per character rather than per word
+const (
+	wordsPerMinute = 200
+	cjkPerMinute   = 400
+)
+
+// summaryLen is the length in characters of generated summaries
+const summaryLen = 200
+
+// Analysis describes the Markdown of a post
+type Analysis struct {
+	// latin words plus CJK characters
+	Words          int
+	ReadingMinutes int
+	// plain text of the first paragraphs
+	Summary string
+	// headings with the anchors Post gives them
+	TOC []models.TOCEntry
+}
+
+// Analyze counts words and extracts the summary and table of contents of a
+// post's Markdown
+func Analyze(src string) Analysis {
+	source := []byte(src)
+	ctx := parser.NewContext(parser.WithIDs(&headingIDs{seen: map[string]bool{}}))
+	doc := postMarkdown.Parser().Parse(text.NewReader(source), parser.WithContext(ctx))
+
+	var (
+		a          Analysis
+		latin, cjk int
+		summary    []string
+		summaryN   int
+	)
+	count := func(b []byte) {
+		w, c := countWords(b)
+		latin += w
+		cjk += c
+	}
+	_ = ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch n := n.(type) {
+		case *ast.Heading:
+			entry := models.TOCEntry{Level: n.Level, Text: plainText(n, source)}
+			if id, ok := n.AttributeString("id"); ok {
+				if b, ok := id.([]byte); ok {
+					entry.ID = string(b)
+				}
+			}
+			a.TOC = append(a.TOC, entry)
+		case *ast.Paragraph:
+			if n.Parent() != nil && n.Parent().Kind() == ast.KindDocument && summaryN < summaryLen {
+				if s := plainText(n, source); s != "" {
+					summary = append(summary, s)
+					summaryN += utf8.RuneCountInString(s)
+				}
+			}
+		case *ast.Text:
+			count(n.Value(source))
+		case *ast.String:
+			count(n.Value)
+		case *ast.CodeBlock, *ast.FencedCodeBlock:
+			lines := n.Lines()
+			for i := 0; i < lines.Len(); i++ {
+				seg := lines.At(i)
+				count(seg.Value(source))
+			}
+			return ast.WalkSkipChildren, nil
+		}
+		return ast.WalkContinue, nil
+	})
+
+	a.Words = latin + cjk
+	a.ReadingMinutes = int(math.Ceil(float64(latin)/wordsPerMinute + float64(cjk)/cjkPerMinute))
+	a.Summary = truncate(strings.Join(summary, " "), summaryLen)
+	return a
+}
+
+// ApplyTo stores the analysis on post. A generated summary replaces an empty
+// one and keeps following the content until the author writes their own.
+func (a Analysis) ApplyTo(post *models.Post) {
+	post.WordCount = a.Words
+	post.ReadingMinutes = a.ReadingMinutes
+	post.TOC = a.TOC
+	if post.Summary == "" || post.SummaryAuto {
+		post.Summary = a.Summary
+		post.SummaryAuto = true
+	}
+}
+
+// plainText joins the text inside n
+func plainText(n ast.Node, source []byte) string {
+	var sb strings.Builder
+	_ = ast.Walk(n, func(c ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch c := c.(type) {
+		case *ast.Text:
+			sb.Write(c.Value(source))
+			if c.SoftLineBreak() || c.HardLineBreak() {
+				sb.WriteByte(' ')
+			}
+		case *ast.String:
+			sb.Write(c.Value)
+		case *ast.RawHTML:
+			return ast.WalkSkipChildren, nil
+		}
+		return ast.WalkContinue, nil
+	})
+	return strings.TrimSpace(sb.String())
+}
+
+func isCJK(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul)
+}
+
+// countWords counts latin style words and CJK characters in b
+func countWords(b []byte) (words, cjk int) {
+	inWord := false
+	for _, r := range string(b) {
+		switch {
+		case isCJK(r):
+			cjk++
+			inWord = false
+		case unicode.IsLetter(r) || unicode.IsNumber(r) || r == '\'' && inWord:
+			if !inWord {
+				words++
+				inWord = true
+			}
+		default:
+			inWord = false
+		}
+	}
+	return words, cjk
+}
+
+// truncate shortens s to about n characters, at a word boundary for latin text
+func truncate(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	cut := n
+	if !isCJK(runes[cut-1]) {
+		// back off to the last space unless the word is very long
+		for i := cut; i > n*3/4; i-- {
+			if unicode.IsSpace(runes[i]) {
+				cut = i
+				break
+			}
+		}
+	}
+	return strings.TrimRightFunc(string(runes[:cut]), func(r rune) bool {
+		return unicode.IsSpace(r) || unicode.IsPunct(r)
+	}) + "…"
+}
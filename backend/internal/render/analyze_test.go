@@ -0,0 +1,99 @@
+package render
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"easyblog/internal/models"
+)
+
+func TestAnalyzeCounts(t *testing.T) {
+	tests := []struct {
+		name        string
+		src         string
+		wantWords   int
+		wantMinutes int
+	}{
+		{"empty", "", 0, 0},
+		{"latin words", "Hello, world! It's a *fine* day.", 6, 1},
+		{"markup not counted", "# Title\n\n[link text](https://example.com/a/b/c)", 3, 1},
+		{"code counted", "```go\nfmt.Println(x)\n```", 3, 1},
+		{"cjk by character", "东京塔是一座电波塔", 9, 1},
+		{"mixed", "Go 语言", 3, 1},
+		{"latin reading time", strings.Repeat("word ", 401), 401, 3},
+		{"cjk reading time", strings.Repeat("字", 801), 801, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := Analyze(tt.src)
+			if a.Words != tt.wantWords || a.ReadingMinutes != tt.wantMinutes {
+				t.Errorf("words, minutes = %d, %d; want %d, %d", a.Words, a.ReadingMinutes, tt.wantWords, tt.wantMinutes)
+			}
+		})
+	}
+}
+
+func TestAnalyzeSummary(t *testing.T) {
+	long := strings.Repeat("lorem ipsum ", 40)
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"first paragraphs", "# Title\n\nFirst *one*.\n\nSecond.", "First one. Second."},
+		{"skips code and quotes", "> quoted\n\n```\ncode\n```\n\nText.", "Text."},
+		{"skips raw html", "Hi <b>there</b>.", "Hi there."},
+		{"cut at a word", long, strings.TrimSpace(long[:198]) + "…"},
+		{"cjk cut anywhere", strings.Repeat("字", 250), strings.Repeat("字", 200) + "…"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Analyze(tt.src).Summary; got != tt.want {
+				t.Errorf("summary = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAnalyzeTOCMatchesRenderedAnchors(t *testing.T) {
+	src := "# Intro\n\n## Setup *fast*\n\n## Setup fast\n\n### 东京 タワー\n\ntext"
+	want := []models.TOCEntry{
+		{Level: 1, Text: "Intro", ID: "intro"},
+		{Level: 2, Text: "Setup fast", ID: "setup-fast"},
+		{Level: 2, Text: "Setup fast", ID: "setup-fast-1"},
+		{Level: 3, Text: "东京 タワー", ID: "东京-タワー"},
+	}
+	toc := Analyze(src).TOC
+	if !reflect.DeepEqual(toc, want) {
+		t.Fatalf("toc = %+v, want %+v", toc, want)
+	}
+	html := Post(src)
+	for _, e := range toc {
+		if !strings.Contains(html, `id="`+e.ID+`"`) {
+			t.Errorf("rendered html lacks anchor %q", e.ID)
+		}
+	}
+}
+
+func TestApplyTo(t *testing.T) {
+	tests := []struct {
+		name     string
+		post     models.Post
+		wantSum  string
+		wantAuto bool
+	}{
+		{"empty summary generated", models.Post{}, "Body.", true},
+		{"generated summary follows", models.Post{Summary: "old", SummaryAuto: true}, "Body.", true},
+		{"author summary kept", models.Post{Summary: "mine"}, "mine", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			post := tt.post
+			Analyze("Body.").ApplyTo(&post)
+			if post.Summary != tt.wantSum || post.SummaryAuto != tt.wantAuto || post.WordCount != 1 {
+				t.Errorf("summary %q auto %v words %d", post.Summary, post.SummaryAuto, post.WordCount)
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+// Package render turns Markdown into sanitized HTML so every consumer of
+// posts and comments (api, feeds, mail) shows the same markup.
+package render
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+
+	"easyblog/internal/slug"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer/html"
+)
+
+// Version changes whenever the output for the same source changes, which
+// invalidates cached renderings
+const Version = 2
+
+var (
+	postMarkdown = goldmark.New(
+		goldmark.WithExtensions(append(gfm(), extension.Footnote)...),
+		goldmark.WithParserOptions(parser.WithAutoHeadingID()),
+		// authors may embed html, the sanitizer decides what stays
+		goldmark.WithRendererOptions(html.WithUnsafe()),
+	)
+	commentMarkdown = goldmark.New(
+		goldmark.WithExtensions(gfm()...),
+	)
+
+	postPolicy    = newPolicy()
+	commentPolicy = newCommentPolicy()
+)
+
+// Post renders the Markdown of a post: CommonMark with GFM tables, task
+// lists, strikethrough and autolinks, footnotes and heading anchors
+func Post(src string) string {
+	return convert(postMarkdown, postPolicy, src)
+}
+
+// Comment renders the Markdown of a comment. Raw html is dropped and links
+// are marked nofollow.
+func Comment(src string) string {
+	return convert(commentMarkdown, commentPolicy, src)
+}
+
+func convert(md goldmark.Markdown, policy *bluemonday.Policy, src string) string {
+	var buf bytes.Buffer
+	ctx := parser.NewContext(parser.WithIDs(&headingIDs{seen: map[string]bool{}}))
+	if err := md.Convert([]byte(src), &buf, parser.WithContext(ctx)); err != nil {
+		// goldmark only fails on writer errors, never on input
+		return ""
+	}
+	return policy.Sanitize(buf.String())
+}
+
+// gfm is extension.GFM with table alignment as attributes, which the
+// sanitizer can check unlike style
+func gfm() []goldmark.Extender {
+	return []goldmark.Extender{
+		extension.NewTable(extension.WithTableCellAlignMethod(extension.TableCellAlignAttribute)),
+		extension.Strikethrough,
+		extension.Linkify,
+		extension.TaskList,
+	}
+}
+
+// headingIDs derives heading anchors like post slugs, so headings in any
+// script get a readable id
+type headingIDs struct {
+	seen map[string]bool
+}
+
+func (ids *headingIDs) Generate(value []byte, kind ast.NodeKind) []byte {
+	base := slug.Make(string(value))
+	if base == "" {
+		base = "section"
+	}
+	id := base
+	for i := 1; ids.seen[id]; i++ {
+		id = fmt.Sprintf("%s-%d", base, i)
+	}
+	ids.seen[id] = true
+	return []byte(id)
+}
+
+func (ids *headingIDs) Put(value []byte) {
+	ids.seen[string(value)] = true
+}
+
+var (
+	anchorID   = regexp.MustCompile(`^[\p{L}\p{N}_:.-]+$`)
+	codeClass  = regexp.MustCompile(`^language-[\w+#.-]+$`)
+	noteClass  = regexp.MustCompile(`^(footnote-ref|footnote-backref|footnotes)$`)
+	noteRole   = regexp.MustCompile(`^doc-(noteref|backlink|endnotes)$`)
+	alignValue = regexp.MustCompile(`^(left|right|center)$`)
+)
+
+func newCommentPolicy() *bluemonday.Policy {
+	p := bluemonday.UGCPolicy()
+	p.AllowAttrs("class").Matching(codeClass).OnElements("code")
+	p.AllowAttrs("align").Matching(alignValue).OnElements("th", "td")
+	p.AllowAttrs("type").Matching(regexp.MustCompile(`^checkbox$`)).OnElements("input")
+	p.AllowAttrs("checked", "disabled").Matching(regexp.MustCompile(`^$`)).OnElements("input")
+	p.RequireNoFollowOnLinks(true)
+	p.AddTargetBlankToFullyQualifiedLinks(true)
+	return p
+}
+
+func newPolicy() *bluemonday.Policy {
+	p := newCommentPolicy()
+	p.RequireNoFollowOnLinks(false)
+	p.AddTargetBlankToFullyQualifiedLinks(false)
+	// heading anchors and footnotes, goldmark keeps non latin letters in ids
+	p.AllowAttrs("id").Matching(anchorID).OnElements("h1", "h2", "h3", "h4", "h5", "h6", "li", "sup")
+	p.AllowAttrs("class").Matching(noteClass).OnElements("a", "div")
+	p.AllowAttrs("role").Matching(noteRole).OnElements("a", "div")
+	return p
+}
@@ -0,0 +1,96 @@
+package render
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPostSanitizes(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		banned  []string
+		wantHas []string
+	}{
+		{"script tag", "hi <script>alert(1)</script>", []string{"<script", "alert(1)"}, []string{"hi"}},
+		{"event handler", `<img src="/a.png" onerror="alert(1)">`, []string{"onerror"}, []string{`src="/a.png"`}},
+		{"javascript link", "[x](javascript:alert(1))", []string{"javascript:"}, nil},
+		{"javascript link html", `<a href="javascript:alert(1)">x</a>`, []string{"javascript:"}, []string{"x"}},
+		{"data uri link", "[x](data:text/html;base64,PHNjcmlwdD4=)", []string{"data:text/html"}, nil},
+		{"iframe", `<iframe src="https://evil.example"></iframe>`, []string{"<iframe"}, nil},
+		{"style attribute", `<p style="background:url(javascript:alert(1))">x</p>`, []string{"style=", "javascript:"}, []string{"x"}},
+		{"svg", `<svg onload="alert(1)"><circle/></svg>`, []string{"<svg", "onload"}, nil},
+		{"unquoted handler", `<h2 id=x onclick=alert(1)>t</h2>`, []string{"onclick"}, []string{"t</h2>"}},
+		{"forged class", `<div class="footnotes evil">x</div>`, []string{"evil"}, nil},
+		{"code class", "```go\nfmt.Println()\n```", nil, []string{`class="language-go"`}},
+		{"escaped in code", "`<script>`", []string{"<script>"}, []string{"&lt;script&gt;"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Post(tt.src)
+			for _, b := range tt.banned {
+				if strings.Contains(got, b) {
+					t.Errorf("Post(%q) = %q contains %q", tt.src, got, b)
+				}
+			}
+			for _, w := range tt.wantHas {
+				if !strings.Contains(got, w) {
+					t.Errorf("Post(%q) = %q lacks %q", tt.src, got, w)
+				}
+			}
+		})
+	}
+}
+
+func TestPostMarkdown(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"heading anchor", "## Hello World", `<h2 id="hello-world">Hello World</h2>`},
+		{"heading anchor non latin", "## 東京 タワー", `<h2 id="東京-タワー">東京 タワー</h2>`},
+		{"duplicate headings", "# A\n\n# A", "<h1 id=\"a\">A</h1>\n<h1 id=\"a-1\">A</h1>"},
+		{"strikethrough", "~~old~~", "<p><del>old</del></p>"},
+		{"autolink", "see https://example.com", `<p>see <a href="https://example.com">https://example.com</a></p>`},
+		{"table alignment", "| a |\n|:-:|\n| b |", `<th align="center">a</th>`},
+		{"task list", "- [x] done", `<input checked="" disabled="" type="checkbox"`},
+		{"footnote", "a[^1]\n\n[^1]: note", `class="footnote-ref"`},
+		{"embedded html kept", "<details><summary>s</summary>x</details>", "<details><summary>s</summary>x</details>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Post(tt.src); !strings.Contains(got, tt.want) {
+				t.Errorf("Post(%q) = %q, want it to contain %q", tt.src, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestComment(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		want    string
+		notWant string
+	}{
+		{"raw html dropped", "hi <b onclick=x>there</b>", "<p>hi there</p>", "<b"},
+		{"script dropped", "<script>alert(1)</script>", "", "alert"},
+		{"links nofollow", "[x](https://example.com)", `rel="nofollow noopener"`, ""},
+		{"external links new tab", "https://example.com", `target="_blank"`, ""},
+		{"javascript link", "[x](javascript:alert(1))", "x", "javascript:"},
+		{"no heading anchors", "# Title", "<h1>Title</h1>", "id="},
+		{"no footnotes", "a[^1]\n\n[^1]: note", "<p>a", "footnote"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Comment(tt.src)
+			if !strings.Contains(got, tt.want) {
+				t.Errorf("Comment(%q) = %q, want it to contain %q", tt.src, got, tt.want)
+			}
+			if tt.notWant != "" && strings.Contains(got, tt.notWant) {
+				t.Errorf("Comment(%q) = %q contains %q", tt.src, got, tt.notWant)
+			}
+		})
+	}
+}
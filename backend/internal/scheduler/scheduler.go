@@ -0,0 +1,150 @@
+// Package scheduler publishes and unpublishes posts at their scheduled time
+// and lifts expired pins.
+// All state lives in the database, so pending transitions survive restarts,
+// and every transition is a conditional update, so several instances can run
+// the scheduler against the same database without applying one twice.
+package scheduler
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"easyblog/internal/models"
+	"easyblog/internal/related"
+
+	"gorm.io/gorm"
+)
+
+// batch bounds the posts handled per tick and kind
+const batch = 100
+
+type Scheduler struct {
+	db       *gorm.DB
+	interval time.Duration
+}
+
+func New(db *gorm.DB, interval time.Duration) *Scheduler {
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+	return &Scheduler{db: db, interval: interval}
+}
+
+// Run catches up on anything due, then ticks until ctx is done
+func (s *Scheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		if err := s.Tick(time.Now()); err != nil {
+			log.Printf("scheduler: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Tick applies every transition due at now
+func (s *Scheduler) Tick(now time.Time) error {
+	// schedule times are stored in UTC and sqlite compares them as text
+	now = now.UTC()
+	if err := s.publishDue(now); err != nil {
+		return err
+	}
+	if err := s.unpublishDue(now); err != nil {
+		return err
+	}
+	return s.unpinDue(now)
+}
+
+func (s *Scheduler) publishDue(now time.Time) error {
+	var due []models.Post
+	err := s.db.Select("id", "publish_at").
+		Where("status = ? AND publish_at <= ?", models.PostScheduled, now).
+		Order("publish_at").Limit(batch).Find(&due).Error
+	if err != nil {
+		return err
+	}
+	var changed []uint
+	for _, p := range due {
+		// the status condition makes sure only one instance wins, the time
+		// condition skips posts rescheduled since they were read
+		res := s.db.Model(&models.Post{}).
+			Where("id = ? AND status = ? AND publish_at <= ?", p.ID, models.PostScheduled, now).
+			Updates(map[string]interface{}{
+				"status":       models.PostPublished,
+				"published_at": gorm.Expr("COALESCE(published_at, ?)", p.PublishAt),
+				"publish_at":   nil,
+			})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 1 {
+			log.Printf("scheduler: published post %d", p.ID)
+			changed = append(changed, p.ID)
+		}
+	}
+	if len(changed) > 0 {
+		statusChanged(s.db, changed)
+	}
+	return nil
+}
+
+func (s *Scheduler) unpublishDue(now time.Time) error {
+	var due []models.Post
+	err := s.db.Select("id").
+		Where("status = ? AND unpublish_at <= ?", models.PostPublished, now).
+		Order("unpublish_at").Limit(batch).Find(&due).Error
+	if err != nil {
+		return err
+	}
+	var changed []uint
+	for _, p := range due {
+		res := s.db.Model(&models.Post{}).
+			Where("id = ? AND status = ? AND unpublish_at <= ?", p.ID, models.PostPublished, now).
+			Updates(map[string]interface{}{
+				"status":       models.PostDraft,
+				"unpublish_at": nil,
+			})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 1 {
+			log.Printf("scheduler: unpublished post %d", p.ID)
+			changed = append(changed, p.ID)
+		}
+	}
+	if len(changed) > 0 {
+		statusChanged(s.db, changed)
+	}
+	return nil
+}
+
+// unpinDue lifts pins past their pinned_until; it is a single conditional
+// update, so running it twice is harmless
+func (s *Scheduler) unpinDue(now time.Time) error {
+	res := s.db.Model(&models.Post{}).
+		Where("pinned = ? AND pinned_until <= ?", true, now).
+		Updates(map[string]interface{}{
+			"pinned":       false,
+			"pinned_until": nil,
+		})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected > 0 {
+		log.Printf("scheduler: unpinned %d posts", res.RowsAffected)
+	}
+	return nil
+}
+
+// statusChanged updates the related posts involving ids, which only
+// suggest published posts
+func statusChanged(db *gorm.DB, ids []uint) {
+	if err := related.Changed(db, ids...); err != nil {
+		log.Printf("scheduler: update related posts: %v", err)
+	}
+}
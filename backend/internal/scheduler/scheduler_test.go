@@ -0,0 +1,122 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+
+	"easyblog/internal/models"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func newTestDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, _ := db.DB()
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+	if err := db.AutoMigrate(&models.Post{}, &models.RelatedPosts{}, &models.RelatedLink{}, &models.RelatedPosting{}, &models.RelatedTerm{}); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestTickWithOffsets(t *testing.T) {
+	base := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) *time.Time {
+		t := base.Add(d)
+		return &t
+	}
+	zones := []*time.Location{
+		time.UTC,
+		time.FixedZone("JST", 9*3600),
+		time.FixedZone("PDT", -7*3600),
+		time.FixedZone("NPT", 5*3600+45*60),
+	}
+	tests := []struct {
+		name string
+		post models.Post
+		want models.PostStatus
+		// pinned after the tick, for posts pinned before it
+		wantPinned bool
+	}{
+		{"publish due", models.Post{Slug: "a", Status: models.PostScheduled, PublishAt: at(-time.Minute)}, models.PostPublished, false},
+		{"publish not due", models.Post{Slug: "b", Status: models.PostScheduled, PublishAt: at(time.Minute)}, models.PostScheduled, false},
+		{"unpublish due", models.Post{Slug: "c", Status: models.PostPublished, UnpublishAt: at(-time.Minute)}, models.PostDraft, false},
+		{"unpublish not due", models.Post{Slug: "d", Status: models.PostPublished, UnpublishAt: at(time.Minute)}, models.PostPublished, false},
+		{"publish due next day in utc", models.Post{Slug: "e", Status: models.PostScheduled, PublishAt: at(-10 * time.Hour)}, models.PostPublished, false},
+		{"publish not due later today in utc", models.Post{Slug: "f", Status: models.PostScheduled, PublishAt: at(8 * time.Hour)}, models.PostScheduled, false},
+		{"pin expired", models.Post{Slug: "g", Status: models.PostPublished, Pinned: true, PinnedUntil: at(-time.Minute)}, models.PostPublished, false},
+		{"pin not expired", models.Post{Slug: "h", Status: models.PostPublished, Pinned: true, PinnedUntil: at(time.Minute)}, models.PostPublished, true},
+	}
+	for _, zone := range zones {
+		for _, tt := range tests {
+			t.Run(zone.String()+"/"+tt.name, func(t *testing.T) {
+				db := newTestDB(t)
+				post := tt.post
+				if err := db.Create(&post).Error; err != nil {
+					t.Fatal(err)
+				}
+				if err := New(db, 0).Tick(base.In(zone)); err != nil {
+					t.Fatal(err)
+				}
+				var got models.Post
+				db.First(&got, post.ID)
+				if got.Status != tt.want {
+					t.Errorf("status = %s, want %s", got.Status, tt.want)
+				}
+				if got.Pinned != tt.wantPinned {
+					t.Errorf("pinned = %v, want %v", got.Pinned, tt.wantPinned)
+				}
+				if tt.want == models.PostPublished && tt.post.PublishAt != nil &&
+					(got.PublishedAt == nil || !got.PublishedAt.Equal(*tt.post.PublishAt)) {
+					t.Errorf("published_at = %v, want %v", got.PublishedAt, tt.post.PublishAt)
+				}
+			})
+		}
+	}
+}
+
+func TestTickSkipsRescheduled(t *testing.T) {
+	now := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+	before, later := now.Add(-time.Minute), now.Add(time.Hour)
+	tests := []struct {
+		name   string
+		post   models.Post
+		column string
+		want   models.PostStatus
+	}{
+		{"publish", models.Post{Slug: "a", Status: models.PostScheduled, PublishAt: &before}, "publish_at", models.PostScheduled},
+		{"unpublish", models.Post{Slug: "b", Status: models.PostPublished, UnpublishAt: &before}, "unpublish_at", models.PostPublished},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			post := tt.post
+			if err := db.Create(&post).Error; err != nil {
+				t.Fatal(err)
+			}
+			// an editor moves the post to later right after the scheduler
+			// read the due posts
+			moved := false
+			db.Callback().Query().After("gorm:query").Register("test:reschedule", func(tx *gorm.DB) {
+				if !moved {
+					moved = true
+					tx.Session(&gorm.Session{NewDB: true, SkipHooks: true}).
+						Exec("UPDATE posts SET "+tt.column+" = ? WHERE id = ?", later, post.ID)
+				}
+			})
+			if err := New(db, 0).Tick(now); err != nil {
+				t.Fatal(err)
+			}
+			var got models.Post
+			db.First(&got, post.ID)
+			if got.Status != tt.want {
+				t.Errorf("status = %s, want %s", got.Status, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,136 @@
+package search
+
+import (
+	"html"
+	"sort"
+	"strings"
+)
+
+// span is a half open range of runes to highlight
+type span struct{ start, end int }
+
+// matches finds where terms occur in text. Words match at the start of a
+// word or of its stem and are marked whole; CJK terms match anywhere.
+func matches(text []rune, terms []Term) []span {
+	folded := make([]rune, len(text))
+	for i, r := range text {
+		folded[i] = fold(r)
+	}
+	var spans []span
+	for _, t := range terms {
+		needle := []rune(t.Text)
+		if isCJK(needle[0]) {
+			for i := 0; i+len(needle) <= len(folded); i++ {
+				if hasPrefixAt(folded, i, needle) {
+					spans = append(spans, span{i, i + len(needle)})
+				}
+			}
+			continue
+		}
+		for i := 0; i < len(folded); i++ {
+			if !isWord(folded[i]) || i > 0 && isWord(folded[i-1]) {
+				continue
+			}
+			end := i
+			for end < len(folded) && isWord(folded[end]) {
+				end++
+			}
+			if wordMatches(folded[i:end], needle, t.Prefix) {
+				spans = append(spans, span{i, end})
+			}
+			i = end - 1
+		}
+	}
+	return merge(spans)
+}
+
+// wordMatches reports whether a folded word or its stem matches needle
+func wordMatches(word, needle []rune, prefix bool) bool {
+	for _, w := range [][]rune{word, stem(word)} {
+		if string(w) == string(needle) || prefix && len(w) > len(needle) && hasPrefixAt(w, 0, needle) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasPrefixAt(s []rune, i int, prefix []rune) bool {
+	for j, r := range prefix {
+		if s[i+j] != r {
+			return false
+		}
+	}
+	return true
+}
+
+// merge sorts spans and joins the overlapping or touching ones, so the
+// bigrams of one CJK word become a single mark
+func merge(spans []span) []span {
+	if len(spans) == 0 {
+		return nil
+	}
+	sort.Slice(spans, func(i, j int) bool { return spans[i].start < spans[j].start })
+	out := spans[:1]
+	for _, s := range spans[1:] {
+		last := &out[len(out)-1]
+		if s.start <= last.end {
+			if s.end > last.end {
+				last.end = s.end
+			}
+			continue
+		}
+		out = append(out, s)
+	}
+	return out
+}
+
+// write escapes text[from:to] and wraps the spans inside it in <mark>
+func write(sb *strings.Builder, text []rune, spans []span, from, to int) {
+	pos := from
+	for _, s := range spans {
+		if s.end <= from || s.start >= to {
+			continue
+		}
+		start, end := max(s.start, from), min(s.end, to)
+		sb.WriteString(html.EscapeString(string(text[pos:start])))
+		sb.WriteString("<mark>")
+		sb.WriteString(html.EscapeString(string(text[start:end])))
+		sb.WriteString("</mark>")
+		pos = end
+	}
+	sb.WriteString(html.EscapeString(string(text[pos:to])))
+}
+
+// Highlight returns text as escaped html with every match of terms in <mark>
+func Highlight(text string, terms []Term) string {
+	runes := []rune(text)
+	var sb strings.Builder
+	write(&sb, runes, matches(runes, terms), 0, len(runes))
+	return sb.String()
+}
+
+// Snippet returns about width characters of text around the first match as
+// escaped html with the matches in <mark>, or the start of text when
+// nothing matches
+func Snippet(text string, terms []Term, width int) string {
+	runes := []rune(text)
+	spans := matches(runes, terms)
+	from := 0
+	if len(spans) > 0 {
+		// some context before the first match
+		from = max(0, spans[0].start-width/4)
+	}
+	to := min(len(runes), from+width)
+	if to == len(runes) {
+		from = max(0, to-width)
+	}
+	var sb strings.Builder
+	if from > 0 {
+		sb.WriteString("…")
+	}
+	write(&sb, runes, spans, from, to)
+	if to < len(runes) {
+		sb.WriteString("…")
+	}
+	return sb.String()
+}
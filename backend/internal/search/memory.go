@@ -0,0 +1,277 @@
+package search
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"easyblog/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// bm25 parameters; title tokens count titleWeight times
+const (
+	bm25K1      = 1.2
+	bm25B       = 0.75
+	titleWeight = 3
+)
+
+// syncInterval is how often searches pick up posts written elsewhere
+const syncInterval = 30 * time.Second
+
+// MemoryEngine is an inverted index held in process. It is built from the
+// database on start and indexes writes of this instance right away. Posts
+// written by other instances or the mdport command are picked up by the
+// first search after syncInterval, which compares update times with the
+// posts table.
+type MemoryEngine struct {
+	db *gorm.DB
+
+	syncMu   sync.Mutex
+	syncedAt time.Time
+
+	mu       sync.RWMutex
+	docs     map[uint]memDoc
+	postings map[string]map[uint]int // token -> post -> weighted frequency
+	tokens   []string                // sorted keys of postings, nil when stale
+	totalLen int
+}
+
+type memDoc struct {
+	length  int
+	tokens  []string
+	updated time.Time
+}
+
+func NewMemory(db *gorm.DB) *MemoryEngine {
+	return &MemoryEngine{db: db, docs: map[uint]memDoc{}, postings: map[string]map[uint]int{}}
+}
+
+func (e *MemoryEngine) Name() string { return "memory" }
+
+func (e *MemoryEngine) Index(post models.Post) error {
+	title, body := document(post)
+	freq := map[string]int{}
+	for _, t := range strings.Fields(title) {
+		freq[t] += titleWeight
+	}
+	for _, t := range strings.Fields(body) {
+		freq[t]++
+	}
+	doc := memDoc{updated: post.UpdatedAt}
+	for t, n := range freq {
+		doc.tokens = append(doc.tokens, t)
+		doc.length += n
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.remove(post.ID)
+	for t, n := range freq {
+		p := e.postings[t]
+		if p == nil {
+			p = map[uint]int{}
+			e.postings[t] = p
+			e.tokens = nil
+		}
+		p[post.ID] = n
+	}
+	e.docs[post.ID] = doc
+	e.totalLen += doc.length
+	return nil
+}
+
+func (e *MemoryEngine) Remove(id uint) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.remove(id)
+	return nil
+}
+
+func (e *MemoryEngine) remove(id uint) {
+	doc, ok := e.docs[id]
+	if !ok {
+		return
+	}
+	for _, t := range doc.tokens {
+		delete(e.postings[t], id)
+		if len(e.postings[t]) == 0 {
+			delete(e.postings, t)
+			e.tokens = nil
+		}
+	}
+	e.totalLen -= doc.length
+	delete(e.docs, id)
+}
+
+// sync indexes the posts that changed in the database since they were
+// indexed and drops deleted ones. Only ids and update times of unchanged
+// posts are read. Concurrent searches do not wait for a running sync.
+func (e *MemoryEngine) sync() error {
+	if !e.syncMu.TryLock() {
+		return nil
+	}
+	defer e.syncMu.Unlock()
+	if time.Since(e.syncedAt) < syncInterval {
+		return nil
+	}
+	var rows []models.Post
+	if err := e.db.Model(&models.Post{}).Select("id", "updated_at").Find(&rows).Error; err != nil {
+		return err
+	}
+	live := make(map[uint]bool, len(rows))
+	var stale, gone []uint
+	e.mu.RLock()
+	for _, r := range rows {
+		live[r.ID] = true
+		if doc, ok := e.docs[r.ID]; !ok || !doc.updated.Equal(r.UpdatedAt) {
+			stale = append(stale, r.ID)
+		}
+	}
+	for id := range e.docs {
+		if !live[id] {
+			gone = append(gone, id)
+		}
+	}
+	e.mu.RUnlock()
+
+	for _, id := range gone {
+		e.Remove(id)
+	}
+	for start := 0; start < len(stale); start += 200 {
+		var batch []models.Post
+		err := e.db.Select(indexColumns).Where("id IN ?", stale[start:min(start+200, len(stale))]).Find(&batch).Error
+		if err != nil {
+			return err
+		}
+		for _, p := range batch {
+			e.Index(p)
+		}
+	}
+	e.syncedAt = time.Now()
+	return nil
+}
+
+func (e *MemoryEngine) Search(q Query) (Result, error) {
+	if err := e.sync(); err != nil {
+		return Result{}, err
+	}
+	scores := e.score(q.Terms)
+	if len(scores) == 0 {
+		return Result{}, nil
+	}
+	// the filter runs in the database, in chunks to stay below bind limits
+	ids := make([]uint, 0, len(scores))
+	for id := range scores {
+		ids = append(ids, id)
+	}
+	var hits []Hit
+	for start := 0; start < len(ids); start += 500 {
+		chunk := ids[start:min(start+500, len(ids))]
+		var kept []uint
+		tx := e.db.Model(&models.Post{})
+		if q.Filter != nil {
+			tx = tx.Scopes(q.Filter)
+		}
+		if err := tx.Where("posts.id IN ?", chunk).Pluck("posts.id", &kept).Error; err != nil {
+			return Result{}, err
+		}
+		for _, id := range kept {
+			hits = append(hits, Hit{PostID: id, Score: scores[id]})
+		}
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].PostID > hits[j].PostID
+	})
+	res := Result{Total: int64(len(hits))}
+	if q.Offset < len(hits) {
+		res.Hits = hits[q.Offset:min(q.Offset+q.Limit, len(hits))]
+	}
+	return res, nil
+}
+
+// score ranks the posts matching every term with BM25
+func (e *MemoryEngine) score(terms []Term) map[uint]float64 {
+	// write lock as the sorted token list may need a rebuild
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.tokens == nil {
+		e.tokens = make([]string, 0, len(e.postings))
+		for t := range e.postings {
+			e.tokens = append(e.tokens, t)
+		}
+		sort.Strings(e.tokens)
+	}
+	n := float64(len(e.docs))
+	if n == 0 || len(terms) == 0 {
+		return nil
+	}
+	avgLen := float64(e.totalLen) / n
+	var scores map[uint]float64
+	for _, term := range terms {
+		found := map[uint]float64{}
+		for _, token := range e.expand(term) {
+			p := e.postings[token]
+			idf := math.Log(1 + (n-float64(len(p))+0.5)/(float64(len(p))+0.5))
+			for id, tf := range p {
+				norm := bm25K1 * (1 - bm25B + bm25B*float64(e.docs[id].length)/avgLen)
+				found[id] += idf * float64(tf) * (bm25K1 + 1) / (float64(tf) + norm)
+			}
+		}
+		if scores == nil {
+			scores = found
+			continue
+		}
+		// every term has to match
+		for id := range scores {
+			if s, ok := found[id]; ok {
+				scores[id] += s
+			} else {
+				delete(scores, id)
+			}
+		}
+	}
+	return scores
+}
+
+// expand lists the indexed tokens term matches
+func (e *MemoryEngine) expand(term Term) []string {
+	if !term.Prefix {
+		if _, ok := e.postings[term.Text]; ok {
+			return []string{term.Text}
+		}
+		return nil
+	}
+	var out []string
+	for i := sort.SearchStrings(e.tokens, term.Text); i < len(e.tokens) && strings.HasPrefix(e.tokens[i], term.Text); i++ {
+		out = append(out, e.tokens[i])
+	}
+	return out
+}
+
+func (e *MemoryEngine) count() (int64, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return int64(len(e.docs)), nil
+}
+
+// reset empties the index before catchUp rebuilds it from the database,
+// which also counts as a sync
+func (e *MemoryEngine) reset() error {
+	e.syncMu.Lock()
+	e.syncedAt = time.Now()
+	e.syncMu.Unlock()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.docs = map[uint]memDoc{}
+	e.postings = map[string]map[uint]int{}
+	e.tokens = nil
+	e.totalLen = 0
+	return nil
+}
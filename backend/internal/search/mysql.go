@@ -0,0 +1,71 @@
+package search
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"easyblog/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// MySQLEngine uses InnoDB FULLTEXT indexes with the ngram parser, which
+// also matches parts of words. The ngram parser drops every ngram that
+// contains a stopword, so run MySQL with innodb_ft_enable_stopword=OFF.
+type MySQLEngine struct {
+	db *gorm.DB
+}
+
+func NewMySQL(db *gorm.DB) (*MySQLEngine, error) {
+	err := db.Exec(`CREATE TABLE IF NOT EXISTS post_search (
+		post_id BIGINT UNSIGNED PRIMARY KEY,
+		title TEXT NOT NULL,
+		body MEDIUMTEXT NOT NULL,
+		FULLTEXT KEY ft_post_search (title, body) WITH PARSER ngram,
+		FULLTEXT KEY ft_post_search_title (title) WITH PARSER ngram
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`).Error
+	if err != nil {
+		return nil, err
+	}
+	return &MySQLEngine{db: db}, nil
+}
+
+func (e *MySQLEngine) Name() string { return "mysql fulltext" }
+
+func (e *MySQLEngine) Index(post models.Post) error {
+	title, body := document(post)
+	return e.db.Exec("REPLACE INTO post_search (post_id, title, body) VALUES (?, ?, ?)", post.ID, title, body).Error
+}
+
+func (e *MySQLEngine) Remove(id uint) error {
+	return e.db.Exec("DELETE FROM post_search WHERE post_id = ?", id).Error
+}
+
+func (e *MySQLEngine) Search(q Query) (Result, error) {
+	parts := make([]string, len(q.Terms))
+	for i, t := range q.Terms {
+		// a phrase of ngrams matches anywhere in a token; terms shorter than
+		// an ngram need the wildcard form
+		if utf8.RuneCountInString(t.Text) < 2 {
+			parts[i] = "+" + t.Text + "*"
+		} else {
+			parts[i] = `+"` + t.Text + `"`
+		}
+	}
+	against := strings.Join(parts, " ")
+	return sqlSearch(func() *gorm.DB {
+		return e.db.Table("post_search").
+			Joins("JOIN posts ON posts.id = post_search.post_id").
+			Where("MATCH (post_search.title, post_search.body) AGAINST (? IN BOOLEAN MODE)", against)
+	}, q, "MATCH (post_search.title, post_search.body) AGAINST (? IN BOOLEAN MODE) + "+
+		"2 * MATCH (post_search.title) AGAINST (? IN BOOLEAN MODE)", against, against)
+}
+
+func (e *MySQLEngine) count() (n int64, err error) {
+	err = e.db.Raw("SELECT count(*) FROM post_search").Scan(&n).Error
+	return n, err
+}
+
+func (e *MySQLEngine) reset() error {
+	return e.db.Exec("DELETE FROM post_search").Error
+}
@@ -0,0 +1,64 @@
+package search
+
+import (
+	"strings"
+
+	"easyblog/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// PostgresEngine keeps a weighted tsvector per post under a GIN index. The
+// simple configuration is used since tokens are already normalized.
+type PostgresEngine struct {
+	db *gorm.DB
+}
+
+func NewPostgres(db *gorm.DB) (*PostgresEngine, error) {
+	err := db.Exec("CREATE TABLE IF NOT EXISTS post_search (post_id bigint PRIMARY KEY, document tsvector NOT NULL)").Error
+	if err == nil {
+		err = db.Exec("CREATE INDEX IF NOT EXISTS idx_post_search_document ON post_search USING GIN (document)").Error
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &PostgresEngine{db: db}, nil
+}
+
+func (e *PostgresEngine) Name() string { return "postgres tsvector" }
+
+func (e *PostgresEngine) Index(post models.Post) error {
+	title, body := document(post)
+	return e.db.Exec(`INSERT INTO post_search (post_id, document)
+		VALUES (?, setweight(to_tsvector('simple', ?), 'A') || setweight(to_tsvector('simple', ?), 'B'))
+		ON CONFLICT (post_id) DO UPDATE SET document = EXCLUDED.document`, post.ID, title, body).Error
+}
+
+func (e *PostgresEngine) Remove(id uint) error {
+	return e.db.Exec("DELETE FROM post_search WHERE post_id = ?", id).Error
+}
+
+func (e *PostgresEngine) Search(q Query) (Result, error) {
+	parts := make([]string, len(q.Terms))
+	for i, t := range q.Terms {
+		parts[i] = t.Text
+		if t.Prefix {
+			parts[i] += ":*"
+		}
+	}
+	query := strings.Join(parts, " & ")
+	return sqlSearch(func() *gorm.DB {
+		return e.db.Table("post_search").
+			Joins("JOIN posts ON posts.id = post_search.post_id").
+			Where("post_search.document @@ to_tsquery('simple', ?)", query)
+	}, q, "ts_rank(post_search.document, to_tsquery('simple', ?))", query)
+}
+
+func (e *PostgresEngine) count() (n int64, err error) {
+	err = e.db.Raw("SELECT count(*) FROM post_search").Scan(&n).Error
+	return n, err
+}
+
+func (e *PostgresEngine) reset() error {
+	return e.db.Exec("DELETE FROM post_search").Error
+}
@@ -0,0 +1,160 @@
+// Package search indexes posts for full-text search. Each database driver
+// has a native engine (SQLite FTS5, PostgreSQL tsvector, MySQL FULLTEXT)
+// and an in-process index is used where none is available.
+//
+// Text is tokenized in Go before it reaches any engine, so all of them agree
+// on what a word is: latin words are folded to lowercase without
+// diacritics, CJK runs are split into bigrams.
+package search
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"easyblog/internal/config"
+	"easyblog/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// ErrUnavailable is returned by engines the database cannot host
+var ErrUnavailable = errors.New("search engine unavailable")
+
+// IndexVersion changes whenever Tokens produces different tokens for the
+// same text, which rebuilds the stored indexes on the next start
+const IndexVersion = "2"
+
+// indexColumns are the post columns Index reads
+var indexColumns = []string{"id", "title", "summary", "summary_auto", "content", "updated_at"}
+
+// Engine keeps an index of posts in sync and answers ranked queries
+type Engine interface {
+	Name() string
+	Index(post models.Post) error
+	Remove(id uint) error
+	Search(q Query) (Result, error)
+
+	count() (int64, error)
+	reset() error
+}
+
+// Query is a search request. Filter narrows the posts table (status,
+// category, author...) and runs in the same database query as the match
+// where the engine allows it.
+type Query struct {
+	Terms  []Term
+	Filter func(*gorm.DB) *gorm.DB
+	Offset int
+	Limit  int
+}
+
+// Hit is a matching post, higher scores rank first
+type Hit struct {
+	PostID uint    `json:"post_id"`
+	Score  float64 `json:"score"`
+}
+
+type Result struct {
+	Total int64
+	Hits  []Hit
+}
+
+// Open builds the engine selected by server.search.engine and indexes any
+// posts it is missing
+func Open(db *gorm.DB, cfg *config.Config) (Engine, error) {
+	e, err := newEngine(db, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := catchUp(db, e); err != nil {
+		return nil, fmt.Errorf("search: build %s index: %w", e.Name(), err)
+	}
+	return e, nil
+}
+
+func newEngine(db *gorm.DB, cfg *config.Config) (Engine, error) {
+	switch cfg.Server.Search.Engine {
+	case "memory":
+		return NewMemory(db), nil
+	case "auto", "":
+	default:
+		return nil, fmt.Errorf("unsupported search engine: %s", cfg.Server.Search.Engine)
+	}
+	var (
+		e   Engine
+		err error
+	)
+	switch cfg.Database.Driver {
+	case "sqlite", "sqlite3":
+		e, err = NewSQLite(db)
+	case "postgres":
+		e, err = NewPostgres(db)
+	case "mysql":
+		e, err = NewMySQL(db)
+	default:
+		err = ErrUnavailable
+	}
+	if errors.Is(err, ErrUnavailable) {
+		hint := ""
+		if strings.HasPrefix(cfg.Database.Driver, "sqlite") {
+			hint = " (build with -tags sqlite_fts5 for fts5)"
+		}
+		log.Printf("search: no native full-text search for %s, using the in-memory index%s", cfg.Database.Driver, hint)
+		return NewMemory(db), nil
+	}
+	return e, err
+}
+
+// catchUp rebuilds the index when it does not cover every post, e.g. on first
+// start, after switching engines or changing the tokenizer, or always for the
+// in-memory index
+func catchUp(db *gorm.DB, e Engine) error {
+	var posts int64
+	if err := db.Model(&models.Post{}).Count(&posts).Error; err != nil {
+		return err
+	}
+	indexed, err := e.count()
+	if err != nil {
+		return err
+	}
+	var version models.ConfigModel
+	if err := db.Where("key = ?", "search_index_version").Limit(1).Find(&version).Error; err != nil {
+		return err
+	}
+	if indexed == posts && version.Value == IndexVersion {
+		return nil
+	}
+	if err := e.reset(); err != nil {
+		return err
+	}
+	var batch []models.Post
+	res := db.Select(indexColumns).FindInBatches(&batch, 200, func(tx *gorm.DB, _ int) error {
+		for _, p := range batch {
+			if err := e.Index(p); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if res.Error != nil {
+		return res.Error
+	}
+	version.Key, version.Value = "search_index_version", IndexVersion
+	if err := db.Save(&version).Error; err != nil {
+		return err
+	}
+	log.Printf("search: indexed %d posts with %s", posts, e.Name())
+	return nil
+}
+
+// document returns the title and body of post in index form
+func document(post models.Post) (title, body string) {
+	body = Plain(post.Content)
+	// a generated summary only repeats the content
+	if !post.SummaryAuto {
+		body = post.Summary + " " + body
+	}
+	return Document(post.Title), Document(body)
+}
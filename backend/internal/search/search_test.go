@@ -0,0 +1,177 @@
+package search
+
+import (
+	"testing"
+	"time"
+
+	"easyblog/internal/config"
+	"easyblog/internal/models"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func newTestDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, _ := db.DB()
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+	if err := db.AutoMigrate(&models.Post{}, &models.ConfigModel{}); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func openEngine(t *testing.T, db *gorm.DB, engine string) Engine {
+	cfg := &config.Config{}
+	cfg.Database.Driver = "sqlite"
+	cfg.Server.Search.Engine = engine
+	e, err := Open(db, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func found(t *testing.T, e Engine, query string) []uint {
+	res, err := e.Search(Query{Terms: Terms(query), Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []uint
+	for _, h := range res.Hits {
+		ids = append(ids, h.PostID)
+	}
+	return ids
+}
+
+func TestSearch(t *testing.T) {
+	tests := []struct {
+		name  string
+		text  string
+		query string
+		match bool
+	}{
+		{"stem plural query", "Indexing posts quickly", "indexes", true},
+		{"stem gerund query", "The index was rebuilt", "indexing", true},
+		{"stem verb forms", "She runs daily", "running", true},
+		{"stem ies", "Short stories", "story", true},
+		{"stem silent e", "Making coffee", "make", true},
+		{"partly typed word", "Indexing posts", "indexi", true},
+		{"different word", "Indexing posts", "inbox", false},
+		{"every term must match", "Indexing posts", "index comments", false},
+		{"diacritics in text", "Crème brûlée recipe", "creme brulee", true},
+		{"diacritics in query", "creme brulee recipe", "crème", true},
+		{"umlaut", "A guide to Zürich", "zurich", true},
+		{"cjk word", "东京塔是一座电波塔", "东京", true},
+		{"cjk inside a run", "東京タワーに行きました", "タワー", true},
+		{"cjk single character", "東京タワー", "京", true},
+		{"cjk no match", "東京タワー", "京都", false},
+		{"hangul", "한국어 블로그 검색", "블로그", true},
+		{"mixed scripts", "用Go语言写博客", "go 语言", true},
+	}
+	engines := []string{"memory", "auto"}
+	for _, engine := range engines {
+		for _, tt := range tests {
+			t.Run(engine+"/"+tt.name, func(t *testing.T) {
+				db := newTestDB(t)
+				post := models.Post{Slug: "p", Title: "Post", Content: tt.text}
+				if err := db.Create(&post).Error; err != nil {
+					t.Fatal(err)
+				}
+				e := openEngine(t, db, engine)
+				if engine == "auto" && e.Name() == "memory" {
+					t.Skip("go-sqlite3 built without the sqlite_fts5 tag")
+				}
+				if got := len(found(t, e, tt.query)) == 1; got != tt.match {
+					t.Errorf("%q in %q: match = %v, want %v", tt.query, tt.text, got, tt.match)
+				}
+			})
+		}
+	}
+}
+
+func TestMemorySyncsWithDatabase(t *testing.T) {
+	db := newTestDB(t)
+	e := openEngine(t, db, "memory").(*MemoryEngine)
+	// another instance or the mdport command writes to the database
+	post := models.Post{Slug: "p", Title: "Gardening", Content: "tomatoes"}
+	db.Create(&post)
+
+	steps := []struct {
+		name   string
+		change func()
+		query  string
+		want   int
+	}{
+		{"not seen before the interval", func() {}, "tomato", 0},
+		{"created", func() { e.syncedAt = time.Time{} }, "tomato", 1},
+		{"updated", func() {
+			db.Model(&post).Updates(map[string]interface{}{"content": "cucumbers", "updated_at": time.Now().Add(time.Second)})
+			e.syncedAt = time.Time{}
+		}, "cucumber", 1},
+		{"old text gone", func() {}, "tomato", 0},
+		{"deleted", func() {
+			db.Delete(&post)
+			e.syncedAt = time.Time{}
+		}, "cucumber", 0},
+	}
+	for _, s := range steps {
+		s.change()
+		if got := len(found(t, e, s.query)); got != s.want {
+			t.Fatalf("%s: %d hits for %q, want %d", s.name, got, s.query, s.want)
+		}
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	tests := []struct {
+		name  string
+		text  string
+		query string
+		want  string
+	}{
+		{"stemmed word", "Indexing & <b>", "indexes", "<mark>Indexing</mark> &amp; &lt;b&gt;"},
+		{"every form", "Short stories and a story", "story", "Short <mark>stories</mark> and a <mark>story</mark>"},
+		{"word start only", "reindex the index", "index", "reindex the <mark>index</mark>"},
+		{"diacritics", "Crème brûlée", "creme", "<mark>Crème</mark> brûlée"},
+		{"cjk", "去东京塔看看", "东京塔", "去<mark>东京塔</mark>看看"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Highlight(tt.text, Terms(tt.query)); got != tt.want {
+				t.Errorf("Highlight = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStem(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"index", "index"},
+		{"indexes", "index"},
+		{"indexed", "index"},
+		{"indexing", "index"},
+		{"running", "run"},
+		{"falling", "fall"},
+		{"classes", "class"},
+		{"status", "status"},
+		{"stories", "stori"},
+		{"story", "stori"},
+		{"string", "string"},
+		{"go", "go"},
+		{"zürich", "zürich"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			if got := string(stem([]rune(tt.in))); got != tt.want {
+				t.Errorf("stem(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,26 @@
+package search
+
+import "gorm.io/gorm"
+
+// sqlSearch runs a match built by from against the posts table. from must
+// join posts and restrict it to the matching rows; score ranks them.
+func sqlSearch(from func() *gorm.DB, q Query, score string, args ...interface{}) (Result, error) {
+	scoped := func() *gorm.DB {
+		tx := from().Where("posts.deleted_at IS NULL")
+		if q.Filter != nil {
+			tx = tx.Scopes(q.Filter)
+		}
+		return tx
+	}
+	var res Result
+	if err := scoped().Count(&res.Total).Error; err != nil {
+		return res, err
+	}
+	if res.Total == 0 {
+		return res, nil
+	}
+	err := scoped().Select("posts.id AS post_id, "+score+" AS score", args...).
+		Order("score DESC, posts.id DESC").Offset(q.Offset).Limit(q.Limit).
+		Scan(&res.Hits).Error
+	return res, err
+}
@@ -0,0 +1,72 @@
+package search
+
+import (
+	"strings"
+
+	"easyblog/internal/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// SQLiteEngine uses an FTS5 table keyed by post id. FTS5 is only compiled
+// into go-sqlite3 with the sqlite_fts5 build tag; without it Open falls
+// back to the in-memory index.
+type SQLiteEngine struct {
+	db *gorm.DB
+}
+
+func NewSQLite(db *gorm.DB) (*SQLiteEngine, error) {
+	// probe quietly, a missing module is expected without the build tag
+	probe := db.Session(&gorm.Session{Logger: logger.Default.LogMode(logger.Silent)})
+	err := probe.Exec("CREATE VIRTUAL TABLE IF NOT EXISTS post_fts USING fts5(title, body, tokenize = 'unicode61')").Error
+	if err != nil {
+		if strings.Contains(err.Error(), "no such module") {
+			return nil, ErrUnavailable
+		}
+		return nil, err
+	}
+	return &SQLiteEngine{db: db}, nil
+}
+
+func (e *SQLiteEngine) Name() string { return "sqlite fts5" }
+
+func (e *SQLiteEngine) Index(post models.Post) error {
+	title, body := document(post)
+	return e.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("DELETE FROM post_fts WHERE rowid = ?", post.ID).Error; err != nil {
+			return err
+		}
+		return tx.Exec("INSERT INTO post_fts (rowid, title, body) VALUES (?, ?, ?)", post.ID, title, body).Error
+	})
+}
+
+func (e *SQLiteEngine) Remove(id uint) error {
+	return e.db.Exec("DELETE FROM post_fts WHERE rowid = ?", id).Error
+}
+
+func (e *SQLiteEngine) Search(q Query) (Result, error) {
+	parts := make([]string, len(q.Terms))
+	for i, t := range q.Terms {
+		// tokens hold letters and digits only, quoting is enough
+		parts[i] = `"` + t.Text + `"`
+		if t.Prefix {
+			parts[i] += "*"
+		}
+	}
+	match := strings.Join(parts, " AND ")
+	return sqlSearch(func() *gorm.DB {
+		return e.db.Table("post_fts").
+			Joins("JOIN posts ON posts.id = post_fts.rowid").
+			Where("post_fts MATCH ?", match)
+	}, q, "-bm25(post_fts, 3.0, 1.0)")
+}
+
+func (e *SQLiteEngine) count() (n int64, err error) {
+	err = e.db.Raw("SELECT count(*) FROM post_fts").Scan(&n).Error
+	return n, err
+}
+
+func (e *SQLiteEngine) reset() error {
+	return e.db.Exec("DELETE FROM post_fts").Error
+}
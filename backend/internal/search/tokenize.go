@@ -0,0 +1,211 @@
+package search
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// maxTerms bounds the work a single query can cause
+const maxTerms = 16
+
+// maxTokenLen drops runs that are never words, like base64 blobs
+const maxTokenLen = 64
+
+// Term is one normalized query token. Prefix terms also match longer tokens.
+type Term struct {
+	Text   string
+	Prefix bool
+}
+
+// isCJK reports scripts written without spaces between words. They are
+// indexed as overlapping bigrams so any substring of two or more characters
+// can be found without a dictionary.
+func isCJK(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul)
+}
+
+// fold maps a rune to its lowercase form without diacritics, one rune in
+// and one out so positions in the folded text match the original
+func fold(r rune) rune {
+	if r < 0x80 {
+		return unicode.ToLower(r)
+	}
+	if isCJK(r) {
+		return r
+	}
+	for _, d := range norm.NFKD.String(string(r)) {
+		return unicode.ToLower(d)
+	}
+	return r
+}
+
+func isWord(r rune) bool {
+	return (unicode.IsLetter(r) || unicode.IsNumber(r)) && !isCJK(r)
+}
+
+// scan splits s into latin style words and CJK runs
+func scan(s string, word, cjk func([]rune)) {
+	var cur []rune
+	inCJK := false
+	flush := func() {
+		if len(cur) > 0 {
+			if inCJK {
+				cjk(cur)
+			} else if len(cur) <= maxTokenLen {
+				word(cur)
+			}
+		}
+		cur = cur[:0]
+	}
+	for _, r := range s {
+		r = fold(r)
+		switch {
+		case isCJK(r):
+			if !inCJK {
+				flush()
+				inCJK = true
+			}
+			cur = append(cur, r)
+		case isWord(r) || unicode.Is(unicode.Mn, r) && len(cur) > 0 && !inCJK:
+			if inCJK {
+				flush()
+				inCJK = false
+			}
+			if !unicode.Is(unicode.Mn, r) {
+				cur = append(cur, r)
+			}
+		default:
+			flush()
+		}
+	}
+	flush()
+}
+
+// stem strips common English inflections so the forms of a word share a
+// token: indexes, indexed and indexing all become index. It is deliberately
+// light; other languages pass through unless they happen to end alike, and
+// since both documents and queries are stemmed a rough cut stays consistent.
+func stem(w []rune) []rune {
+	if len(w) < 4 {
+		return w
+	}
+	for _, r := range w {
+		if r >= 0x80 {
+			return w
+		}
+	}
+	s := string(w)
+	switch {
+	case strings.HasSuffix(s, "sses"):
+		s = s[:len(s)-2]
+	case strings.HasSuffix(s, "ies"):
+		s = s[:len(s)-3] + "i"
+	case strings.HasSuffix(s, "es") && hasAnySuffix(s[:len(s)-2], "s", "x", "z", "ch", "sh"):
+		s = s[:len(s)-2]
+	case strings.HasSuffix(s, "s") && !hasAnySuffix(s, "ss", "us", "is"):
+		s = s[:len(s)-1]
+	}
+	for _, suffix := range []string{"ing", "ed"} {
+		if base, ok := strings.CutSuffix(s, suffix); ok && len(base) >= 3 && hasVowel(base) {
+			s = base
+			// running -> run, but falling -> fall
+			if n := len(s); s[n-1] == s[n-2] && !strings.ContainsRune("aeioulsz", rune(s[n-1])) {
+				s = s[:n-1]
+			}
+			break
+		}
+	}
+	if n := len(s); n > 3 && hasVowel(s[:n-1]) {
+		switch s[n-1] {
+		case 'y':
+			s = s[:n-1] + "i"
+		case 'e':
+			s = s[:n-1]
+		}
+	}
+	return []rune(s)
+}
+
+func hasAnySuffix(s string, suffixes ...string) bool {
+	for _, suffix := range suffixes {
+		if strings.HasSuffix(s, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasVowel(s string) bool {
+	return strings.ContainsAny(s, "aeiouy")
+}
+
+// Tokens returns the index tokens of s. A word yields its stem and, when
+// that differs, the word itself so a query typed halfway still matches. A
+// CJK run yields its bigrams and its last character, so every character
+// starts at least one token.
+func Tokens(s string) []string {
+	var out []string
+	scan(s, func(w []rune) {
+		st := string(stem(w))
+		out = append(out, st)
+		if word := string(w); word != st {
+			out = append(out, word)
+		}
+	}, func(run []rune) {
+		for i := 0; i+1 < len(run); i++ {
+			out = append(out, string(run[i:i+2]))
+		}
+		out = append(out, string(run[len(run)-1]))
+	})
+	return out
+}
+
+// Document is s as space separated tokens, the form stored in the indexes
+func Document(s string) string {
+	return strings.Join(Tokens(s), " ")
+}
+
+// Terms parses a user query. Words match as prefixes of their stem, CJK
+// runs as their bigrams, and a lone CJK character as the prefix of a token.
+func Terms(q string) []Term {
+	var out []Term
+	seen := map[string]bool{}
+	add := func(t Term) {
+		if !seen[t.Text] && len(out) < maxTerms {
+			seen[t.Text] = true
+			out = append(out, t)
+		}
+	}
+	scan(q, func(w []rune) {
+		add(Term{Text: string(stem(w)), Prefix: true})
+	}, func(run []rune) {
+		if len(run) == 1 {
+			add(Term{Text: string(run), Prefix: true})
+			return
+		}
+		for i := 0; i+1 < len(run); i++ {
+			add(Term{Text: string(run[i : i+2])})
+		}
+	})
+	return out
+}
+
+var (
+	mdFence = regexp.MustCompile("(?m)^\\s*(```|~~~).*$")
+	mdLink  = regexp.MustCompile(`!?\[([^\]]*)\]\([^)]*\)`)
+	mdTag   = regexp.MustCompile(`<[^>]+>`)
+	mdMark  = regexp.MustCompile("[#*_>~`|]+")
+	spaces  = regexp.MustCompile(`\s+`)
+)
+
+// Plain strips Markdown syntax from src, keeping the words a reader sees
+func Plain(src string) string {
+	s := mdFence.ReplaceAllString(src, " ")
+	s = mdLink.ReplaceAllString(s, "$1")
+	s = mdTag.ReplaceAllString(s, " ")
+	s = mdMark.ReplaceAllString(s, " ")
+	return strings.TrimSpace(spaces.ReplaceAllString(s, " "))
+}
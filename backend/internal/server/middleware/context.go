@@ -2,15 +2,17 @@ package middleware
 
 import (
     "easyblog/internal/config"
+    "easyblog/internal/keys"
     "github.com/gin-gonic/gin"
     "gorm.io/gorm"
 )
 
 // Injects common dependencies into request context
-func WithDeps(cfg *config.Config, db *gorm.DB) gin.HandlerFunc {
+func WithDeps(cfg *config.Config, db *gorm.DB, ks *keys.KeySet) gin.HandlerFunc {
     return func(c *gin.Context) {
         c.Set("cfg", cfg)
         c.Set("db", db)
+        c.Set("keys", ks)
         c.Next()
     }
 }
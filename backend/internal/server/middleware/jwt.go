@@ -5,12 +5,15 @@ import (
     "strings"
 
     "easyblog/internal/config"
+    "easyblog/internal/keys"
     "easyblog/internal/models"
+    "easyblog/internal/session"
     "github.com/gin-gonic/gin"
-    "github.com/golang-jwt/jwt/v5"
     "gorm.io/gorm"
 )
 
+// JWT authenticates the request with either a session access token or a
+// personal access token (ebp_ prefix)
 func JWT(cfg *config.Config) gin.HandlerFunc {
     return func(c *gin.Context) {
         authHeader := c.GetHeader("Authorization")
@@ -19,29 +22,106 @@ func JWT(cfg *config.Config) gin.HandlerFunc {
             return
         }
         tokenStr := strings.TrimPrefix(authHeader, "Bearer ")
-        token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
-            return []byte(cfg.Server.JWT.Secret), nil
-        })
-        if err != nil || !token.Valid {
-            c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
-            return
+        db, _ := c.MustGet("db").(*gorm.DB)
+        ks, _ := c.MustGet("keys").(*keys.KeySet)
+        sessions := session.NewManager(db, cfg, ks)
+
+        var userID interface{}
+        if strings.HasPrefix(tokenStr, session.AccessTokenPrefix) {
+            token, err := sessions.LookupAccessToken(tokenStr)
+            if err != nil {
+                c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
+                return
+            }
+            c.Set("token_scopes", session.TokenScopes(token))
+            userID = token.UserID
+        } else {
+            claims, err := sessions.Parse(tokenStr)
+            if err != nil {
+                c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
+                return
+            }
+            sid, ok := session.SessionID(claims)
+            if !ok {
+                c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid token claims"})
+                return
+            }
+            if !sessions.Active(sid) {
+                c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "session revoked"})
+                return
+            }
+            c.Set("session_id", sid)
+            userID = claims["sub"]
+        }
+
+        var user models.User
+        if err := db.First(&user, userID).Error; err == nil {
+            if user.Disabled {
+                c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "account disabled"})
+                return
+            }
+            if user.Pending {
+                c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "account pending approval"})
+                return
+            }
+            if mfaEnrollmentRequired(db, user) && !strings.HasPrefix(c.FullPath(), "/api/auth/") {
+                c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "two-factor enrollment required"})
+                return
+            }
+            if user.MustChangePassword && !passwordChangeRoute(c) {
+                c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "password change required", "must_change_password": true})
+                return
+            }
+            c.Set("user", user)
         }
-        claims, ok := token.Claims.(jwt.MapClaims)
-        if !ok {
-            c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid token claims"})
+        c.Next()
+    }
+}
+
+// OptionalJWT authenticates like JWT when a bearer token is sent and lets
+// anonymous requests through, for public routes that show more to some users
+func OptionalJWT(cfg *config.Config) gin.HandlerFunc {
+    auth := JWT(cfg)
+    return func(c *gin.Context) {
+        if c.GetHeader("Authorization") == "" {
+            c.Next()
             return
         }
-        // Load user if DB is available from context
-        if dbVal, exists := c.Get("db"); exists {
-            if db, ok := dbVal.(*gorm.DB); ok {
-                var user models.User
-                if err := db.First(&user, claims["sub"]).Error; err == nil {
-                    c.Set("user", user)
-                }
-            }
+        auth(c)
+    }
+}
+
+// SessionOnly rejects personal access tokens, for routes that manage
+// credentials themselves
+func SessionOnly() gin.HandlerFunc {
+    return func(c *gin.Context) {
+        if _, ok := c.Get("token_scopes"); ok {
+            c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "not allowed with an access token"})
+            return
         }
         c.Next()
     }
 }
 
+// Users holding a temporary password may only change it, sign out, and
+// read their profile to learn that they have to
+func passwordChangeRoute(c *gin.Context) bool {
+    switch c.Request.Method + " " + c.FullPath() {
+    case "PUT /api/auth/password", "POST /api/auth/logout", "POST /api/auth/logout/all", "GET /api/auth/profile":
+        return true
+    }
+    return false
+}
 
+// Admins without TOTP may only reach /api/auth routes (to enroll) while
+// the require_admin_2fa site config is on
+func mfaEnrollmentRequired(db *gorm.DB, user models.User) bool {
+    if user.Role != models.RoleAdmin || user.TOTPEnabled {
+        return false
+    }
+    var cf models.ConfigModel
+    if err := db.Where("key = ?", "require_admin_2fa").First(&cf).Error; err != nil {
+        return false
+    }
+    return cf.Value == "true"
+}
@@ -0,0 +1,23 @@
+package middleware
+
+import (
+    "net/http"
+
+    "easyblog/internal/rbac"
+    "github.com/gin-gonic/gin"
+)
+
+// RequirePermission must be chained after JWT
+func RequirePermission(perm rbac.Permission) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        if _, exists := c.Get("user"); !exists {
+            c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+            return
+        }
+        if !rbac.Allowed(c, perm) {
+            c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "permission denied", "permission": perm})
+            return
+        }
+        c.Next()
+    }
+}
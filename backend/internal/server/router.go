@@ -3,104 +3,224 @@ package server
 import (
 	"easyblog/internal/controllers/categories"
 	"easyblog/internal/controllers/friendslink"
+	"easyblog/internal/controllers/series"
 	"easyblog/internal/controllers/tags"
+	"easyblog/internal/controllers/users"
+	"easyblog/internal/rbac"
 	"net/http"
 
 	"easyblog/internal/config"
+	"easyblog/internal/controllers/admin"
 	"easyblog/internal/controllers/auth"
 	"easyblog/internal/controllers/comments"
 	cfghandler "easyblog/internal/controllers/config"
 	"easyblog/internal/controllers/posts"
+	"easyblog/internal/keys"
+	"easyblog/internal/mailer"
+	"easyblog/internal/search"
 	mw "easyblog/internal/server/middleware"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 )
 
-func NewRouter(cfg *config.Config, db *gorm.DB) *gin.Engine {
+func NewRouter(cfg *config.Config, db *gorm.DB, ks *keys.KeySet, index search.Engine, mail mailer.Mailer) *gin.Engine {
 	r := gin.Default()
-	r.Use(mw.WithDeps(cfg, db))
+	r.Use(mw.WithDeps(cfg, db, ks))
+	r.Static(cfg.Server.Upload.URLPrefix, cfg.Server.Upload.Dir)
 
 	r.GET("/health", func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{"status": "ok"})
 	})
+	// public keys for verifying access tokens, see session.AccessTokenType
+	r.GET("/.well-known/jwks.json", func(c *gin.Context) {
+		c.Header("Cache-Control", "public, max-age=300")
+		c.JSON(http.StatusOK, ks.JWKS())
+	})
 
 	api := r.Group("/api")
 	{
 		// auth routes
 		authGroup := api.Group("/auth")
 		{
-			authHandler := auth.NewHandler(db, cfg)
+			authHandler := auth.NewHandler(db, cfg, ks, mail)
+			// public
 			authGroup.POST("/register", authHandler.Register)
 			authGroup.POST("/login", authHandler.Login)
+			authGroup.POST("/login/2fa", authHandler.LoginMFA)
+			authGroup.POST("/refresh", authHandler.Refresh)
+			authGroup.POST("/verify-email", authHandler.VerifyEmail)
+			authGroup.POST("/password/forgot", authHandler.ForgotPassword)
+			authGroup.POST("/password/reset", authHandler.ResetPassword)
+			authGroup.GET("/oidc/providers", authHandler.OIDCProviders)
+			authGroup.GET("/oidc/:provider/login", authHandler.OIDCLogin)
+			authGroup.GET("/oidc/:provider/callback", authHandler.OIDCCallback)
+			// any authenticated user
+			authGroup.POST("/logout", mw.JWT(cfg), mw.SessionOnly(), authHandler.Logout)
+			authGroup.POST("/logout/all", mw.JWT(cfg), mw.SessionOnly(), authHandler.LogoutAll)
 			authGroup.GET("/profile", mw.JWT(cfg), authHandler.Profile)
+			authGroup.PUT("/profile", mw.JWT(cfg), mw.SessionOnly(), authHandler.UpdateProfile)
+			authGroup.POST("/avatar", mw.JWT(cfg), mw.SessionOnly(), authHandler.UploadAvatar)
+			authGroup.PUT("/password", mw.JWT(cfg), mw.SessionOnly(), authHandler.ChangePassword)
+			authGroup.POST("/verify-email/send", mw.JWT(cfg), authHandler.SendVerification)
+			authGroup.POST("/2fa/setup", mw.JWT(cfg), mw.SessionOnly(), authHandler.SetupTOTP)
+			authGroup.POST("/2fa/enable", mw.JWT(cfg), mw.SessionOnly(), authHandler.EnableTOTP)
+			authGroup.POST("/2fa/disable", mw.JWT(cfg), mw.SessionOnly(), authHandler.DisableTOTP)
+			authGroup.POST("/2fa/recovery-codes", mw.JWT(cfg), mw.SessionOnly(), authHandler.RegenerateRecoveryCodes)
+			// personal access tokens, managed from a web session only
+			authGroup.GET("/tokens", mw.JWT(cfg), mw.SessionOnly(), authHandler.ListTokens)
+			authGroup.POST("/tokens", mw.JWT(cfg), mw.SessionOnly(), authHandler.CreateToken)
+			authGroup.DELETE("/tokens/:id", mw.JWT(cfg), mw.SessionOnly(), authHandler.RevokeToken)
+		}
+
+		// public author profiles
+		usersGroup := api.Group("/users")
+		{
+			usersHandler := users.NewHandler(db)
+			usersGroup.GET("/:username", mw.OptionalJWT(cfg), usersHandler.Get)
 		}
 
 		// category routes
 		categoriesGroup := api.Group("/categories")
 		{
 			categoriesHandler := categories.NewHandler(db, cfg)
+			// public
 			categoriesGroup.GET("/", categoriesHandler.List)
+			categoriesGroup.GET("/slug/:slug", categoriesHandler.GetBySlug)
 			categoriesGroup.Use(mw.JWT(cfg))
-			categoriesGroup.POST("/:id", categoriesHandler.Create)
-			categoriesGroup.DELETE("/:id", categoriesHandler.Delete)
-			categoriesGroup.PUT("/:id", categoriesHandler.Update)
+			categoriesGroup.POST("/:id", mw.RequirePermission(rbac.CategoriesManage), categoriesHandler.Create)
+			categoriesGroup.DELETE("/:id", mw.RequirePermission(rbac.CategoriesManage), categoriesHandler.Delete)
+			categoriesGroup.PUT("/:id", mw.RequirePermission(rbac.CategoriesManage), categoriesHandler.Update)
 		}
 
 		// tag routes
 		tagsGroup := api.Group("/tags")
 		{
 			tagsHandler := tags.NewHandler(db, cfg)
+			// public
 			tagsGroup.GET("/", tagsHandler.List)
+			tagsGroup.GET("/slug/:slug", tagsHandler.GetBySlug)
 			tagsGroup.Use(mw.JWT(cfg))
-			tagsGroup.POST("", tagsHandler.Create)
-			tagsGroup.DELETE("/:id", tagsHandler.Delete)
-			tagsGroup.PUT("/:id", tagsHandler.Update)
+			tagsGroup.POST("", mw.RequirePermission(rbac.TagsManage), tagsHandler.Create)
+			tagsGroup.DELETE("/:id", mw.RequirePermission(rbac.TagsManage), tagsHandler.Delete)
+			tagsGroup.PUT("/:id", mw.RequirePermission(rbac.TagsManage), tagsHandler.Update)
 		}
 
 		// posts routes
 		postsGroup := api.Group("/posts")
 		{
-			postsHandler := posts.NewHandler(db)
-			postsGroup.GET("", postsHandler.List)
-			postsGroup.GET("/:id", postsHandler.Get)
-			postsGroup.GET("/category/:id", postsHandler.GetPostsByCategory)
-			postsGroup.GET("/tag/:id", postsHandler.GetPostsByTag)
+			postsHandler := posts.NewHandler(db, cfg, ks, index)
+			// public, signed in editors may list other statuses and authors see their own drafts
+			postsGroup.GET("", mw.OptionalJWT(cfg), postsHandler.List)
+			postsGroup.GET("/featured", mw.OptionalJWT(cfg), postsHandler.Featured)
+			postsGroup.GET("/:id", mw.OptionalJWT(cfg), postsHandler.Get)
+			postsGroup.GET("/slug/:slug", mw.OptionalJWT(cfg), postsHandler.GetBySlug)
+			postsGroup.GET("/:id/related", mw.OptionalJWT(cfg), postsHandler.Related)
+			postsGroup.GET("/category/:id", mw.OptionalJWT(cfg), postsHandler.GetPostsByCategory)
+			postsGroup.GET("/tag/:id", mw.OptionalJWT(cfg), postsHandler.GetPostsByTag)
+			// the token comes in a header or the query, never the path
+			postsGroup.GET("/preview", postsHandler.Preview)
+			// password protected posts, wrong guesses are throttled
+			postsGroup.POST("/:id/unlock", postsHandler.Unlock)
 			postsGroup.Use(mw.JWT(cfg))
-			postsGroup.POST("", postsHandler.Create)
-			postsGroup.PUT("/:id", postsHandler.Update)
-			postsGroup.DELETE("/:id", postsHandler.Delete)
-			postsGroup.PUT("/:id/publish", postsHandler.Publish)
+			postsGroup.GET("/mine", postsHandler.Mine)
+			postsGroup.POST("/:id/preview", mw.RequirePermission(rbac.PostsUpdate), postsHandler.CreatePreview)
+			postsGroup.POST("", mw.RequirePermission(rbac.PostsCreate), postsHandler.Create)
+			// ownership checked in handler, *_any permissions bypass it
+			postsGroup.PUT("/:id", mw.RequirePermission(rbac.PostsUpdate), postsHandler.Update)
+			postsGroup.DELETE("/:id", mw.RequirePermission(rbac.PostsDelete), postsHandler.Delete)
+			postsGroup.PUT("/:id/publish", mw.RequirePermission(rbac.PostsPublish), postsHandler.Publish)
+			// pins and features shape the front page, so they are for editors only
+			postsGroup.PUT("/:id/pin", mw.RequirePermission(rbac.PostsPublishAny), postsHandler.Pin)
+			postsGroup.PUT("/:id/feature", mw.RequirePermission(rbac.PostsPublishAny), postsHandler.Feature)
+			// Markdown files with front matter; imports publish only with posts:publish
+			postsGroup.POST("/import", mw.RequirePermission(rbac.PostsCreate), postsHandler.Import)
+			postsGroup.GET("/export", mw.RequirePermission(rbac.PostsUpdate), postsHandler.Export)
+			// revisions are visible to whoever may edit the post
+			postsGroup.GET("/:id/revisions", mw.RequirePermission(rbac.PostsUpdate), postsHandler.ListRevisions)
+			postsGroup.GET("/:id/revisions/diff", mw.RequirePermission(rbac.PostsUpdate), postsHandler.DiffRevisions)
+			postsGroup.GET("/:id/revisions/:rev", mw.RequirePermission(rbac.PostsUpdate), postsHandler.GetRevision)
+			postsGroup.POST("/:id/revisions/:rev/restore", mw.RequirePermission(rbac.PostsUpdate), postsHandler.RestoreRevision)
+		}
+
+		// series routes
+		seriesGroup := api.Group("/series")
+		{
+			seriesHandler := series.NewHandler(db, cfg)
+			// public, unpublished parts are only listed for their editors
+			seriesGroup.GET("", mw.OptionalJWT(cfg), seriesHandler.List)
+			seriesGroup.GET("/:id", mw.OptionalJWT(cfg), seriesHandler.Get)
+			seriesGroup.GET("/slug/:slug", mw.OptionalJWT(cfg), seriesHandler.GetBySlug)
+			seriesGroup.Use(mw.JWT(cfg))
+			seriesGroup.POST("", mw.RequirePermission(rbac.PostsCreate), seriesHandler.Create)
+			// ownership checked in handler, *_any permissions bypass it
+			seriesGroup.PUT("/:id", mw.RequirePermission(rbac.PostsUpdate), seriesHandler.Update)
+			seriesGroup.DELETE("/:id", mw.RequirePermission(rbac.PostsDelete), seriesHandler.Delete)
+			seriesGroup.PUT("/:id/parts", mw.RequirePermission(rbac.PostsUpdate), seriesHandler.SetParts)
+			seriesGroup.POST("/:id/parts", mw.RequirePermission(rbac.PostsUpdate), seriesHandler.AddPart)
+			seriesGroup.DELETE("/:id/parts/:post_id", mw.RequirePermission(rbac.PostsUpdate), seriesHandler.RemovePart)
 		}
 
 		// friends link
 		friendsGroup := api.Group("/friends")
 		{
 			friendsHandler := friendslink.NewHandler(db)
+			// public
 			friendsGroup.GET("", friendsHandler.List)
-			friendsGroup.Use(mw.JWT(cfg))
+			friendsGroup.Use(mw.JWT(cfg), mw.RequirePermission(rbac.FriendsManage))
 			friendsGroup.POST("", friendsHandler.Create)
 			friendsGroup.PUT("/:id", friendsHandler.Update)
 			friendsGroup.DELETE("/:id", friendsHandler.Delete)
 		}
 
 		// comments routes
-		cmHandler := comments.NewHandler(db)
-		api.GET("/posts/:id/comments", cmHandler.ListByPost)
-		api.POST("/comments", mw.JWT(cfg), cmHandler.Create)
-		api.DELETE("/comments/:id", mw.JWT(cfg), cmHandler.Delete)
+		cmHandler := comments.NewHandler(db, cfg, ks)
+		// public, as far as the post is readable
+		api.GET("/posts/:id/comments", mw.OptionalJWT(cfg), cmHandler.ListByPost)
+		api.POST("/comments", mw.JWT(cfg), mw.RequirePermission(rbac.CommentsCreate), cmHandler.Create)
+		// ownership checked in handler, comments:delete_any bypasses it
+		api.DELETE("/comments/:id", mw.JWT(cfg), mw.RequirePermission(rbac.CommentsDelete), cmHandler.Delete)
 
 		// config routes
 		configGroup := api.Group("/config")
 		{
 			configHandler := cfghandler.NewHandler(db)
+			// public
 			configGroup.GET("", configHandler.Get)
-			configGroup.Use(mw.JWT(cfg))
+			configGroup.Use(mw.JWT(cfg), mw.RequirePermission(rbac.ConfigManage))
 			configGroup.GET("/all", configHandler.List)
 			configGroup.POST("", configHandler.Create)
 			configGroup.PUT("", configHandler.Update)
 			configGroup.DELETE("", configHandler.Delete)
 		}
+
+		// admin routes
+		adminGroup := api.Group("/admin")
+		{
+			adminHandler := admin.NewHandler(db, cfg, ks)
+			adminGroup.Use(mw.JWT(cfg))
+			adminGroup.GET("/roles", mw.RequirePermission(rbac.RolesRead), adminHandler.Roles)
+			usersGroup := adminGroup.Group("/users", mw.RequirePermission(rbac.UsersManage))
+			usersGroup.GET("", adminHandler.ListUsers)
+			usersGroup.GET("/:id", adminHandler.GetUser)
+			usersGroup.PUT("/:id/role", adminHandler.UpdateRole)
+			usersGroup.PUT("/:id/disabled", adminHandler.SetDisabled)
+			usersGroup.POST("/:id/password", adminHandler.ResetPassword)
+			usersGroup.POST("/:id/logout", adminHandler.LogoutUser)
+			usersGroup.DELETE("/:id/2fa", adminHandler.ResetTOTP)
+			usersGroup.DELETE("/:id/lockout", adminHandler.Unlock)
+			usersGroup.DELETE("/:id", adminHandler.DeleteUser)
+			usersGroup.POST("/:id/approve", adminHandler.ApproveUser)
+			usersGroup.POST("/:id/reject", adminHandler.RejectUser)
+			invitesGroup := adminGroup.Group("/invites", mw.RequirePermission(rbac.UsersManage))
+			invitesGroup.GET("", adminHandler.ListInvites)
+			invitesGroup.POST("", adminHandler.CreateInvite)
+			invitesGroup.DELETE("/:id", adminHandler.DeleteInvite)
+			adminGroup.GET("/login-attempts", mw.RequirePermission(rbac.UsersManage), adminHandler.ListLoginAttempts)
+			adminGroup.DELETE("/login-attempts/:id", mw.RequirePermission(rbac.UsersManage), adminHandler.DeleteLoginAttempt)
+			adminGroup.GET("/keys", mw.RequirePermission(rbac.ConfigManage), adminHandler.ListKeys)
+			adminGroup.POST("/keys/rotate", mw.RequirePermission(rbac.ConfigManage), adminHandler.RotateKeys)
+		}
 	}
 
 	return r
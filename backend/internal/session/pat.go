@@ -0,0 +1,75 @@
+package session
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"time"
+
+	"easyblog/internal/models"
+	"easyblog/internal/rbac"
+	"easyblog/internal/utils"
+
+	"gorm.io/gorm"
+)
+
+// AccessTokenPrefix marks personal access tokens so they can be told apart from JWTs
+const AccessTokenPrefix = "ebp_"
+
+// CreateAccessToken stores a new personal access token and returns its
+// plain value, which is never retrievable again
+func (m *Manager) CreateAccessToken(user models.User, name string, scopes []rbac.Permission, expiresAt *time.Time) (string, *models.AccessToken, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", nil, err
+	}
+	plain := AccessTokenPrefix + base64.RawURLEncoding.EncodeToString(raw)
+	scopeNames := make([]string, len(scopes))
+	for i, s := range scopes {
+		scopeNames[i] = string(s)
+	}
+	token := models.AccessToken{
+		UserID:    user.ID,
+		Name:      name,
+		Prefix:    plain[:len(AccessTokenPrefix)+6],
+		TokenHash: utils.SHA256Encode(plain),
+		Scopes:    strings.Join(scopeNames, ","),
+		ExpiresAt: expiresAt,
+	}
+	if err := m.db.Create(&token).Error; err != nil {
+		return "", nil, err
+	}
+	return plain, &token, nil
+}
+
+// LookupAccessToken resolves a presented token and records its use
+func (m *Manager) LookupAccessToken(plain string) (*models.AccessToken, error) {
+	var token models.AccessToken
+	if err := m.db.Where("token_hash = ?", utils.SHA256Encode(plain)).First(&token).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidToken
+		}
+		return nil, err
+	}
+	now := time.Now()
+	if token.ExpiresAt != nil && now.After(*token.ExpiresAt) {
+		return nil, ErrTokenExpired
+	}
+	// avoid a write on every request
+	if token.LastUsedAt == nil || now.Sub(*token.LastUsedAt) > time.Minute {
+		m.db.Model(&token).UpdateColumn("last_used_at", now)
+	}
+	return &token, nil
+}
+
+// TokenScopes splits the stored scopes of a token
+func TokenScopes(token *models.AccessToken) []rbac.Permission {
+	scopes := []rbac.Permission{}
+	for _, s := range strings.Split(token.Scopes, ",") {
+		if s != "" {
+			scopes = append(scopes, rbac.Permission(s))
+		}
+	}
+	return scopes
+}
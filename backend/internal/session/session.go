@@ -0,0 +1,224 @@
+package session
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+
+	"easyblog/internal/config"
+	"easyblog/internal/keys"
+	"easyblog/internal/models"
+	"easyblog/internal/utils"
+
+	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrInvalidToken   = errors.New("invalid token")
+	ErrTokenExpired   = errors.New("token expired")
+	ErrTokenReused    = errors.New("refresh token reused")
+	ErrSessionRevoked = errors.New("session revoked")
+	ErrUserDisabled   = errors.New("account disabled")
+)
+
+type Manager struct {
+	db   *gorm.DB
+	cfg  *config.Config
+	keys *keys.KeySet
+}
+
+func NewManager(db *gorm.DB, cfg *config.Config, ks *keys.KeySet) *Manager {
+	return &Manager{db: db, cfg: cfg, keys: ks}
+}
+
+// Access tokens follow RFC 9068: services verifying them against the JWKS
+// must check typ, iss and aud so no other token signed by the same keys
+// passes for a login.
+const (
+	AccessTokenType = "at+jwt"
+	AccessAudience  = "easyblog-api"
+)
+
+// issuer is the iss claim of access tokens
+func (m *Manager) issuer() string {
+	if m.cfg.Server.JWT.Issuer == "" {
+		return "easyblog"
+	}
+	return m.cfg.Server.JWT.Issuer
+}
+
+// Pair is returned to the client after login or refresh
+type Pair struct {
+	AccessToken  string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"` // seconds
+}
+
+// Create starts a new session for user and issues the first token pair
+func (m *Manager) Create(user models.User, userAgent, ip string) (*Pair, error) {
+	var pair *Pair
+	err := m.db.Transaction(func(tx *gorm.DB) error {
+		sess := models.Session{UserID: user.ID, UserAgent: truncate(userAgent, 255), IP: ip}
+		if err := tx.Create(&sess).Error; err != nil {
+			return err
+		}
+		var err error
+		pair, err = m.issue(tx, user, sess.ID)
+		return err
+	})
+	return pair, err
+}
+
+// Refresh rotates a refresh token. Presenting a token that was already
+// rotated revokes the whole session, as it indicates the token leaked.
+func (m *Manager) Refresh(refreshToken string) (*Pair, error) {
+	var rt models.RefreshToken
+	if err := m.db.Where("token_hash = ?", utils.SHA256Encode(refreshToken)).First(&rt).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidToken
+		}
+		return nil, err
+	}
+	var sess models.Session
+	if err := m.db.First(&sess, rt.SessionID).Error; err != nil {
+		return nil, ErrInvalidToken
+	}
+	if sess.RevokedAt != nil {
+		return nil, ErrSessionRevoked
+	}
+	if rt.UsedAt != nil {
+		if err := m.Revoke(sess.ID); err != nil {
+			return nil, err
+		}
+		return nil, ErrTokenReused
+	}
+	if time.Now().After(rt.ExpiresAt) {
+		return nil, ErrTokenExpired
+	}
+	var user models.User
+	if err := m.db.First(&user, sess.UserID).Error; err != nil {
+		return nil, ErrInvalidToken
+	}
+	if user.Disabled || user.Pending {
+		return nil, ErrUserDisabled
+	}
+
+	var pair *Pair
+	err := m.db.Transaction(func(tx *gorm.DB) error {
+		// conditional update so two concurrent refreshes cannot both succeed
+		res := tx.Model(&models.RefreshToken{}).
+			Where("id = ? AND used_at IS NULL", rt.ID).
+			Update("used_at", time.Now())
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return ErrTokenReused
+		}
+		var err error
+		pair, err = m.issue(tx, user, sess.ID)
+		return err
+	})
+	if errors.Is(err, ErrTokenReused) {
+		if err := m.Revoke(sess.ID); err != nil {
+			return nil, err
+		}
+	}
+	return pair, err
+}
+
+// Revoke invalidates a session and every token issued from it
+func (m *Manager) Revoke(sessionID uint) error {
+	return m.db.Model(&models.Session{}).
+		Where("id = ? AND revoked_at IS NULL", sessionID).
+		Update("revoked_at", time.Now()).Error
+}
+
+// RevokeAll logs the user out of every session
+func (m *Manager) RevokeAll(userID uint) error {
+	return m.db.Model(&models.Session{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", time.Now()).Error
+}
+
+// RevokeOthers logs the user out of every session except keep
+func (m *Manager) RevokeOthers(userID, keep uint) error {
+	return m.db.Model(&models.Session{}).
+		Where("user_id = ? AND id <> ? AND revoked_at IS NULL", userID, keep).
+		Update("revoked_at", time.Now()).Error
+}
+
+// Active reports whether the session exists and has not been revoked
+func (m *Manager) Active(sessionID uint) bool {
+	var count int64
+	m.db.Model(&models.Session{}).Where("id = ? AND revoked_at IS NULL", sessionID).Count(&count)
+	return count > 0
+}
+
+// Parse validates an access token and returns its claims
+func (m *Manager) Parse(tokenStr string) (jwt.MapClaims, error) {
+	token, err := m.keys.Parse(tokenStr, jwt.MapClaims{},
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuer(m.issuer()),
+		jwt.WithAudience(AccessAudience),
+	)
+	if err != nil || !token.Valid || token.Header["typ"] != AccessTokenType {
+		return nil, ErrInvalidToken
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, ErrInvalidToken
+	}
+	return claims, nil
+}
+
+// SessionID extracts the sid claim of an access token
+func SessionID(claims jwt.MapClaims) (uint, bool) {
+	sid, ok := claims["sid"].(float64)
+	if !ok || sid <= 0 {
+		return 0, false
+	}
+	return uint(sid), true
+}
+
+func (m *Manager) issue(tx *gorm.DB, user models.User, sessionID uint) (*Pair, error) {
+	now := time.Now()
+	accessTTL := time.Duration(m.cfg.Server.JWT.AccessExpireMinute) * time.Minute
+	signed, err := m.keys.Sign(AccessTokenType, jwt.MapClaims{
+		"iss":   m.issuer(),
+		"aud":   AccessAudience,
+		"sub":   user.ID,
+		"sid":   sessionID,
+		"email": user.Email,
+		"role":  user.Role,
+		"iat":   now.Unix(),
+		"exp":   now.Add(accessTTL).Unix(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("sign token: %w", err)
+	}
+
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, err
+	}
+	refresh := base64.RawURLEncoding.EncodeToString(raw)
+	if err := tx.Create(&models.RefreshToken{
+		SessionID: sessionID,
+		TokenHash: utils.SHA256Encode(refresh),
+		ExpiresAt: now.Add(time.Duration(m.cfg.Server.JWT.RefreshExpireHour) * time.Hour),
+	}).Error; err != nil {
+		return nil, err
+	}
+	return &Pair{AccessToken: signed, RefreshToken: refresh, ExpiresIn: int64(accessTTL.Seconds())}, nil
+}
+
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}
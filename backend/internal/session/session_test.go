@@ -0,0 +1,92 @@
+package session
+
+import (
+	"testing"
+	"time"
+
+	"easyblog/internal/actiontoken"
+	"easyblog/internal/config"
+	"easyblog/internal/keys"
+	"easyblog/internal/models"
+
+	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func newTestManager(t *testing.T, alg string) *Manager {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, _ := db.DB()
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+	if err := db.AutoMigrate(&models.User{}, &models.Session{}, &models.RefreshToken{}, &models.SigningKey{}); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{}
+	cfg.Server.JWT.Algorithm = alg
+	cfg.Server.JWT.Secret = "test-secret"
+	cfg.Server.JWT.AccessExpireMinute = 15
+	cfg.Server.JWT.RefreshExpireHour = 1
+	ks, err := keys.Load(db, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewManager(db, cfg, ks)
+}
+
+func TestParseAcceptsOnlyAccessTokens(t *testing.T) {
+	for _, alg := range []string{keys.AlgEdDSA, keys.AlgRS256, keys.AlgHS256} {
+		m := newTestManager(t, alg)
+		user := models.User{Email: "ada@example.com", Role: models.RoleReader}
+		user.ID = 1
+		pair, err := m.Create(user, "test", "127.0.0.1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		action, err := actiontoken.Issue(m.keys, actiontoken.PurposeVerifyEmail, actiontoken.Claims{
+			RegisteredClaims: jwt.RegisteredClaims{Subject: "1"},
+		}, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		claims := func(aud, iss string) jwt.MapClaims {
+			return jwt.MapClaims{"sub": 1, "sid": 1, "aud": aud, "iss": iss, "exp": time.Now().Add(time.Hour).Unix()}
+		}
+		sign := func(typ string, c jwt.MapClaims) string {
+			s, err := m.keys.Sign(typ, c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return s
+		}
+
+		tests := []struct {
+			name  string
+			token string
+			ok    bool
+		}{
+			{"access token", pair.AccessToken, true},
+			{"action token", action, false},
+			{"missing typ", sign("", claims(AccessAudience, "easyblog")), false},
+			{"other audience", sign(AccessTokenType, claims("someone-else", "easyblog")), false},
+			{"other issuer", sign(AccessTokenType, claims(AccessAudience, "elsewhere")), false},
+		}
+		for _, tt := range tests {
+			t.Run(alg+"/"+tt.name, func(t *testing.T) {
+				_, err := m.Parse(tt.token)
+				if (err == nil) != tt.ok {
+					t.Errorf("Parse err = %v, want ok %v", err, tt.ok)
+				}
+			})
+		}
+
+		t.Run(alg+"/access token as action token", func(t *testing.T) {
+			if _, err := actiontoken.Parse(m.keys, actiontoken.PurposeVerifyEmail, pair.AccessToken); err == nil {
+				t.Error("access token accepted as action token")
+			}
+		})
+	}
+}
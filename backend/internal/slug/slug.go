@@ -0,0 +1,181 @@
+// Package slug builds URL slugs and keeps redirects from old slugs so
+// permalinks survive renames.
+package slug
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"easyblog/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/text/unicode/norm"
+	"gorm.io/gorm"
+)
+
+// MaxLen is the maximum slug length in runes
+const MaxLen = 96
+
+// Kinds of slugged records, used for the redirect table
+const (
+	KindPost     = "post"
+	KindCategory = "category"
+	KindTag      = "tag"
+	KindSeries   = "series"
+)
+
+var (
+	ErrTaken   = errors.New("slug already in use")
+	ErrInvalid = errors.New("slug must contain letters or digits")
+)
+
+// letters NFKD does not decompose into ascii
+var special = map[rune]string{
+	'ß': "ss", 'æ': "ae", 'Æ': "ae", 'œ': "oe", 'Œ': "oe", 'ø': "o", 'Ø': "o",
+	'đ': "d", 'Đ': "d", 'ł': "l", 'Ł': "l", 'þ': "th", 'Þ': "th", 'ð': "d", 'Ð': "d",
+}
+
+// Make turns s into a lowercase, dash separated slug. Latin letters are
+// folded to ascii; scripts without a dictionary free transliteration, such as
+// CJK, are kept as they are since browsers show them fine in urls. The result
+// may be empty when s has no letters or digits.
+func Make(s string) string {
+	var b strings.Builder
+	dash := false
+	emit := func(part string) {
+		if dash && b.Len() > 0 {
+			b.WriteByte('-')
+		}
+		dash = false
+		b.WriteString(part)
+	}
+	latin := false // whether the last letter was folded to ascii
+	for _, r := range norm.NFKD.String(s) {
+		switch {
+		case unicode.Is(unicode.Mn, r):
+			// accents of latin letters are dropped, while the marks of other
+			// scripts, such as the kana voicing mark, are part of the letter
+			if !latin && !dash && b.Len() > 0 {
+				b.WriteRune(r)
+			}
+		case special[r] != "":
+			emit(special[r])
+			latin = true
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			emit(string(unicode.ToLower(r)))
+			latin = r < utf8.RuneSelf || unicode.Is(unicode.Latin, r)
+		default:
+			dash = true
+		}
+	}
+	// recompose what NFKD split apart in the scripts we keep, e.g. hangul
+	out := []rune(norm.NFC.String(b.String()))
+	if len(out) > MaxLen {
+		out = out[:MaxLen]
+	}
+	return strings.Trim(string(out), "-")
+}
+
+// Unique returns base, or base with a numeric suffix, that no other row of
+// model uses. Soft deleted rows keep their slug so restoring them is safe.
+// fallback is used when base is empty.
+func Unique(db *gorm.DB, model interface{}, base, fallback string, excludeID uint) (string, error) {
+	if base == "" {
+		base = fallback
+	}
+	candidate := base
+	for i := 2; i < 1000; i++ {
+		taken, err := Taken(db, model, candidate, excludeID)
+		if err != nil {
+			return "", err
+		}
+		if !taken {
+			return candidate, nil
+		}
+		candidate = fmt.Sprintf("%s-%d", base, i)
+	}
+	return "", ErrTaken
+}
+
+// For picks the slug of a row of model: the requested one, which must be
+// free, or one derived from source
+func For(db *gorm.DB, model interface{}, requested, source, fallback string, id uint) (string, error) {
+	if requested == "" {
+		return Unique(db, model, Make(source), fallback, id)
+	}
+	s := Make(requested)
+	if s == "" {
+		return "", ErrInvalid
+	}
+	taken, err := Taken(db, model, s, id)
+	if err != nil {
+		return "", err
+	}
+	if taken {
+		return "", ErrTaken
+	}
+	return s, nil
+}
+
+// Taken reports whether another row of model uses s
+func Taken(db *gorm.DB, model interface{}, s string, excludeID uint) (bool, error) {
+	var count int64
+	err := db.Unscoped().Model(model).Where("slug = ? AND id <> ?", s, excludeID).Count(&count).Error
+	return count > 0, err
+}
+
+// Moved records that old now lives at id and claims next, which may have
+// been the old slug of another record
+func Moved(tx *gorm.DB, kind, old, next string, id uint) error {
+	if err := Claim(tx, kind, next); err != nil {
+		return err
+	}
+	if old == "" || old == next {
+		return nil
+	}
+	if err := Claim(tx, kind, old); err != nil {
+		return err
+	}
+	return tx.Create(&models.SlugRedirect{Kind: kind, OldSlug: old, TargetID: id}).Error
+}
+
+// Claim drops the redirect for s, a live slug always wins over a redirect
+func Claim(tx *gorm.DB, kind, s string) error {
+	return tx.Where("kind = ? AND old_slug = ?", kind, s).Delete(&models.SlugRedirect{}).Error
+}
+
+// Resolve looks up where an old slug moved to
+func Resolve(db *gorm.DB, kind, old string) (uint, bool) {
+	var r models.SlugRedirect
+	if err := db.Where("kind = ? AND old_slug = ?", kind, old).First(&r).Error; err != nil {
+		return 0, false
+	}
+	return r.TargetID, true
+}
+
+// Redirect answers with a permanent redirect to the current route with its
+// :slug parameter replaced by s
+func Redirect(c *gin.Context, s string) {
+	target := strings.Replace(c.FullPath(), ":slug", url.PathEscape(s), 1)
+	if q := c.Request.URL.RawQuery; q != "" {
+		target += "?" + q
+	}
+	c.Redirect(http.StatusMovedPermanently, target)
+}
+
+// WriteError answers with the status matching an error of For
+func WriteError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, ErrTaken):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrInvalid):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
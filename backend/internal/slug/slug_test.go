@@ -0,0 +1,142 @@
+package slug
+
+import (
+	"strings"
+	"testing"
+
+	"easyblog/internal/models"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestMake(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"plain", "Hello World", "hello-world"},
+		{"punctuation collapses", "  C++ & Go -- a tour!  ", "c-go-a-tour"},
+		{"accents folded", "Crème brûlée à Zürich", "creme-brulee-a-zurich"},
+		{"special latin letters", "Straße Ærø Łódź Þórr", "strasse-aero-lodz-thorr"},
+		{"compatibility forms", "ﬁnal Ｆｕｌｌ ①", "final-full-1"},
+		{"digits", "Go 1.25 released", "go-1-25-released"},
+		{"cyrillic kept", "Чайка и море", "чайка-и-море"},
+		{"kana voicing kept", "ガイド ブック", "ガイド-ブック"},
+		{"hangul recomposed", "한국어 블로그", "한국어-블로그"},
+		{"han kept", "東京タワー 2024", "東京タワー-2024"},
+		{"mixed scripts", "Café 東京", "cafe-東京"},
+		{"no letters", "!!! ??? ...", ""},
+		{"empty", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Make(tt.in); got != tt.want {
+				t.Errorf("Make(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMakeTruncates(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"runes not bytes", strings.Repeat("日", MaxLen+10), strings.Repeat("日", MaxLen)},
+		{"no trailing dash", strings.Repeat("a", MaxLen-1) + " b", strings.Repeat("a", MaxLen-1)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Make(tt.in); got != tt.want {
+				t.Errorf("Make = %q (%d runes), want %q", got, len([]rune(got)), tt.want)
+			}
+		})
+	}
+}
+
+func newTestDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, _ := db.DB()
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+	if err := db.AutoMigrate(&models.Tag{}, &models.SlugRedirect{}); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestFor(t *testing.T) {
+	db := newTestDB(t)
+	db.Create(&models.Tag{Name: "Go", Slug: "go"})
+	deleted := models.Tag{Name: "Rust", Slug: "rust"}
+	db.Create(&deleted)
+	db.Delete(&deleted)
+
+	tests := []struct {
+		name      string
+		requested string
+		source    string
+		id        uint
+		want      string
+		wantErr   error
+	}{
+		{"derived from source", "", "Web Dev", 0, "web-dev", nil},
+		{"derived gets suffix", "", "Go", 0, "go-2", nil},
+		{"soft deleted keeps slug", "", "Rust", 0, "rust-2", nil},
+		{"own slug is free", "", "Go", 1, "go", nil},
+		{"fallback", "", "???", 0, "tag-9", nil},
+		{"requested is normalized", "Hello World", "", 0, "hello-world", nil},
+		{"requested taken", "go", "", 0, "", ErrTaken},
+		{"requested invalid", "---", "", 0, "", ErrInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := For(db, &models.Tag{}, tt.requested, tt.source, "tag-9", tt.id)
+			if err != tt.wantErr || got != tt.want {
+				t.Errorf("For = %q, %v; want %q, %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMovedAndResolve(t *testing.T) {
+	db := newTestDB(t)
+	steps := []struct {
+		old, next string
+		id        uint
+	}{
+		{"first", "second", 1},
+		{"second", "third", 1},
+		// another record takes over an old slug
+		{"other", "first", 2},
+	}
+	for _, s := range steps {
+		if err := Moved(db, KindTag, s.old, s.next, s.id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		old    string
+		wantID uint
+		wantOK bool
+	}{
+		{"first", 0, false},
+		{"second", 1, true},
+		{"third", 0, false},
+		{"other", 2, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.old, func(t *testing.T) {
+			id, ok := Resolve(db, KindTag, tt.old)
+			if id != tt.wantID || ok != tt.wantOK {
+				t.Errorf("Resolve(%q) = %d, %v; want %d, %v", tt.old, id, ok, tt.wantID, tt.wantOK)
+			}
+		})
+	}
+}
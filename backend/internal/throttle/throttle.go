@@ -0,0 +1,143 @@
+// Package throttle slows down password guessing. Every failure on a key
+// doubles the wait before the next attempt; after max_failures the key is
+// locked out for lockout_minutes, doubling again on each further failure.
+// A zero backoff_seconds or lockout_minutes turns that stage off, a zero
+// max_failures never locks out.
+package throttle
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"easyblog/internal/config"
+	"easyblog/internal/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const maxDelay = 24 * time.Hour
+
+type Guard struct {
+	db  *gorm.DB
+	cfg config.LoginThrottleConfig
+}
+
+func NewGuard(db *gorm.DB, cfg config.LoginThrottleConfig) *Guard {
+	return &Guard{db: db, cfg: cfg}
+}
+
+func EmailKey(email string) string { return "email:" + strings.ToLower(email) }
+func IPKey(ip string) string       { return "ip:" + ip }
+
+// maxRetries bounds how often Reserve starts over on a key that changed
+// under it
+const maxRetries = 5
+
+// ErrContended is returned when a key keeps changing under Reserve
+var ErrContended = errors.New("throttle: too many concurrent attempts")
+
+// Reserve counts an attempt against each of keys before the caller verifies
+// it, so parallel requests cannot all pass before any failure is written. It
+// returns how long the caller has to wait when any key is still throttled, in
+// which case nothing is counted. The attempt stands as a failure unless the
+// caller releases or resets the keys after a success. When the failures
+// cannot be read the caller must not go on, or a struggling database would
+// lift the throttle.
+func (g *Guard) Reserve(keys ...string) (time.Duration, error) {
+	for i, key := range keys {
+		wait, err := g.reserve(key)
+		if err != nil || wait > 0 {
+			g.Release(keys[:i]...)
+			return wait, err
+		}
+	}
+	return 0, nil
+}
+
+func (g *Guard) reserve(key string) (time.Duration, error) {
+	for range maxRetries {
+		now := time.Now()
+		var a models.LoginAttempt
+		err := g.db.Where("subject = ?", key).First(&a).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			res := g.db.Clauses(clause.OnConflict{DoNothing: true}).
+				Create(&models.LoginAttempt{Subject: key, Failures: 1, LastFailureAt: now})
+			if res.Error != nil {
+				return 0, res.Error
+			}
+			if res.RowsAffected == 1 {
+				return 0, nil
+			}
+			// lost a race with a concurrent insert
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		if wait := a.LastFailureAt.Add(g.delay(a)).Sub(now); wait > 0 {
+			return wait, nil
+		}
+		failures := a.Failures + 1
+		if now.Sub(a.LastFailureAt) > time.Duration(g.cfg.ResetHour)*time.Hour {
+			// old failures are forgotten
+			failures = 1
+		}
+		// only one of several concurrent attempts moves the count on, the
+		// others start over and find the key throttled
+		res := g.db.Model(&models.LoginAttempt{}).
+			Where("id = ? AND failures = ?", a.ID, a.Failures).
+			Updates(map[string]interface{}{"failures": failures, "last_failure_at": now})
+		if res.Error != nil {
+			return 0, res.Error
+		}
+		if res.RowsAffected == 1 {
+			return 0, nil
+		}
+	}
+	return 0, ErrContended
+}
+
+// Release takes back the attempt Reserve counted on keys, after a success
+// that should not clear their earlier failures
+func (g *Guard) Release(keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	return g.db.Model(&models.LoginAttempt{}).
+		Where("subject IN ? AND failures > 0", keys).
+		Update("failures", gorm.Expr("failures - 1")).Error
+}
+
+// Reset clears the failure count of keys, e.g. after a successful login
+func (g *Guard) Reset(keys ...string) error {
+	return g.db.Where("subject IN ?", keys).Delete(&models.LoginAttempt{}).Error
+}
+
+func (g *Guard) delay(a models.LoginAttempt) time.Duration {
+	if a.Failures <= 0 {
+		return 0
+	}
+	maxFailures := g.cfg.MaxFailures
+	if strings.HasPrefix(a.Subject, "ip:") {
+		maxFailures = g.cfg.IPMaxFailures
+	}
+	var base time.Duration
+	var shift int
+	if maxFailures <= 0 || a.Failures < maxFailures {
+		base, shift = time.Duration(g.cfg.BackoffSeconds)*time.Second, a.Failures-1
+	} else {
+		base, shift = time.Duration(g.cfg.LockoutMinutes)*time.Minute, a.Failures-maxFailures
+	}
+	if base <= 0 {
+		// this stage is turned off
+		return 0
+	}
+	d := base << min(shift, 16)
+	if d <= 0 || d > maxDelay {
+		// overflowed or beyond the cap
+		d = maxDelay
+	}
+	return d
+}
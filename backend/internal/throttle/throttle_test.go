@@ -0,0 +1,134 @@
+package throttle
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"easyblog/internal/config"
+	"easyblog/internal/models"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestDelay(t *testing.T) {
+	defaults := config.LoginThrottleConfig{MaxFailures: 5, IPMaxFailures: 20, BackoffSeconds: 1, LockoutMinutes: 15}
+	noBackoff := defaults
+	noBackoff.BackoffSeconds = 0
+	noLockout := defaults
+	noLockout.LockoutMinutes = 0
+	neverLock := defaults
+	neverLock.MaxFailures = 0
+	tests := []struct {
+		name     string
+		cfg      config.LoginThrottleConfig
+		subject  string
+		failures int
+		want     time.Duration
+	}{
+		{"no failures", defaults, "email:a", 0, 0},
+		{"first backoff", defaults, "email:a", 1, time.Second},
+		{"backoff doubles", defaults, "email:a", 4, 8 * time.Second},
+		{"lockout", defaults, "email:a", 5, 15 * time.Minute},
+		{"lockout doubles", defaults, "email:a", 7, time.Hour},
+		{"lockout capped", defaults, "email:a", 40, maxDelay},
+		{"ip uses its own limit", defaults, "ip:1.2.3.4", 5, 16 * time.Second},
+		{"backoff disabled", noBackoff, "email:a", 3, 0},
+		{"backoff disabled still locks out", noBackoff, "email:a", 5, 15 * time.Minute},
+		{"lockout disabled", noLockout, "email:a", 9, 0},
+		{"max failures 0 never locks out", neverLock, "email:a", 9, 256 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewGuard(nil, tt.cfg)
+			got := g.delay(models.LoginAttempt{Subject: tt.subject, Failures: tt.failures})
+			if got != tt.want {
+				t.Errorf("delay = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func newTestGuard(t *testing.T, cfg config.LoginThrottleConfig) (*Guard, *gorm.DB) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, _ := db.DB()
+	// every connection would open its own empty in-memory database
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+	if err := db.AutoMigrate(&models.LoginAttempt{}); err != nil {
+		t.Fatal(err)
+	}
+	return NewGuard(db, cfg), db
+}
+
+func TestReserveFailsClosed(t *testing.T) {
+	g, db := newTestGuard(t, config.LoginThrottleConfig{MaxFailures: 5, BackoffSeconds: 60, LockoutMinutes: 15, ResetHour: 24})
+	if wait, err := g.Reserve("email:a"); err != nil || wait != 0 {
+		t.Fatalf("first Reserve = %v, %v", wait, err)
+	}
+	if wait, err := g.Reserve("email:a", "ip:1.2.3.4"); err != nil || wait <= 0 {
+		t.Fatalf("Reserve after a failure = %v, %v, want a wait", wait, err)
+	}
+	var ip int64
+	db.Model(&models.LoginAttempt{}).Where("subject = ?", "ip:1.2.3.4").Count(&ip)
+	if ip != 0 {
+		t.Errorf("a throttled attempt was counted on the other key")
+	}
+	sqlDB, _ := db.DB()
+	sqlDB.Close()
+	if _, err := g.Reserve("email:a"); err == nil {
+		t.Fatal("Reserve on a closed database returned no error")
+	}
+}
+
+func TestReserveConcurrent(t *testing.T) {
+	g, db := newTestGuard(t, config.LoginThrottleConfig{MaxFailures: 5, BackoffSeconds: 60, LockoutMinutes: 15, ResetHour: 24})
+	// one earlier failure, old enough to have waited out its backoff
+	db.Create(&models.LoginAttempt{Subject: "email:b", Failures: 1, LastFailureAt: time.Now().Add(-time.Hour)})
+	for _, key := range []string{"email:a", "email:b"} {
+		t.Run(key, func(t *testing.T) {
+			const n = 20
+			var passed atomic.Int32
+			var wg sync.WaitGroup
+			for range n {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					wait, err := g.Reserve(key)
+					if err == nil && wait == 0 {
+						passed.Add(1)
+					}
+				}()
+			}
+			wg.Wait()
+			if got := passed.Load(); got != 1 {
+				t.Errorf("%d of %d parallel attempts passed, want 1", got, n)
+			}
+		})
+	}
+}
+
+func TestRelease(t *testing.T) {
+	g, db := newTestGuard(t, config.LoginThrottleConfig{MaxFailures: 5, BackoffSeconds: 60, LockoutMinutes: 15, ResetHour: 24})
+	if wait, err := g.Reserve("email:a", "ip:1.2.3.4"); err != nil || wait != 0 {
+		t.Fatalf("Reserve = %v, %v", wait, err)
+	}
+	// a success clears the account and takes the attempt back from the ip
+	g.Reset("email:a")
+	if err := g.Release("ip:1.2.3.4"); err != nil {
+		t.Fatal(err)
+	}
+	if wait, err := g.Reserve("email:a", "ip:1.2.3.4"); err != nil || wait != 0 {
+		t.Fatalf("Reserve after a success = %v, %v, want no wait", wait, err)
+	}
+	var a models.LoginAttempt
+	db.Where("subject = ?", "ip:1.2.3.4").First(&a)
+	if a.Failures != 1 {
+		t.Errorf("ip failures = %d, want 1", a.Failures)
+	}
+}
@@ -0,0 +1,85 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA1 key of the RFC 6238 test vectors, "12345678901234567890"
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCodeRFC6238(t *testing.T) {
+	// the RFC lists 8 digit codes, 6 digit codes are their last 6 digits
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		got, err := Code(rfcSecret, Step(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("Code at %d = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+	if got, _ := Code(" "+strings.ToLower(rfcSecret)+" ", 1); got != "287082" {
+		t.Errorf("Code of a lower case secret = %s, want 287082", got)
+	}
+	if _, err := Code("not base32!", 1); err == nil {
+		t.Error("Code accepted an invalid secret")
+	}
+}
+
+func TestValidateSkew(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	step := Step(now)
+	code := func(step int64) string {
+		c, err := Code(rfcSecret, step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	tests := []struct {
+		name   string
+		code   string
+		skew   int64
+		want   int64
+		wantOK bool
+	}{
+		{"current step", code(step), 1, step, true},
+		{"previous step", code(step - 1), 1, step - 1, true},
+		{"next step", code(step + 1), 1, step + 1, true},
+		{"two steps behind", code(step - 2), 1, 0, false},
+		{"two steps ahead", code(step + 2), 1, 0, false},
+		{"previous step without skew", code(step - 1), 0, 0, false},
+		{"surrounding spaces", " " + code(step) + " ", 1, step, true},
+		{"too short", code(step)[:5], 1, 0, false},
+		{"wrong code", "000000", 1, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := Validate(rfcSecret, tt.code, now, tt.skew)
+			if ok != tt.wantOK || got != tt.want {
+				t.Errorf("Validate = %d, %v, want %d, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestProvisioningURI(t *testing.T) {
+	got := ProvisioningURI("Easy Blog", "a@example.com", rfcSecret)
+	want := "otpauth://totp/Easy%20Blog:a@example.com?algorithm=SHA1&digits=6&issuer=Easy+Blog&period=30&secret=" + rfcSecret
+	if got != want {
+		t.Errorf("ProvisioningURI = %s, want %s", got, want)
+	}
+}
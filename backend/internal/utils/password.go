@@ -0,0 +1,114 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"easyblog/internal/config"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	PasswordArgon2id = "argon2id"
+	PasswordBcrypt   = "bcrypt"
+)
+
+var ErrInvalidPasswordHash = errors.New("invalid password hash")
+
+// HashPassword hashes the client supplied password (sha256 hex) with the configured algorithm
+func HashPassword(cfg config.PasswordConfig, password string) (string, error) {
+	switch cfg.Algorithm {
+	case PasswordBcrypt:
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), bcryptCost(cfg))
+		if err != nil {
+			return "", err
+		}
+		return string(hash), nil
+	case PasswordArgon2id, "":
+		salt := make([]byte, cfg.Argon2.SaltLen)
+		if _, err := rand.Read(salt); err != nil {
+			return "", err
+		}
+		key := argon2.IDKey([]byte(password), salt, cfg.Argon2.Time, cfg.Argon2.Memory, cfg.Argon2.Threads, cfg.Argon2.KeyLen)
+		return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+			argon2.Version, cfg.Argon2.Memory, cfg.Argon2.Time, cfg.Argon2.Threads,
+			base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key),
+		), nil
+	default:
+		return "", fmt.Errorf("unsupported password algorithm: %s", cfg.Algorithm)
+	}
+}
+
+// bcryptCost is the configured bcrypt cost, costs below bcrypt.MinCost fall
+// back to the default for hashing and rehash checks alike
+func bcryptCost(cfg config.PasswordConfig) int {
+	if cfg.BcryptCost < bcrypt.MinCost {
+		return bcrypt.DefaultCost
+	}
+	return cfg.BcryptCost
+}
+
+// VerifyPassword compares password against a stored hash in constant time.
+// needsRehash reports whether the stored hash is a legacy sha256 value or
+// was produced with different settings than the current configuration.
+func VerifyPassword(cfg config.PasswordConfig, hash, password string) (ok bool, needsRehash bool, err error) {
+	switch {
+	case strings.HasPrefix(hash, "$argon2id$"):
+		var (
+			version      int
+			memory, time uint32
+			threads      uint8
+		)
+		parts := strings.Split(hash, "$")
+		if len(parts) != 6 {
+			return false, false, ErrInvalidPasswordHash
+		}
+		if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+			return false, false, ErrInvalidPasswordHash
+		}
+		if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &time, &threads); err != nil {
+			return false, false, ErrInvalidPasswordHash
+		}
+		salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+		if err != nil {
+			return false, false, ErrInvalidPasswordHash
+		}
+		key, err := base64.RawStdEncoding.DecodeString(parts[5])
+		if err != nil {
+			return false, false, ErrInvalidPasswordHash
+		}
+		other := argon2.IDKey([]byte(password), salt, time, memory, threads, uint32(len(key)))
+		if subtle.ConstantTimeCompare(key, other) != 1 {
+			return false, false, nil
+		}
+		needsRehash = cfg.Algorithm != PasswordArgon2id && cfg.Algorithm != "" ||
+			version != argon2.Version ||
+			memory != cfg.Argon2.Memory || time != cfg.Argon2.Time || threads != cfg.Argon2.Threads ||
+			uint32(len(salt)) != cfg.Argon2.SaltLen || uint32(len(key)) != cfg.Argon2.KeyLen
+		return true, needsRehash, nil
+	case strings.HasPrefix(hash, "$2"):
+		if err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)); err != nil {
+			if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+				return false, false, nil
+			}
+			return false, false, err
+		}
+		cost, err := bcrypt.Cost([]byte(hash))
+		if err != nil {
+			return false, false, err
+		}
+		return true, cfg.Algorithm != PasswordBcrypt || cost != bcryptCost(cfg), nil
+	default:
+		// legacy rows store the client sha256 hex as-is
+		if subtle.ConstantTimeCompare([]byte(hash), []byte(password)) != 1 {
+			return false, false, nil
+		}
+		return true, true, nil
+	}
+}
@@ -0,0 +1,76 @@
+package utils
+
+import (
+	"testing"
+
+	"easyblog/internal/config"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func bcryptConfig(cost int) config.PasswordConfig {
+	return config.PasswordConfig{Algorithm: PasswordBcrypt, BcryptCost: cost}
+}
+
+func TestVerifyPasswordBcryptRehash(t *testing.T) {
+	tests := []struct {
+		name       string
+		hashCost   int
+		verifyCost int
+		rehash     bool
+	}{
+		{"same cost", bcrypt.MinCost, bcrypt.MinCost, false},
+		{"cost raised", bcrypt.MinCost, bcrypt.MinCost + 1, true},
+		{"zero cost clamps on both sides", 0, 0, false},
+		{"low cost clamps on both sides", 2, 3, false},
+		{"clamped cost matches the default", 0, bcrypt.DefaultCost, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hash, err := HashPassword(bcryptConfig(tt.hashCost), "secret")
+			if err != nil {
+				t.Fatal(err)
+			}
+			ok, rehash, err := VerifyPassword(bcryptConfig(tt.verifyCost), hash, "secret")
+			if err != nil || !ok {
+				t.Fatalf("VerifyPassword = %v, %v", ok, err)
+			}
+			if rehash != tt.rehash {
+				t.Errorf("needsRehash = %v, want %v", rehash, tt.rehash)
+			}
+		})
+	}
+}
+
+func TestVerifyPassword(t *testing.T) {
+	cfg := config.PasswordConfig{Algorithm: PasswordArgon2id}
+	cfg.Argon2.Time, cfg.Argon2.Memory, cfg.Argon2.Threads = 1, 1024, 1
+	cfg.Argon2.KeyLen, cfg.Argon2.SaltLen = 32, 16
+	argon, err := HashPassword(cfg, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		hash     string
+		password string
+		ok       bool
+		rehash   bool
+	}{
+		{"argon2id match", argon, "secret", true, false},
+		{"argon2id mismatch", argon, "wrong", false, false},
+		{"legacy match", "abc123", "abc123", true, true},
+		{"legacy mismatch", "abc123", "abc124", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, rehash, err := VerifyPassword(cfg, tt.hash, tt.password)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok != tt.ok || rehash != tt.rehash {
+				t.Errorf("VerifyPassword = %v, %v, want %v, %v", ok, rehash, tt.ok, tt.rehash)
+			}
+		})
+	}
+}
@@ -1 +1 @@
-go run ./cmd/server/
\ No newline at end of file
+go run -tags sqlite_fts5 ./cmd/server/
\ No newline at end of file
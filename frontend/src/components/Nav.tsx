@@ -20,8 +20,8 @@ export default function Nav() {
   const { user, logout } = useAuth();
   const [isMenuOpen, setIsMenuOpen] = useState(false);
 
-  function onLogout() {
-    logout();
+  async function onLogout() {
+    await logout();
     nav("/");
   }
 
@@ -6,8 +6,13 @@ import type { User } from "../types";
 type AuthContextType = {
   user: User | null;
   loading: boolean;
-  login: (email: string, password: string) => Promise<void>;
-  logout: () => void;
+  // resolves to an mfa token when a second factor is required
+  login: (email: string, password: string) => Promise<string | null>;
+  loginMFA: (
+    mfaToken: string,
+    second: { code?: string; recovery_code?: string },
+  ) => Promise<void>;
+  logout: () => Promise<void>;
   refresh: () => Promise<void>;
 };
 
@@ -35,17 +40,31 @@ export function AuthProvider({ children }: { children: React.ReactNode }) {
   }, []);
 
   async function login(email: string, passwordShaHex: string) {
-    await api.login(email, passwordShaHex);
+    const res = await api.login(email, passwordShaHex);
+    if ("mfa_required" in res && res.mfa_required) {
+      return res.mfa_token;
+    }
+    await refresh();
+    return null;
+  }
+
+  async function loginMFA(
+    mfaToken: string,
+    second: { code?: string; recovery_code?: string },
+  ) {
+    await api.loginMFA(mfaToken, second);
     await refresh();
   }
 
-  function logout() {
-    api.logout();
+  async function logout() {
+    await api.logout();
     setUser(null);
   }
 
   return (
-    <AuthContext.Provider value={{ user, loading, login, logout, refresh }}>
+    <AuthContext.Provider
+      value={{ user, loading, login, loginMFA, logout, refresh }}
+    >
       {children}
     </AuthContext.Provider>
   );
@@ -1,7 +1,10 @@
-import type { Post, Comment } from "../types";
+import type { Post, PostListItem, Comment } from "../types";
 
 const BASE = import.meta.env.VITE_API_BASE ?? "/api";
 const TOKEN_KEY = "easyblog_token";
+const REFRESH_KEY = "easyblog_refresh_token";
+
+type TokenPair = { token: string; refresh_token: string; expires_in: number };
 
 function getToken() {
   return localStorage.getItem(TOKEN_KEY);
@@ -12,14 +15,74 @@ function setToken(t: string | null) {
   else localStorage.removeItem(TOKEN_KEY);
 }
 
-async function request<T>(path: string, opts: RequestInit = {}) {
+function setTokens(pair: TokenPair | null) {
+  setToken(pair?.token ?? null);
+  if (pair?.refresh_token) {
+    localStorage.setItem(REFRESH_KEY, pair.refresh_token);
+  } else {
+    localStorage.removeItem(REFRESH_KEY);
+  }
+}
+
+// refresh tokens are single use and reusing one revokes the session, so
+// concurrent 401s share a single refresh request
+let refreshing: Promise<boolean> | null = null;
+
+function refreshSession() {
+  if (!refreshing) {
+    refreshing = (async () => {
+      const refreshToken = localStorage.getItem(REFRESH_KEY);
+      if (!refreshToken) return false;
+      try {
+        const res = await fetch(`${BASE}/auth/refresh`, {
+          method: "POST",
+          headers: { "Content-Type": "application/json" },
+          body: JSON.stringify({ refresh_token: refreshToken }),
+        });
+        if (!res.ok) {
+          // another tab may have rotated the token meanwhile
+          if (
+            res.status === 401 &&
+            localStorage.getItem(REFRESH_KEY) === refreshToken
+          ) {
+            setTokens(null);
+          }
+          return false;
+        }
+        setTokens((await res.json()) as TokenPair);
+        return true;
+      } catch {
+        return false;
+      }
+    })().finally(() => {
+      refreshing = null;
+    });
+  }
+  return refreshing;
+}
+
+// request sends an API call with the stored access token; an expired token is
+// refreshed once and the call retried, unless retry is false
+async function request<T>(
+  path: string,
+  opts: RequestInit = {},
+  retry = true,
+): Promise<T> {
   const headers: Record<string, string> = {
     "Content-Type": "application/json",
   };
   const token = getToken();
   if (token) headers["Authorization"] = `Bearer ${token}`;
-  opts.headers = { ...(opts.headers || {}), ...headers };
-  const res = await fetch(`${BASE}${path}`, opts);
+  const res = await fetch(`${BASE}${path}`, {
+    ...opts,
+    headers: { ...(opts.headers || {}), ...headers },
+  });
+  if (res.status === 401 && token && retry) {
+    // a token changed by another tab or request only needs a retry
+    if (getToken() !== token || (await refreshSession())) {
+      return request<T>(path, opts, false);
+    }
+  }
   const text = await res.text();
   if (!res.ok) throw new Error(text || res.statusText);
   try {
@@ -35,7 +98,7 @@ export async function fetchPosts(page = 0, size = 10, q?: string) {
   params.set("page", String(page));
   params.set("size", String(size));
   if (q) params.set("q", q);
-  return request<{ total: number; items: Post[] }>(
+  return request<{ total: number; items: PostListItem[] }>(
     `/posts?${params.toString()}`,
   );
 }
@@ -88,11 +151,17 @@ export async function createPost(payload: any) {
 }
 
 export async function fetchPostsByCategory(id: string | number) {
-  return request<any[]>(`/posts/category/${id}`);
+  const res = await request<{ total: number; items: PostListItem[] }>(
+    `/posts/category/${id}`,
+  );
+  return res.items || [];
 }
 
 export async function fetchPostsByTag(id: string | number) {
-  return request<any[]>(`/posts/tag/${id}`);
+  const res = await request<{ total: number; items: PostListItem[] }>(
+    `/posts/tag/${id}`,
+  );
+  return res.items || [];
 }
 
 export async function updatePost(id: string | number, payload: any) {
@@ -123,13 +192,42 @@ export async function deleteFriend(id: string | number) {
 }
 
 // auth
+// accounts with two-factor authentication answer the password with an
+// mfa_token, redeemed by loginMFA
+export type LoginResult =
+  | TokenPair
+  | { mfa_required: true; mfa_token: string };
+
 export async function login(email: string, passwordSha256Hex: string) {
-  const res = await request<{ token: string }>(`/auth/login`, {
-    method: "POST",
-    body: JSON.stringify({ email, password: passwordSha256Hex }),
-  });
-  if (res && (res as any).token) {
-    setToken((res as any).token);
+  const res = await request<LoginResult>(
+    `/auth/login`,
+    {
+      method: "POST",
+      body: JSON.stringify({ email, password: passwordSha256Hex }),
+    },
+    false,
+  );
+  if (res && "token" in res && res.token) {
+    setTokens(res);
+  }
+  return res;
+}
+
+// loginMFA completes a login with either a TOTP code or a recovery code
+export async function loginMFA(
+  mfaToken: string,
+  second: { code?: string; recovery_code?: string },
+) {
+  const res = await request<TokenPair>(
+    `/auth/login/2fa`,
+    {
+      method: "POST",
+      body: JSON.stringify({ mfa_token: mfaToken, ...second }),
+    },
+    false,
+  );
+  if (res && res.token) {
+    setTokens(res);
   }
   return res;
 }
@@ -160,8 +258,16 @@ export async function profile() {
   );
 }
 
-export function logout() {
-  setToken(null);
+// logout revokes the session server side before forgetting the tokens
+export async function logout() {
+  if (getToken()) {
+    try {
+      await request(`/auth/logout`, { method: "POST" });
+    } catch {
+      // the tokens are dropped either way
+    }
+  }
+  setTokens(null);
 }
 
 export function getStoredToken() {
@@ -185,6 +291,7 @@ export default {
   fetchComments,
   postComment,
   login,
+  loginMFA,
   register,
   getConfig,
   profile,
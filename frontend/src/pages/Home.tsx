@@ -3,10 +3,10 @@ import React, { useEffect, useState } from "react";
 import { Link } from "react-router-dom";
 
 import api from "../lib/api";
-import type { Post } from "../types";
+import type { PostListItem } from "../types";
 
 export default function Home() {
-  const [posts, setPosts] = useState<Post[]>([]);
+  const [posts, setPosts] = useState<PostListItem[]>([]);
   const [total, setTotal] = useState(0);
   const [loading, setLoading] = useState(false);
 
@@ -41,12 +41,12 @@ export default function Home() {
       <div className="grid gap-6">
         {posts.map((p) => (
           <article
-            key={p.ID}
+            key={p.id}
             className="group flex items-start gap-4 rounded-lg border bg-white/60 p-4 shadow-sm hover:shadow-md dark:bg-slate-800"
           >
             <div className="flex-1">
               <Link
-                to={`/posts/${p.ID}`}
+                to={`/posts/${p.id}`}
                 className="text-xl font-semibold hover:underline"
               >
                 {p.title}
@@ -61,10 +61,10 @@ export default function Home() {
                 <div className="ml-2 flex gap-1">
                   {(p.categories || []).map((c) => (
                     <span
-                      key={(c as any).id}
+                      key={c.id}
                       className="rounded border px-2 py-0.5 text-xs"
                     >
-                      {(c as any).name}
+                      {c.name}
                     </span>
                   ))}
                 </div>
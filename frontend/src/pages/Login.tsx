@@ -1,4 +1,4 @@
-import { Lock, Mail, AlertCircle, ArrowRight } from "lucide-react";
+import { Lock, Mail, AlertCircle, ArrowRight, KeyRound } from "lucide-react";
 import React, { useState } from "react";
 import { useNavigate, Link } from "react-router-dom";
 
@@ -10,13 +10,22 @@ export default function Login() {
   const [password, setPassword] = useState("");
   const [error, setError] = useState<string | null>(null);
   const [loading, setLoading] = useState(false);
+  // set once the password was accepted and a second factor is required
+  const [mfaToken, setMfaToken] = useState<string | null>(null);
+  const [code, setCode] = useState("");
+  const [useRecovery, setUseRecovery] = useState(false);
   const nav = useNavigate();
-  const { login } = useAuth();
+  const { login, loginMFA } = useAuth();
 
   async function onSubmit(e: React.FormEvent) {
     e.preventDefault();
     setError(null);
 
+    if (mfaToken) {
+      await onSubmitCode();
+      return;
+    }
+
     // Simple validation
     if (!email || !password) {
       setError("Please fill in all fields");
@@ -31,7 +40,11 @@ export default function Login() {
     setLoading(true);
     try {
       const pwHash = await api.sha256Hex(password);
-      await login(email, pwHash);
+      const pending = await login(email, pwHash);
+      if (pending) {
+        setMfaToken(pending);
+        return;
+      }
       nav("/");
     } catch (err: any) {
       setError(
@@ -42,6 +55,40 @@ export default function Login() {
     }
   }
 
+  async function onSubmitCode() {
+    if (!mfaToken) return;
+    if (!code.trim()) {
+      setError(
+        useRecovery
+          ? "Please enter a recovery code"
+          : "Please enter the code from your authenticator app",
+      );
+      return;
+    }
+
+    setLoading(true);
+    try {
+      await loginMFA(
+        mfaToken,
+        useRecovery ? { recovery_code: code.trim() } : { code: code.trim() },
+      );
+      nav("/");
+    } catch (err: any) {
+      setError(err?.message || "Verification failed. Please try again.");
+    } finally {
+      setLoading(false);
+    }
+  }
+
+  function startOver() {
+    setMfaToken(null);
+    setCode("");
+    setUseRecovery(false);
+    setError(null);
+  }
+
+  const busyLabel = mfaToken ? "Verifying..." : "Logging in...";
+
   return (
     <div className="animate-fade-in flex min-h-[80vh] items-center justify-center px-4 py-12 sm:px-6 lg:px-8">
       <div className="w-full max-w-md">
@@ -68,53 +115,106 @@ export default function Login() {
                 </div>
               )}
 
-              <div>
-                <label
-                  htmlFor="email"
-                  className="mb-1 block text-sm font-medium text-gray-700 dark:text-gray-300"
-                >
-                  Email address
-                </label>
-                <div className="relative">
-                  <div className="pointer-events-none absolute inset-y-0 left-0 flex items-center pl-3">
-                    <Mail className="h-5 w-5 text-gray-400" />
+              {mfaToken ? (
+                <div>
+                  <label
+                    htmlFor="code"
+                    className="mb-1 block text-sm font-medium text-gray-700 dark:text-gray-300"
+                  >
+                    {useRecovery ? "Recovery code" : "Authentication code"}
+                  </label>
+                  <div className="relative">
+                    <div className="pointer-events-none absolute inset-y-0 left-0 flex items-center pl-3">
+                      <KeyRound className="h-5 w-5 text-gray-400" />
+                    </div>
+                    <input
+                      id="code"
+                      name="code"
+                      type="text"
+                      required
+                      autoFocus
+                      autoComplete="one-time-code"
+                      inputMode={useRecovery ? "text" : "numeric"}
+                      value={code}
+                      onChange={(e) => setCode(e.target.value)}
+                      className="transition-smooth block w-full rounded-lg border border-gray-300 bg-gray-50 py-3 pr-3 pl-10 shadow-sm focus:ring-2 focus:ring-blue-500 focus:outline-none dark:border-gray-700 dark:bg-gray-900"
+                      placeholder={useRecovery ? "xxxxx-xxxxx" : "123456"}
+                    />
+                  </div>
+                  <div className="mt-2 flex justify-between text-sm">
+                    <button
+                      type="button"
+                      onClick={() => {
+                        setUseRecovery(!useRecovery);
+                        setCode("");
+                        setError(null);
+                      }}
+                      className="transition-smooth font-medium text-blue-600 hover:text-blue-700 dark:text-blue-400 dark:hover:text-blue-300"
+                    >
+                      {useRecovery
+                        ? "Use authenticator app"
+                        : "Use a recovery code"}
+                    </button>
+                    <button
+                      type="button"
+                      onClick={startOver}
+                      className="transition-smooth text-gray-600 hover:text-gray-800 dark:text-gray-400 dark:hover:text-gray-200"
+                    >
+                      Back
+                    </button>
                   </div>
-                  <input
-                    id="email"
-                    name="email"
-                    type="email"
-                    required
-                    value={email}
-                    onChange={(e) => setEmail(e.target.value)}
-                    className="transition-smooth block w-full rounded-lg border border-gray-300 bg-gray-50 py-3 pr-3 pl-10 shadow-sm focus:ring-2 focus:ring-blue-500 focus:outline-none dark:border-gray-700 dark:bg-gray-900"
-                    placeholder="you@example.com"
-                  />
                 </div>
-              </div>
+              ) : (
+                <>
+                  <div>
+                    <label
+                      htmlFor="email"
+                      className="mb-1 block text-sm font-medium text-gray-700 dark:text-gray-300"
+                    >
+                      Email address
+                    </label>
+                    <div className="relative">
+                      <div className="pointer-events-none absolute inset-y-0 left-0 flex items-center pl-3">
+                        <Mail className="h-5 w-5 text-gray-400" />
+                      </div>
+                      <input
+                        id="email"
+                        name="email"
+                        type="email"
+                        required
+                        value={email}
+                        onChange={(e) => setEmail(e.target.value)}
+                        className="transition-smooth block w-full rounded-lg border border-gray-300 bg-gray-50 py-3 pr-3 pl-10 shadow-sm focus:ring-2 focus:ring-blue-500 focus:outline-none dark:border-gray-700 dark:bg-gray-900"
+                        placeholder="you@example.com"
+                      />
+                    </div>
+                  </div>
 
-              <div>
-                <label
-                  htmlFor="password"
-                  className="mb-1 block text-sm font-medium text-gray-700 dark:text-gray-300"
-                >
-                  Password
-                </label>
-                <div className="relative">
-                  <div className="pointer-events-none absolute inset-y-0 left-0 flex items-center pl-3">
-                    <Lock className="h-5 w-5 text-gray-400" />
+                  <div>
+                    <label
+                      htmlFor="password"
+                      className="mb-1 block text-sm font-medium text-gray-700 dark:text-gray-300"
+                    >
+                      Password
+                    </label>
+                    <div className="relative">
+                      <div className="pointer-events-none absolute inset-y-0 left-0 flex items-center pl-3">
+                        <Lock className="h-5 w-5 text-gray-400" />
+                      </div>
+                      <input
+                        id="password"
+                        name="password"
+                        type="password"
+                        required
+                        value={password}
+                        onChange={(e) => setPassword(e.target.value)}
+                        className="transition-smooth block w-full rounded-lg border border-gray-300 bg-gray-50 py-3 pr-3 pl-10 shadow-sm focus:ring-2 focus:ring-blue-500 focus:outline-none dark:border-gray-700 dark:bg-gray-900"
+                        placeholder="••••••••"
+                      />
+                    </div>
                   </div>
-                  <input
-                    id="password"
-                    name="password"
-                    type="password"
-                    required
-                    value={password}
-                    onChange={(e) => setPassword(e.target.value)}
-                    className="transition-smooth block w-full rounded-lg border border-gray-300 bg-gray-50 py-3 pr-3 pl-10 shadow-sm focus:ring-2 focus:ring-blue-500 focus:outline-none dark:border-gray-700 dark:bg-gray-900"
-                    placeholder="••••••••"
-                  />
-                </div>
-              </div>
+                </>
+              )}
 
               <div className="pt-2">
                 <button
@@ -123,10 +223,10 @@ export default function Login() {
                   disabled={loading}
                 >
                   {loading ? (
-                    "Logging in..."
+                    busyLabel
                   ) : (
                     <>
-                      <span>Sign in</span>
+                      <span>{mfaToken ? "Verify" : "Sign in"}</span>
                       <ArrowRight size={18} />
                     </>
                   )}
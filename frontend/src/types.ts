@@ -27,6 +27,28 @@ export interface Post {
   tags?: Tag[];
 }
 
+// compact entry returned by post listings
+export interface PostListItem {
+  id: number;
+  title: string;
+  slug: string;
+  summary?: string;
+  cover_image?: string;
+  status?: string;
+  visibility?: 'public' | 'unlisted' | 'private' | 'password';
+  author?: { id: number; username: string; avatar?: string; bio?: string };
+  categories?: { id: number; name: string; slug: string }[];
+  tags?: { id: number; name: string; slug: string }[];
+  pinned?: boolean;
+  featured?: boolean;
+  view_count?: number;
+  comment_count?: number;
+  reading_minutes?: number;
+  published_at?: string;
+  created_at?: string;
+  updated_at?: string;
+}
+
 export interface Comment {
   ID: number;
   post_id: number;